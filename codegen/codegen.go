@@ -0,0 +1,716 @@
+// Package codegen compiles a parsed unit to bytecode for a simple stack
+// machine.
+//
+// Each function gets a frame of word sized slots, holding its parameters
+// followed by its autos. Instructions take operands from the top of the
+// stack and push their result, and every expression leaves exactly one
+// value on the stack.
+package codegen
+
+import (
+	"fmt"
+	"github.com/erik/gob/parse"
+)
+
+type Op int
+
+const (
+	Push       Op = iota // Push Arg
+	PushString           // Push the address of string Arg
+	PushFunc             // Push the address of function symbol Arg
+	Pop                  // Discard the top of the stack
+	Dup                  // Push a copy of the top of the stack
+
+	Load        // Push frame slot Arg
+	Store       // Pop into frame slot Arg
+	LoadGlobal  // Push global Arg
+	StoreGlobal // Pop into global Arg
+
+	Add
+	Sub
+	Mul
+	Div
+	Mod
+	Shl
+	Shr
+	And
+	Or
+	Xor
+	Eq
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+	Neg
+	Not
+	Compl
+
+	Jump      // Continue at instruction Arg
+	JumpFalse // Pop, and continue at instruction Arg if zero
+
+	// Pop Arg arguments and the function beneath them, and push the
+	// function's return value
+	Call
+	Return // Pop the return value and leave the function
+)
+
+var opNames = [...]string{
+	Push:        "push",
+	PushString:  "pushstr",
+	PushFunc:    "pushfn",
+	Pop:         "pop",
+	Dup:         "dup",
+	Load:        "load",
+	Store:       "store",
+	LoadGlobal:  "loadg",
+	StoreGlobal: "storeg",
+	Add:         "add",
+	Sub:         "sub",
+	Mul:         "mul",
+	Div:         "div",
+	Mod:         "mod",
+	Shl:         "shl",
+	Shr:         "shr",
+	And:         "and",
+	Or:          "or",
+	Xor:         "xor",
+	Eq:          "eq",
+	Ne:          "ne",
+	Lt:          "lt",
+	Le:          "le",
+	Gt:          "gt",
+	Ge:          "ge",
+	Neg:         "neg",
+	Not:         "not",
+	Compl:       "compl",
+	Jump:        "jump",
+	JumpFalse:   "jumpf",
+	Call:        "call",
+	Return:      "ret",
+}
+
+func (o Op) String() string {
+	if o < 0 || int(o) >= len(opNames) {
+		return fmt.Sprintf("op(%d)", int(o))
+	}
+	return opNames[o]
+}
+
+// Whether the instruction uses its Arg
+func (o Op) HasArg() bool {
+	switch o {
+	case Push, PushString, PushFunc, Load, Store, LoadGlobal,
+		StoreGlobal, Jump, JumpFalse, Call:
+		return true
+	}
+	return false
+}
+
+type Instruction struct {
+	Op  Op
+	Arg int64
+}
+
+func (i Instruction) String() string {
+	if i.Op.HasArg() {
+		return fmt.Sprintf("%v %d", i.Op, i.Arg)
+	}
+	return i.Op.String()
+}
+
+type Function struct {
+	Name   string
+	Params int
+	Slots  int // Parameters and autos
+	Entry  int // Index of the first instruction
+}
+
+type Global struct {
+	Name  string
+	Value int64
+}
+
+type Program struct {
+	Code []Instruction
+
+	Funcs   []Function
+	Globals []Global
+
+	// Text of each string literal with the escapes of the unit's dialect
+	// decoded, without the terminating *e
+	Strings []string
+
+	// Names of called functions, which may be defined outside of the
+	// program
+	Symbols []string
+}
+
+// Compile the unit. Vectors, pointers and switch aren't supported yet.
+func Generate(unit parse.TranslationUnit) (Program, error) {
+	unit, err := unit.FoldSizes()
+	if err != nil {
+		return Program{}, err
+	}
+
+	g := generator{
+		config:  unit.Config,
+		funcs:   map[string]bool{},
+		globals: map[string]int{},
+		strings: map[string]int{},
+		symbols: map[string]int{},
+	}
+
+	for _, fn := range unit.Funcs {
+		g.funcs[fn.Name] = true
+	}
+
+	for _, v := range unit.Vars {
+		init, ok := v.(parse.ExternVarInitNode)
+		if !ok {
+			return Program{}, unsupported(v)
+		}
+
+		value, err := g.constant(init.Value)
+		if err != nil {
+			return Program{}, err
+		}
+
+		g.globals[init.Name] = len(g.prog.Globals)
+		g.prog.Globals = append(g.prog.Globals, Global{init.Name, value})
+	}
+
+	for _, fn := range unit.Funcs {
+		if err := g.function(fn); err != nil {
+			return Program{}, fmt.Errorf("%s: %v", fn.Name, err)
+		}
+	}
+
+	return g.prog, nil
+}
+
+type generator struct {
+	prog   Program
+	config parse.Config
+
+	funcs   map[string]bool
+	globals map[string]int
+	strings map[string]int
+	symbols map[string]int
+
+	// State of the function being generated
+	slots  map[string]int
+	labels map[string]int
+	gotos  map[int]string // Jump instruction to its label
+	breaks [][]int        // Jumps out of each enclosing loop
+	nexts  [][]int        // Jumps to the step of each enclosing loop
+
+	// Variables the function declares extrn but the program doesn't define
+	externs map[string]bool
+}
+
+func (g *generator) function(fn parse.FunctionNode) error {
+	g.slots = map[string]int{}
+	g.externs = g.externalVariables(fn)
+	g.labels = map[string]int{}
+	g.gotos = map[int]string{}
+	g.breaks = nil
+	g.nexts = nil
+
+	for _, param := range fn.Params {
+		g.slots[param] = len(g.slots)
+	}
+
+	for _, local := range fn.Locals() {
+		if local.VecDecl {
+			return fmt.Errorf("auto vector %s not supported", local.Name)
+		}
+		if _, ok := g.slots[local.Name]; !ok {
+			g.slots[local.Name] = len(g.slots)
+		}
+	}
+
+	g.prog.Funcs = append(g.prog.Funcs, Function{
+		Name:   fn.Name,
+		Params: len(fn.Params),
+		Slots:  len(g.slots),
+		Entry:  len(g.prog.Code),
+	})
+
+	if err := g.statement(fn.Body); err != nil {
+		return err
+	}
+
+	// Falling off the end returns 0
+	g.emit(Push, 0)
+	g.emit(Return, 0)
+
+	for jump, label := range g.gotos {
+		target, ok := g.labels[label]
+		if !ok {
+			return fmt.Errorf("undefined label %s", label)
+		}
+		g.prog.Code[jump].Arg = int64(target)
+	}
+
+	return nil
+}
+
+// Names fn declares extrn which the program doesn't define. Functions
+// defined elsewhere are called by symbol, but a variable there can't be
+// reached.
+func (g *generator) externalVariables(fn parse.FunctionNode) map[string]bool {
+	builtins := g.config.Builtins
+	if builtins == nil {
+		builtins = parse.Builtins
+	}
+
+	externs := map[string]bool{}
+	parse.Walk(fn.Body, func(n parse.Node) bool {
+		if decl, ok := n.(parse.ExternVarDeclNode); ok {
+			for _, name := range decl.Names {
+				_, global := g.globals[name]
+				if !global && !g.funcs[name] && !builtins[name] {
+					externs[name] = true
+				}
+			}
+		}
+		return true
+	})
+
+	return externs
+}
+
+func (g *generator) statement(node parse.Node) error {
+	switch n := node.(type) {
+	case parse.BlockNode:
+		for _, stmt := range n.Nodes {
+			if err := g.statement(stmt); err != nil {
+				return err
+			}
+		}
+
+	case parse.StatementNode:
+		if err := g.expression(n.Expr); err != nil {
+			return err
+		}
+		g.emit(Pop, 0)
+
+	case parse.NullNode, parse.VarDeclNode, parse.ExternVarDeclNode:
+		// Autos are given slots up front, and extrn only declares
+
+	case parse.ReturnNode:
+		if _, ok := n.Node.(parse.NullNode); ok {
+			g.emit(Push, 0)
+		} else if err := g.expression(n.Node); err != nil {
+			return err
+		}
+		g.emit(Return, 0)
+
+	case parse.IfNode:
+		if err := g.expression(n.Cond); err != nil {
+			return err
+		}
+		skip := g.emit(JumpFalse, 0)
+
+		if err := g.statement(n.Body); err != nil {
+			return err
+		}
+
+		if n.HasElse {
+			end := g.emit(Jump, 0)
+			g.patch(skip)
+
+			if err := g.statement(n.ElseBody); err != nil {
+				return err
+			}
+			g.patch(end)
+		} else {
+			g.patch(skip)
+		}
+
+	case parse.WhileNode:
+		return g.loop(parse.NullNode{}, n.Cond, parse.NullNode{}, n.Body)
+
+	case parse.ForNode:
+		return g.loop(n.Init, n.Cond, n.Step, n.Body)
+
+	case parse.BreakNode:
+		if len(g.breaks) == 0 {
+			return fmt.Errorf("break outside of a loop")
+		}
+
+		inner := len(g.breaks) - 1
+		g.breaks[inner] = append(g.breaks[inner], g.emit(Jump, 0))
+
+	case parse.ContinueNode:
+		if len(g.nexts) == 0 {
+			return fmt.Errorf("continue outside of a loop")
+		}
+
+		inner := len(g.nexts) - 1
+		g.nexts[inner] = append(g.nexts[inner], g.emit(Jump, 0))
+
+	case parse.LabelNode:
+		g.labels[n.Name] = len(g.prog.Code)
+
+	case parse.GotoNode:
+		name, ok := n.Name()
+		if !ok {
+			return unsupported(node)
+		}
+
+		g.gotos[g.emit(Jump, 0)] = name
+
+	default:
+		if parse.IsStatement(node) {
+			return unsupported(node)
+		}
+
+		// Bare expression
+		if err := g.expression(node); err != nil {
+			return err
+		}
+		g.emit(Pop, 0)
+	}
+
+	return nil
+}
+
+// Generate a loop, where init, cond and step may each be a NullNode
+func (g *generator) loop(init, cond, step, body parse.Node) error {
+	if err := g.discarded(init); err != nil {
+		return err
+	}
+
+	start := len(g.prog.Code)
+	exit := -1
+
+	if _, ok := cond.(parse.NullNode); !ok {
+		if err := g.expression(cond); err != nil {
+			return err
+		}
+		exit = g.emit(JumpFalse, 0)
+	}
+
+	g.breaks = append(g.breaks, nil)
+	g.nexts = append(g.nexts, nil)
+
+	if err := g.statement(body); err != nil {
+		return err
+	}
+
+	inner := len(g.nexts) - 1
+	for _, jump := range g.nexts[inner] {
+		g.patch(jump)
+	}
+	g.nexts = g.nexts[:inner]
+
+	if err := g.discarded(step); err != nil {
+		return err
+	}
+
+	g.emit(Jump, int64(start))
+
+	if exit >= 0 {
+		g.patch(exit)
+	}
+
+	inner = len(g.breaks) - 1
+	for _, jump := range g.breaks[inner] {
+		g.patch(jump)
+	}
+	g.breaks = g.breaks[:inner]
+
+	return nil
+}
+
+// Generate an expression for its side effects, if it isn't a NullNode
+func (g *generator) discarded(node parse.Node) error {
+	if _, ok := node.(parse.NullNode); ok {
+		return nil
+	}
+
+	if err := g.expression(node); err != nil {
+		return err
+	}
+	g.emit(Pop, 0)
+
+	return nil
+}
+
+var binaryOps = map[string]Op{
+	"+":  Add,
+	"-":  Sub,
+	"*":  Mul,
+	"/":  Div,
+	"%":  Mod,
+	"<<": Shl,
+	">>": Shr,
+	"&":  And,
+	"|":  Or,
+	"^":  Xor,
+	"==": Eq,
+	"!=": Ne,
+	"<":  Lt,
+	"<=": Le,
+	">":  Gt,
+	">=": Ge,
+}
+
+func (g *generator) expression(node parse.Node) error {
+	switch n := node.(type) {
+	case parse.IntegerNode, parse.CharacterNode:
+		value, err := g.constant(n)
+		if err != nil {
+			return err
+		}
+		g.emit(Push, value)
+
+	case parse.StringNode:
+		idx, ok := g.strings[n.Value]
+		if !ok {
+			str, err := g.config.Unescape(n.Value)
+			if err != nil {
+				return err
+			}
+
+			idx = len(g.prog.Strings)
+			g.strings[n.Value] = idx
+			g.prog.Strings = append(g.prog.Strings, str)
+		}
+		g.emit(PushString, int64(idx))
+
+	case parse.IdentNode:
+		if slot, ok := g.slots[n.Value]; ok {
+			g.emit(Load, int64(slot))
+		} else if global, ok := g.globals[n.Value]; ok {
+			g.emit(LoadGlobal, int64(global))
+		} else if g.externs[n.Value] {
+			return fmt.Errorf("external variable %s not supported", n.Value)
+		} else {
+			g.emit(PushFunc, int64(g.symbol(n.Value)))
+		}
+
+	case parse.ParenNode:
+		return g.expression(n.Node)
+
+	case parse.CommaNode:
+		// Only the last value is kept
+		for i, expr := range n.Exprs {
+			if i != 0 {
+				g.emit(Pop, 0)
+			}
+			if err := g.expression(expr); err != nil {
+				return err
+			}
+		}
+
+	case parse.AssignNode:
+		return g.assign(n)
+
+	case parse.BinaryNode:
+		op, ok := binaryOps[n.Oper]
+		if !ok {
+			return unsupported(node)
+		}
+
+		if err := g.expression(n.Left); err != nil {
+			return err
+		}
+		if err := g.expression(n.Right); err != nil {
+			return err
+		}
+		g.emit(op, 0)
+
+	case parse.UnaryNode:
+		switch n.Oper {
+		case "-":
+			if err := g.expression(n.Node); err != nil {
+				return err
+			}
+			g.emit(Neg, 0)
+		case "!":
+			if err := g.expression(n.Node); err != nil {
+				return err
+			}
+			g.emit(Not, 0)
+		case "~":
+			if err := g.expression(n.Node); err != nil {
+				return err
+			}
+			g.emit(Compl, 0)
+		case "++", "--":
+			return g.increment(n)
+		default:
+			return unsupported(node)
+		}
+
+	case parse.TernaryNode:
+		if err := g.expression(n.Cond); err != nil {
+			return err
+		}
+
+		// Keep the condition as the value when the true branch is
+		// left out, dropping it otherwise
+		if n.Elvis() {
+			g.emit(Dup, 0)
+		}
+		skip := g.emit(JumpFalse, 0)
+
+		if !n.Elvis() {
+			if err := g.expression(n.TrueBody); err != nil {
+				return err
+			}
+		}
+		end := g.emit(Jump, 0)
+		g.patch(skip)
+
+		if n.Elvis() {
+			g.emit(Pop, 0)
+		}
+		if err := g.expression(n.FalseBody); err != nil {
+			return err
+		}
+		g.patch(end)
+
+	case parse.FunctionCallNode:
+		if err := g.expression(n.Callable); err != nil {
+			return err
+		}
+
+		for _, arg := range n.Args {
+			if err := g.expression(arg); err != nil {
+				return err
+			}
+		}
+		g.emit(Call, int64(len(n.Args)))
+
+	default:
+		return unsupported(node)
+	}
+
+	return nil
+}
+
+// Generate `a = b` or a compound assignment such as `a =+ b`, leaving
+// the assigned value on the stack
+func (g *generator) assign(n parse.AssignNode) error {
+	load, store, slot, err := g.variable(n.Lhs)
+	if err != nil {
+		return err
+	}
+
+	if n.Op == "=" {
+		if err := g.expression(n.Rhs); err != nil {
+			return err
+		}
+	} else {
+		op, ok := binaryOps[n.Op[1:]]
+		if !ok {
+			return unsupported(n)
+		}
+
+		g.emit(load, slot)
+		if err := g.expression(n.Rhs); err != nil {
+			return err
+		}
+		g.emit(op, 0)
+	}
+
+	g.emit(Dup, 0)
+	g.emit(store, slot)
+
+	return nil
+}
+
+// Generate a prefix or postfix ++ or --, leaving the new or old value
+// on the stack respectively
+func (g *generator) increment(n parse.UnaryNode) error {
+	load, store, slot, err := g.variable(n.Node)
+	if err != nil {
+		return err
+	}
+
+	op := Add
+	if n.Oper == "--" {
+		op = Sub
+	}
+
+	g.emit(load, slot)
+	if n.Postfix {
+		g.emit(Dup, 0)
+	}
+
+	g.emit(Push, 1)
+	g.emit(op, 0)
+
+	if !n.Postfix {
+		g.emit(Dup, 0)
+	}
+	g.emit(store, slot)
+
+	return nil
+}
+
+// Instructions to load and store an assignable expression
+func (g *generator) variable(node parse.Node) (Op, Op, int64, error) {
+	if paren, ok := node.(parse.ParenNode); ok {
+		return g.variable(paren.Node)
+	}
+
+	ident, ok := node.(parse.IdentNode)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("can't assign to %v", node)
+	}
+
+	if slot, ok := g.slots[ident.Value]; ok {
+		return Load, Store, int64(slot), nil
+	} else if global, ok := g.globals[ident.Value]; ok {
+		return LoadGlobal, StoreGlobal, int64(global), nil
+	} else if g.externs[ident.Value] {
+		return 0, 0, 0, fmt.Errorf("external variable %s not supported", ident.Value)
+	}
+
+	return 0, 0, 0, fmt.Errorf("can't assign to %s", ident.Value)
+}
+
+func (g *generator) symbol(name string) int {
+	idx, ok := g.symbols[name]
+	if !ok {
+		idx = len(g.prog.Symbols)
+		g.symbols[name] = idx
+		g.prog.Symbols = append(g.prog.Symbols, name)
+	}
+
+	return idx
+}
+
+// Append an instruction, returning its index
+func (g *generator) emit(op Op, arg int64) int {
+	g.prog.Code = append(g.prog.Code, Instruction{op, arg})
+	return len(g.prog.Code) - 1
+}
+
+// Point the jump at idx to the next instruction
+func (g *generator) patch(idx int) {
+	g.prog.Code[idx].Arg = int64(len(g.prog.Code))
+}
+
+// Value of an integer or character constant, with the characters packed
+// into a word as the unit's dialect decodes them
+func (g *generator) constant(node parse.Node) (int64, error) {
+	switch n := node.(type) {
+	case parse.IntegerNode:
+		return n.Value, nil
+	case parse.CharacterNode:
+		value, err := n.IntWithConfig(g.config)
+		return int64(value), err
+	}
+
+	return 0, unsupported(node)
+}
+
+func unsupported(node parse.Node) error {
+	return fmt.Errorf("%T not supported: %v", node, node)
+}
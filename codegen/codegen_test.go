@@ -0,0 +1,338 @@
+package codegen
+
+import (
+	"github.com/erik/gob/parse"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func generate(t *testing.T, src string) Program {
+	unit, err := parse.NewParser("", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	prog, err := Generate(unit)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	return prog
+}
+
+func TestGenerateArithmetic(t *testing.T) {
+	prog := generate(t, "f() return(2 + 3 * 4);")
+
+	expected := []Instruction{
+		{Push, 2},
+		{Push, 3},
+		{Push, 4},
+		{Mul, 0},
+		{Add, 0},
+		{Return, 0},
+		{Push, 0},
+		{Return, 0},
+	}
+
+	if !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, got %v", expected, prog.Code)
+	}
+
+	if fn := prog.Funcs[0]; fn.Name != "f" || fn.Entry != 0 {
+		t.Errorf("Function: %+v", fn)
+	}
+}
+
+// Run the named function of a program, which may only call functions it
+// defines
+func run(t *testing.T, prog Program, name string, args ...int64) int64 {
+	funcs := map[string]Function{}
+	for _, fn := range prog.Funcs {
+		funcs[fn.Name] = fn
+	}
+
+	globals := make([]int64, len(prog.Globals))
+	for i, global := range prog.Globals {
+		globals[i] = global.Value
+	}
+
+	var call func(fn Function, args []int64) int64
+	call = func(fn Function, args []int64) int64 {
+		frame := make([]int64, fn.Slots)
+		copy(frame, args)
+
+		var stack []int64
+		pop := func() int64 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			return top
+		}
+
+		bool_ := func(b bool) int64 {
+			if b {
+				return 1
+			}
+			return 0
+		}
+
+		for pc := fn.Entry; ; pc++ {
+			in := prog.Code[pc]
+
+			switch in.Op {
+			case Push, PushFunc:
+				stack = append(stack, in.Arg)
+			case Pop:
+				pop()
+			case Dup:
+				stack = append(stack, stack[len(stack)-1])
+			case Load:
+				stack = append(stack, frame[in.Arg])
+			case Store:
+				frame[in.Arg] = pop()
+			case LoadGlobal:
+				stack = append(stack, globals[in.Arg])
+			case StoreGlobal:
+				globals[in.Arg] = pop()
+			case Jump:
+				pc = int(in.Arg) - 1
+			case JumpFalse:
+				if pop() == 0 {
+					pc = int(in.Arg) - 1
+				}
+			case Call:
+				callArgs := make([]int64, in.Arg)
+				for i := len(callArgs) - 1; i >= 0; i-- {
+					callArgs[i] = pop()
+				}
+				callee := funcs[prog.Symbols[pop()]]
+				stack = append(stack, call(callee, callArgs))
+			case Return:
+				return pop()
+			case Neg:
+				stack = append(stack, -pop())
+			case Not:
+				stack = append(stack, bool_(pop() == 0))
+			default:
+				b, a := pop(), pop()
+				switch in.Op {
+				case Add:
+					stack = append(stack, a+b)
+				case Sub:
+					stack = append(stack, a-b)
+				case Mul:
+					stack = append(stack, a*b)
+				case Lt:
+					stack = append(stack, bool_(a < b))
+				case Eq:
+					stack = append(stack, bool_(a == b))
+				default:
+					t.Fatalf("Unexpected instruction %v", in)
+				}
+			}
+		}
+	}
+
+	return call(funcs[name], args)
+}
+
+func TestGenerateControlFlow(t *testing.T) {
+	prog := generate(t, `
+count 0;
+
+fact(n) {
+  if (n < 2)
+    return (1);
+  return (n * fact(n - 1));
+}
+
+sum(n) {
+  auto i, total;
+  i = total = 0;
+  while (1) {
+    if (i == n) break;
+    (total) =+ ++(i);
+  }
+  return (total);
+}
+
+skip(n) {
+  count++;
+  if (n) goto out;
+  count = 100;
+out:
+  return (count);
+}
+`)
+
+	var tests = []struct {
+		name     string
+		arg      int64
+		expected int64
+	}{
+		{"fact", 5, 120},
+		{"sum", 10, 55},
+		{"skip", 1, 1},
+		{"skip", 0, 100},
+	}
+
+	for _, test := range tests {
+		if result := run(t, prog, test.name, test.arg); result != test.expected {
+			t.Errorf("%s(%d): expected %d, got %d", test.name, test.arg,
+				test.expected, result)
+		}
+	}
+}
+
+func TestGenerateContinue(t *testing.T) {
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+skip2(n) {
+  auto i, total;
+  total = 0;
+  for (i = 0; i < n; i++) {
+    if (i == 2) continue;
+    total =+ i;
+  }
+  return (total);
+}`), parse.ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	prog, err := Generate(unit)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if result := run(t, prog, "skip2", 10); result != 43 {
+		t.Errorf("Expected 43, got %d", result)
+	}
+}
+
+func TestGenerateElvis(t *testing.T) {
+	config := parse.ClassicConfig
+	config.AllowElvis = true
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+pick(a, b) return (a ?: b);
+`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	prog, err := Generate(unit)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if result := run(t, prog, "pick", 3, 7); result != 3 {
+		t.Errorf("Expected 3, got %d", result)
+	}
+	if result := run(t, prog, "pick", 0, 7); result != 7 {
+		t.Errorf("Expected 7, got %d", result)
+	}
+}
+
+func TestGenerateCommaExpressions(t *testing.T) {
+	config := parse.ClassicConfig
+	config.CommaExpressions = true
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+last(a, b) {
+  a =+ 1, b =+ 2;
+  return ((a, b));
+}`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	prog, err := Generate(unit)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if result := run(t, prog, "last", 1, 5); result != 7 {
+		t.Errorf("Expected 7, got %d", result)
+	}
+}
+
+func TestGenerateStrings(t *testing.T) {
+	prog := generate(t, `f() { puts("hi*n"); puts("hi*n"); puts("bye"); }`)
+
+	if !reflect.DeepEqual(prog.Strings, []string{"hi\n", "bye"}) {
+		t.Errorf("Strings: %v", prog.Strings)
+	}
+
+	if !reflect.DeepEqual(prog.Symbols, []string{"puts"}) {
+		t.Errorf("Symbols: %v", prog.Symbols)
+	}
+}
+
+func TestGenerateCharacters(t *testing.T) {
+	prog := generate(t, `
+nl '*n';
+f() { extrn nl; return ('ab' + nl); }
+g() { putchar('a'); }
+`)
+
+	if result := run(t, prog, "f"); result != 'a'<<8|'b'+'\n' {
+		t.Errorf("Expected %d, got %d", 'a'<<8|'b'+'\n', result)
+	}
+
+	if !reflect.DeepEqual(prog.Symbols, []string{"putchar"}) {
+		t.Errorf("Symbols: %v", prog.Symbols)
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	unit, err := parse.NewParser("", strings.NewReader("f() { auto v; v = &v; }")).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if _, err := Generate(unit); err == nil ||
+		!strings.Contains(err.Error(), "f: parse.UnaryNode not supported: &v") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+
+	// A variable defined outside of the program isn't taken for a
+	// function, though functions defined elsewhere can still be called
+	for _, src := range []string{
+		"f() { extrn count; return (count); }",
+		"f() { extrn count; count = 1; }",
+	} {
+		unit, err := parse.NewParser("", strings.NewReader(src)).Parse()
+		if err != nil {
+			t.Fatalf("Failed to parse: %v", err)
+		}
+
+		if _, err := Generate(unit); err == nil ||
+			err.Error() != "f: external variable count not supported" {
+			t.Errorf("%s: expected an error, got %v", src, err)
+		}
+	}
+
+	unit, err = parse.NewParser("", strings.NewReader(
+		"n 1; g() return (2); f() { extrn n, g, putchar; putchar(g() + n); }")).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if _, err := Generate(unit); err != nil {
+		t.Errorf("Expected extrn of globals and functions to compile, got %v", err)
+	}
+}
+
+func TestGenerateSize(t *testing.T) {
+	unit, err := parse.NewParserWithConfig("",
+		strings.NewReader("f(n) { return (size(n)); }"), parse.ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	// Folded as in every backend, rather than called
+	if _, err := Generate(unit); err == nil ||
+		!strings.Contains(err.Error(), "size of n, which isn't a vector") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
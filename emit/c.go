@@ -11,13 +11,43 @@ import (
 type CEmitter struct {
 	writer *bufio.Writer
 	indent int
+
+	// Names which are variables rather than functions in the function
+	// being emitted: globals, parameters, autos and extrns the unit
+	// doesn't define as functions
+	vars map[string]bool
+
+	// Of those, the ones declared as C arrays, which decay to pointers
+	// and have to be turned back into words when used as values
+	vecs map[string]bool
+
+	// Index of the static array holding each string literal, by the
+	// literal as written
+	strings map[string]int
+
+	// Assignments setting up the globals whose initial values aren't
+	// constant in C, such as the word address of a string
+	inits []string
+
+	unit parse.TranslationUnit
 }
 
 func (c CEmitter) Emit(writer io.Writer, unit parse.TranslationUnit) error {
+	unit, err := unit.FoldSizes()
+	if err != nil {
+		return err
+	}
+
 	c.writer = bufio.NewWriter(writer)
 	c.indent = 0
+	c.vars = map[string]bool{}
+	c.vecs = map[string]bool{}
+	c.strings = map[string]int{}
+	c.inits = nil
+	c.unit = unit
 
 	c.EmitHeaders(unit)
+	c.EmitStrings(unit)
 
 	c.EmitLine("\n/* Global variables */")
 
@@ -25,6 +55,8 @@ func (c CEmitter) Emit(writer io.Writer, unit parse.TranslationUnit) error {
 		c.EmitGlobal(v)
 	}
 
+	c.EmitInits()
+
 	c.EmitLine("\n/* Function prototypes */")
 
 	for _, f := range unit.Funcs {
@@ -33,7 +65,39 @@ func (c CEmitter) Emit(writer io.Writer, unit parse.TranslationUnit) error {
 
 	c.EmitLine("\n/* Function definitions */")
 
+	globals, globalVecs := c.vars, c.vecs
+
 	for _, f := range unit.Funcs {
+		c.vars = map[string]bool{}
+		c.vecs = map[string]bool{}
+		for name := range globals {
+			c.vars[name] = true
+			c.vecs[name] = globalVecs[name]
+		}
+
+		for _, param := range f.Params {
+			c.vars[param] = true
+			c.vecs[param] = false
+		}
+
+		for _, local := range f.Locals() {
+			c.vars[local.Name] = true
+			c.vecs[local.Name] = local.VecDecl
+		}
+
+		// An extrn which isn't one of the unit's functions or a builtin
+		// is a variable, if not of this unit then of another
+		parse.Walk(f.Body, func(n parse.Node) bool {
+			if extrn, ok := n.(parse.ExternVarDeclNode); ok {
+				for _, name := range extrn.Names {
+					if _, ok := unit.Function(name); !ok && !c.isBuiltin(name) {
+						c.vars[name] = true
+					}
+				}
+			}
+			return true
+		})
+
 		c.EmitFunction(f)
 	}
 
@@ -54,32 +118,108 @@ func (c *CEmitter) EmitHeaders(unit parse.TranslationUnit) {
 	c.EmitLine(`#include "bstdlib.h"`)
 
 	c.EmitLine("")
+
+	// Pointers are kept in words as the address of a word, as in B, so
+	// that adding one to a pointer steps to the next word
+	c.EmitLine("#define B_ADDR(p) ((B_AUTO)(p) / (B_AUTO)sizeof(B_AUTO))")
+	c.EmitLine("#define B_PTR(w) ((B_AUTO *)((w) * (B_AUTO)sizeof(B_AUTO)))")
+}
+
+// Emit every string literal of the unit as a word aligned array, so its
+// address can be held as the address of a word
+func (c *CEmitter) EmitStrings(unit parse.TranslationUnit) {
+	var strs []parse.StringNode
+
+	visit := func(n parse.Node) bool {
+		if str, ok := n.(parse.StringNode); ok {
+			if _, ok := c.strings[str.Value]; !ok {
+				c.strings[str.Value] = len(strs)
+				strs = append(strs, str)
+			}
+		}
+		return true
+	}
+
+	for _, v := range unit.Vars {
+		parse.Walk(v, visit)
+	}
+	for _, f := range unit.Funcs {
+		parse.Walk(f.Body, visit)
+	}
+
+	if len(strs) == 0 {
+		return
+	}
+
+	c.EmitLine("\n/* Strings */")
+
+	for i, str := range strs {
+		c.EmitLine(fmt.Sprintf("static _Alignas(B_AUTO) char B_STRING_%d[] = %s;",
+			i, c.stringLiteral(str)))
+	}
+}
+
+// Emit a function run before main which sets up the globals whose
+// initial values C can't compute at compile time
+func (c *CEmitter) EmitInits() {
+	if len(c.inits) == 0 {
+		return
+	}
+
+	c.EmitPartial("\n__attribute__((constructor)) static void B_INIT(void) ")
+	c.StartBlock()
+
+	for _, init := range c.inits {
+		c.EmitLine(init)
+	}
+
+	c.EndBlock()
+}
+
+// Emit the initial value of a global, which is set by EmitInits instead
+// if it's a string
+func (c *CEmitter) emitInitializer(target string, val parse.Node) {
+	str, ok := val.(parse.StringNode)
+	if !ok {
+		c.EmitExpression(val)
+		return
+	}
+
+	c.EmitRaw("0")
+	c.inits = append(c.inits, fmt.Sprintf("%s = B_ADDR(B_STRING_%d);",
+		target, c.strings[str.Value]))
 }
 
 func (c *CEmitter) EmitGlobal(v parse.Node) {
 	switch v.(type) {
 	case parse.ExternVarInitNode:
 		var_ := v.(parse.ExternVarInitNode)
-		c.EmitLine(fmt.Sprintf("static B_AUTO %v = %v", var_.Name,
-			var_.Value))
+		c.vars[var_.Name] = true
+		c.EmitPartial(fmt.Sprintf("static B_AUTO %v = ", var_.Name))
+		c.emitInitializer(var_.Name, var_.Value)
+		c.EmitRaw(";\n")
 
 	case parse.ExternVecInitNode:
 		vec := v.(parse.ExternVecInitNode)
-		c.EmitPartial(fmt.Sprintf("static B_AUTO %v[][%d] = ",
-			vec.Name, vec.Size))
+		c.vars[vec.Name] = true
+		c.vecs[vec.Name] = true
+
+		// The size is the highest index, so there is one more word
+		size := vec.Size + 1
+		if len(vec.Values) > size {
+			size = len(vec.Values)
+		}
 
-		c.StartBlock()
+		c.EmitPartial(fmt.Sprintf("static B_AUTO %v[%d] = {", vec.Name, size))
 
 		for i, val := range vec.Values {
-			if i != len(vec.Values)-1 {
-				c.EmitLine(fmt.Sprintf("%v,", val))
-			} else {
-				c.EmitLine(val.String())
+			if i != 0 {
+				c.EmitRaw(", ")
 			}
+			c.emitInitializer(fmt.Sprintf("%s[%d]", vec.Name, i), val)
 		}
 
-		c.EndBlock()
-
+		c.EmitRaw("};\n")
 	}
 }
 
@@ -127,10 +267,55 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 		c.EmitBlock(node.(parse.BlockNode))
 	case parse.BreakNode:
 		c.EmitLine("break;")
+	case parse.ContinueNode:
+		c.EmitLine("continue;")
 	case parse.ExternVarDeclNode:
 		c.EmitLine(fmt.Sprintf("/* %v */", node))
+
+		// Declare the variables of other units
+		for _, name := range node.(parse.ExternVarDeclNode).Names {
+			_, global := c.unit.Variable(name)
+			if c.vars[name] && !global {
+				c.EmitLine(fmt.Sprintf("extern B_AUTO %s;", sanitizeIdentifier(name)))
+			}
+		}
+	case parse.ForNode:
+		for_ := node.(parse.ForNode)
+
+		c.EmitPartial("for (")
+		if _, ok := for_.Init.(parse.NullNode); !ok {
+			c.EmitExpression(for_.Init)
+		}
+		c.EmitRaw(";")
+		if _, ok := for_.Cond.(parse.NullNode); !ok {
+			c.EmitRaw(" ")
+			c.EmitExpression(for_.Cond)
+		}
+		c.EmitRaw(";")
+		if _, ok := for_.Step.(parse.NullNode); !ok {
+			c.EmitRaw(" ")
+			c.EmitExpression(for_.Step)
+		}
+		c.EmitRaw(")\n")
+
+		if _, ok := for_.Body.(parse.BlockNode); ok {
+			c.EmitStatement(for_.Body)
+		} else {
+			c.Indent()
+			c.EmitStatement(for_.Body)
+			c.Deindent()
+		}
 	case parse.GotoNode:
-		c.EmitLine(fmt.Sprintf("goto %s;", node.(parse.GotoNode).Label))
+		goto_ := node.(parse.GotoNode)
+
+		if name, ok := goto_.Name(); ok {
+			c.EmitLine(fmt.Sprintf("goto %s;", name))
+		} else {
+			// Computed goto, a GNU extension
+			c.EmitPartial("goto *(void *)(")
+			c.EmitExpression(goto_.Label)
+			c.EmitRaw(");\n")
+		}
 	case parse.IfNode:
 		if_ := node.(parse.IfNode)
 
@@ -206,7 +391,25 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 			c.EmitRaw(fmt.Sprintf("%s", decl.Name))
 
 			if decl.VecDecl {
-				c.EmitRaw(fmt.Sprintf("[%d]", decl.Size))
+				// One more word than the highest index, or room for
+				// every initializer
+				size := decl.Size + 1
+				if len(decl.Values) > size {
+					size = len(decl.Values)
+				}
+				c.EmitRaw(fmt.Sprintf("[%d]", size))
+			}
+
+			if len(decl.Values) > 0 {
+				c.EmitRaw(" = {")
+				for j, val := range decl.Values {
+					c.EmitExpression(val)
+
+					if j != len(decl.Values)-1 {
+						c.EmitRaw(", ")
+					}
+				}
+				c.EmitRaw("}")
 			}
 
 			if i != len(node.(parse.VarDeclNode).Vars)-1 {
@@ -245,11 +448,26 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 	switch expr.(type) {
 	case parse.ArrayAccessNode:
 		arr := expr.(parse.ArrayAccessNode)
-		c.EmitExpression(arr.Array)
+
+		// A vector can be indexed directly, anything else is a word
+		// holding an address
+		if c.isVector(arr.Array) {
+			c.EmitRaw(sanitizeIdentifier(arr.Array.String()))
+		} else {
+			c.EmitRaw("B_PTR(")
+			c.EmitExpression(arr.Array)
+			c.EmitRaw(")")
+		}
 		c.EmitRaw("[")
 		c.EmitExpression(arr.Index)
 		c.EmitRaw("]")
 
+	case parse.AssignNode:
+		assign := expr.(parse.AssignNode)
+		c.EmitExpression(assign.Lhs)
+		c.EmitRaw(" " + cOperator(assign.Op) + " ")
+		c.EmitExpression(assign.Rhs)
+
 	case parse.BinaryNode:
 		bin := expr.(parse.BinaryNode)
 		c.EmitExpression(bin.Left)
@@ -261,7 +479,27 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 
 	case parse.FunctionCallNode:
 		fun := expr.(parse.FunctionCallNode)
-		c.EmitExpression(fun.Callable)
+
+		if c.emitInlineBuiltin(fun) {
+			return
+		}
+
+		if c.isFunction(fun.Callable) {
+			c.EmitExpression(fun.Callable)
+		} else {
+			// Calling through a variable holding a function's
+			// address, which takes as many words as are passed
+			params := strings.TrimSuffix(
+				strings.Repeat("B_AUTO, ", len(fun.Args)), ", ")
+			if params == "" {
+				params = "void"
+			}
+
+			c.EmitRaw(fmt.Sprintf("((B_AUTO (*)(%s))", params))
+			c.EmitExpression(fun.Callable)
+			c.EmitRaw(")")
+		}
+
 		c.EmitRaw("(")
 		for i, arg := range fun.Args {
 			c.EmitExpression(arg)
@@ -278,17 +516,46 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 		c.EmitExpression(expr.(parse.ParenNode).Node)
 		c.EmitRaw(")")
 
+	case parse.CommaNode:
+		for i, e := range expr.(parse.CommaNode).Exprs {
+			if i != 0 {
+				c.EmitRaw(", ")
+			}
+			c.EmitExpression(e)
+		}
+
 	case parse.TernaryNode:
 		ter := expr.(parse.TernaryNode)
 		c.EmitExpression(ter.Cond)
-		c.EmitRaw(" ? ")
-		c.EmitExpression(ter.TrueBody)
-		c.EmitRaw(" : ")
+		if ter.Elvis() {
+			// GNU C has the same extension
+			c.EmitRaw(" ?: ")
+		} else {
+			c.EmitRaw(" ? ")
+			c.EmitExpression(ter.TrueBody)
+			c.EmitRaw(" : ")
+		}
 		c.EmitExpression(ter.FalseBody)
 
 	case parse.UnaryNode:
 		un := expr.(parse.UnaryNode)
-		if un.Postfix {
+		if un.Oper == "&" && c.isVector(un.Node) {
+			// Already an address, once made a word
+			c.EmitExpression(un.Node)
+		} else if un.Oper == "&" && c.isFunction(un.Node) {
+			// A function's address is kept as it is, since it's only
+			// ever called through
+			c.EmitRaw("(B_AUTO)&")
+			c.EmitExpression(un.Node)
+		} else if un.Oper == "&" {
+			c.EmitRaw("B_ADDR(&")
+			c.EmitExpression(un.Node)
+			c.EmitRaw(")")
+		} else if un.Oper == "*" {
+			c.EmitRaw("*B_PTR(")
+			c.EmitExpression(un.Node)
+			c.EmitRaw(")")
+		} else if un.Postfix {
 			c.EmitExpression(un.Node)
 			c.EmitRaw(un.Oper)
 		} else {
@@ -297,10 +564,18 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 		}
 
 	case parse.IdentNode:
-		c.EmitRaw(sanitizeIdentifier(expr.String()))
+		if c.isVector(expr) {
+			c.EmitRaw("B_ADDR(" + sanitizeIdentifier(expr.String()) + ")")
+		} else {
+			c.EmitRaw(sanitizeIdentifier(expr.String()))
+		}
 
-	case parse.CharacterNode, parse.StringNode:
-		c.EmitRaw(escapeString(expr.String()))
+	case parse.CharacterNode:
+		c.EmitRaw(c.characterLiteral(expr.(parse.CharacterNode)))
+
+	case parse.StringNode:
+		c.EmitRaw(fmt.Sprintf("B_ADDR(B_STRING_%d)",
+			c.strings[expr.(parse.StringNode).Value]))
 
 	default:
 		fmt.Println(expr)
@@ -345,52 +620,122 @@ func (c *CEmitter) Deindent() {
 	}
 }
 
+// Whether an expression names a function rather than a variable: one of
+// the unit's functions, or a name which is never declared, taken to be a
+// function defined elsewhere
+func (c *CEmitter) isFunction(expr parse.Node) bool {
+	ident, ok := expr.(parse.IdentNode)
+	return ok && !c.vars[ident.Value]
+}
+
+// Whether name is one of the runtime functions of the unit's dialect
+func (c *CEmitter) isBuiltin(name string) bool {
+	builtins := c.unit.Builtins
+	if builtins == nil {
+		builtins = parse.Builtins
+	}
+	return builtins[name]
+}
+
+// Whether an expression names a vector declared as a C array
+func (c *CEmitter) isVector(expr parse.Node) bool {
+	ident, ok := expr.(parse.IdentNode)
+	return ok && c.vecs[ident.Value]
+}
+
+// Expand calls to char and lchar into byte accesses, returning false if
+// fun is anything else. `char` is a keyword in C, so these can't be
+// left as calls.
+func (c *CEmitter) emitInlineBuiltin(fun parse.FunctionCallNode) bool {
+	name, ok := c.unit.BuiltinCall(fun)
+	if !ok || c.vars[name] {
+		return false
+	}
+
+	switch {
+	case name == "char" && len(fun.Args) == 2:
+		c.EmitRaw("((unsigned char *)B_PTR(")
+		c.EmitExpression(fun.Args[0])
+		c.EmitRaw("))[")
+		c.EmitExpression(fun.Args[1])
+		c.EmitRaw("]")
+
+	case name == "lchar" && len(fun.Args) == 3:
+		c.EmitRaw("(((unsigned char *)B_PTR(")
+		c.EmitExpression(fun.Args[0])
+		c.EmitRaw("))[")
+		c.EmitExpression(fun.Args[1])
+		c.EmitRaw("] = (")
+		c.EmitExpression(fun.Args[2])
+		c.EmitRaw("))")
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// B spells compound assignment with the '=' first (`=+`), C with it last
+func cOperator(oper string) string {
+	if len(oper) > 1 {
+		return oper[1:] + "="
+	}
+
+	return oper
+}
+
 // Return a C version of the given B identifier
 func sanitizeIdentifier(ident string) string {
 	return strings.Replace(ident, ".", "_", -1)
 }
 
-// *0	null
-// *e	end-of-file
-// *(	{
-// *)	}
-// *t	tab
-// **	*
-// *'	'
-// *"	"
-// *n	new line
-func escapeString(str string) string {
-	escaped := ""
+// C spelling of a character constant. A lone character stays a character
+// literal. More are packed into a word as B does it, since C leaves the
+// value of 'ab' up to the compiler.
+func (c *CEmitter) characterLiteral(char parse.CharacterNode) string {
+	if str, err := c.unit.Unescape(char.Value); err == nil && len(str) == 1 {
+		return "'" + cEscape(str, '\'') + "'"
+	}
 
-	for i := 0; i < len(str); i++ {
-		if str[i] == '*' {
-			switch str[i+1] {
-			case '0':
-				escaped += "\\0"
-			case 'e':
-				// EOT
-				escaped += "\\0"
-			case '(':
-				escaped += "{"
-			case ')':
-				escaped += "}"
-			case 't':
-				escaped += "\\t"
-			case '*':
-				escaped += "*"
-			case '\'':
-				escaped += "'"
-			case '"':
-				escaped += "\""
-			case 'n':
-				escaped += "\\n"
-			}
+	value, err := char.IntWithConfig(c.unit.Config)
+	if err != nil {
+		panic(err)
+	}
 
-			i += 1
-		} else {
-			escaped += string(str[i])
+	return fmt.Sprint(value)
+}
+
+// C spelling of a string literal, decoded with the unit's escapes
+func (c *CEmitter) stringLiteral(str parse.StringNode) string {
+	text, err := c.unit.Unescape(str.Value)
+	if err != nil {
+		panic(err)
+	}
+
+	return `"` + cEscape(text, '"') + `"`
+}
+
+// Escape the characters of str which can't appear as they are between
+// C quotes
+func cEscape(str string, quote byte) string {
+	var b strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		switch char := str[i]; {
+		case char == quote || char == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(char)
+		case char == '\n':
+			b.WriteString("\\n")
+		case char == '\t':
+			b.WriteString("\\t")
+		case char < ' ' || char > '~':
+			fmt.Fprintf(&b, "\\%03o", char)
+		default:
+			b.WriteByte(char)
 		}
 	}
 
-	return escaped
+	return b.String()
 }
@@ -0,0 +1,184 @@
+package emit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erik/gob/parse"
+)
+
+const fnPointerSrc = `
+twice(x) { return (x * 2); }
+run() {
+	auto p;
+	p = &twice;
+	return (p(21));
+}`
+
+func emitC(t *testing.T, src string) string {
+	unit, err := parse.NewParser("", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := (CEmitter{}).Emit(&out, unit); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	return out.String()
+}
+
+func TestEmitFunctionAddress(t *testing.T) {
+	out := emitC(t, fnPointerSrc)
+
+	if !strings.Contains(out, "p = (B_AUTO)&twice;") {
+		t.Errorf("Address of function not taken:\n%s", out)
+	}
+
+	if !strings.Contains(out, "((B_AUTO (*)(B_AUTO))p)(21)") {
+		t.Errorf("Call through pointer not cast:\n%s", out)
+	}
+
+	if !strings.Contains(out, "return (x * 2);") {
+		t.Errorf("Plain expression changed:\n%s", out)
+	}
+}
+
+func TestEmitExtrn(t *testing.T) {
+	out := emitC(t, `
+f() { extrn x, g; g(); x(1); return (&x); }
+g() { return (0); }
+`)
+
+	for _, expected := range []string{
+		"\tg();",
+		"((B_AUTO (*)(B_AUTO))x)(1);",
+		"return (B_ADDR(&x));",
+		"extern B_AUTO x;",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out)
+		}
+	}
+}
+
+// Compile the emitted code and call through the stored pointer
+func TestEmitFunctionAddressRuns(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+
+	dir, err := ioutil.TempDir("", "gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"bstdlib.h": "#include <stdint.h>\ntypedef intptr_t B_AUTO;\n",
+		"out.c": emitC(t, fnPointerSrc) +
+			"\nint main(void) { return run() == 42 ? 0 : 1; }\n",
+	}
+
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin := filepath.Join(dir, "out")
+	if out, err := exec.Command(cc, "-o", bin, filepath.Join(dir, "out.c")).CombinedOutput(); err != nil {
+		t.Fatalf("Compile failed: %v\n%s", err, out)
+	}
+
+	if err := exec.Command(bin).Run(); err != nil {
+		t.Errorf("Call through pointer returned the wrong value: %v", err)
+	}
+}
+
+func TestEmitInlineChar(t *testing.T) {
+	out := emitC(t, `
+first(s) { return (char(s, 0)); }
+set(s, c) { lchar(s, 1, c + 1); }
+`)
+
+	if !strings.Contains(out, "return (((unsigned char *)B_PTR(s))[0]);") {
+		t.Errorf("char not inlined:\n%s", out)
+	}
+
+	if !strings.Contains(out, "(((unsigned char *)B_PTR(s))[1] = (c + 1));") {
+		t.Errorf("lchar not inlined:\n%s", out)
+	}
+
+	// A function of the same name is called like any other
+	out = emitC(t, `
+char(s, i) { return (0); }
+first(s) { return (char(s, 0)); }
+`)
+
+	if strings.Contains(out, "unsigned char") {
+		t.Errorf("User defined char inlined:\n%s", out)
+	}
+}
+
+func TestEmitSize(t *testing.T) {
+	src := "f() { auto v[10]; return (size(v)); }"
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(src),
+		parse.ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := (CEmitter{}).Emit(&out, unit); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "return (10);") {
+		t.Errorf("size not folded:\n%s", out.String())
+	}
+}
+
+func TestEmitGoto(t *testing.T) {
+	out := emitC(t, `f(p) { top: goto top; goto *p; }`)
+
+	for _, expected := range []string{"goto top;", "goto *(void *)(*B_PTR(p));"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out)
+		}
+	}
+}
+
+func TestEmitLiterals(t *testing.T) {
+	config := parse.ModernConfig
+	config.Escapes = map[byte]byte{'e': 4, 'n': '\n', 'q': '"'}
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(
+		`f(s) { s = "*q*n"; return ('*q' + 'ab'); }`), config).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := (CEmitter{}).Emit(&out, unit); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		`static _Alignas(B_AUTO) char B_STRING_0[] = "\"\n";`,
+		`s = B_ADDR(B_STRING_0);`,
+		`return ('"' + 24930);`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+)
+
+// The runtime functions of B
+var DefaultBuiltins = map[string]Builtin{
+	"char":    builtinChar,
+	"exit":    builtinExit,
+	"getchar": builtinGetchar,
+	"lchar":   builtinLchar,
+	"printf":  builtinPrintf,
+	"putchar": builtinPutchar,
+}
+
+func checkArgs(name string, args []int, min int) error {
+	if len(args) < min {
+		return fmt.Errorf("%s expects %d arguments, got %d", name, min, len(args))
+	}
+	return nil
+}
+
+// char(string, i) returns the ith character of string
+func builtinChar(in *Interpreter, args []int) (int, error) {
+	if err := checkArgs("char", args, 2); err != nil {
+		return 0, err
+	}
+
+	return in.Load(args[0] + args[1])
+}
+
+// lchar(string, i, char) sets the ith character of string
+func builtinLchar(in *Interpreter, args []int) (int, error) {
+	if err := checkArgs("lchar", args, 3); err != nil {
+		return 0, err
+	}
+
+	return args[2], in.Store(args[0]+args[1], args[2])
+}
+
+func builtinExit(in *Interpreter, args []int) (int, error) {
+	code := 0
+	if len(args) > 0 {
+		code = args[0]
+	}
+
+	return 0, exitError{code}
+}
+
+// Returns *e at the end of input
+func builtinGetchar(in *Interpreter, args []int) (int, error) {
+	char, err := in.reader.ReadByte()
+	if err == io.EOF {
+		return EOT, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return int(char), nil
+}
+
+// Write the characters packed into a word, first the highest
+func builtinPutchar(in *Interpreter, args []int) (int, error) {
+	if err := checkArgs("putchar", args, 1); err != nil {
+		return 0, err
+	}
+
+	_, err := in.Output.Write(unpack(args[0]))
+	return args[0], err
+}
+
+// Characters packed into a word, ignoring the zero bytes of padding
+func unpack(word int) []byte {
+	var chars []byte
+
+	for w := uint(word); w != 0; w >>= 8 {
+		chars = append([]byte{byte(w)}, chars...)
+	}
+
+	return chars
+}
+
+// printf(format, args...) with %d, %o, %c, %s and %%
+func builtinPrintf(in *Interpreter, args []int) (int, error) {
+	if err := checkArgs("printf", args, 1); err != nil {
+		return 0, err
+	}
+
+	format, err := in.String(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	args = args[1:]
+	next := func() (int, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("printf: too few arguments for %q", format)
+		}
+
+		arg := args[0]
+		args = args[1:]
+		return arg, nil
+	}
+
+	var out []byte
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			out = append(out, format[i])
+			continue
+		}
+
+		i += 1
+		if format[i] == '%' {
+			out = append(out, '%')
+			continue
+		}
+
+		arg, err := next()
+		if err != nil {
+			return 0, err
+		}
+
+		switch format[i] {
+		case 'd':
+			out = append(out, fmt.Sprintf("%d", arg)...)
+		case 'o':
+			out = append(out, fmt.Sprintf("%o", arg)...)
+		case 'c':
+			out = append(out, unpack(arg)...)
+		case 's':
+			str, err := in.String(arg)
+			if err != nil {
+				return 0, err
+			}
+			out = append(out, str...)
+		default:
+			return 0, fmt.Errorf("printf: unknown format %%%c", format[i])
+		}
+	}
+
+	_, err = in.Output.Write(out)
+	return 0, err
+}
@@ -0,0 +1,275 @@
+// Package eval interprets a parsed unit directly, for trying out programs
+// without a backend.
+//
+// Memory is an array of words addressed by index. Every variable is a
+// word in memory, and a vector is a word holding the address of its
+// elements, as in B. Strings are stored one character per word and end
+// with the B end of string character, *e.
+package eval
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"io"
+	"os"
+	"strings"
+)
+
+// Character which ends a string
+const EOT = 4
+
+// Calls which may be nested before a stack overflow, by default
+const DefaultMaxDepth = 10000
+
+// Function provided by the runtime rather than the program
+type Builtin func(in *Interpreter, args []int) (int, error)
+
+type Interpreter struct {
+	Output io.Writer
+	Input  io.Reader
+
+	// Functions callable by name which the unit doesn't define,
+	// DefaultBuiltins by default
+	Builtins map[string]Builtin
+
+	// Deepest nesting of calls allowed, DefaultMaxDepth by default
+	MaxDepth int
+
+	unit   parse.TranslationUnit
+	mem    []int
+	ready  bool
+	reader *bufio.Reader
+
+	globals map[string]int // Name to address
+	strings map[string]int // Literal as written to address
+
+	// Address of the word standing for each function, for calling
+	// through a value
+	funcAddrs map[string]int
+	funcs     map[int]string
+
+	calls []string // Names of the functions being run, outermost first
+}
+
+func New(unit parse.TranslationUnit) *Interpreter {
+	return &Interpreter{
+		Output:   os.Stdout,
+		Input:    os.Stdin,
+		Builtins: DefaultBuiltins,
+		MaxDepth: DefaultMaxDepth,
+		unit:     unit,
+	}
+}
+
+// Call entry in the unit with args, writing to standard output.
+func Run(unit parse.TranslationUnit, entry string, args []int) (int, error) {
+	return New(unit).Call(entry, args)
+}
+
+// Result of exit(), which stops the program
+type exitError struct{ code int }
+
+func (e exitError) Error() string { return fmt.Sprintf("exit(%d)", e.code) }
+
+// Calls nested deeper than MaxDepth, with the chain of calls made
+type stackOverflow struct{ calls []string }
+
+func (e stackOverflow) Error() string {
+	var chain []string
+
+	// Collapse runs of recursion, which would otherwise fill the message
+	for i := 0; i < len(e.calls); {
+		j := i
+		for j < len(e.calls) && e.calls[j] == e.calls[i] {
+			j++
+		}
+
+		if j-i > 1 {
+			chain = append(chain, fmt.Sprintf("%s (x%d)", e.calls[i], j-i))
+		} else {
+			chain = append(chain, e.calls[i])
+		}
+		i = j
+	}
+
+	return "stack overflow: " + strings.Join(chain, " -> ")
+}
+
+// Call the named function with args, returning its result. A call to
+// exit() ends the program and returns its argument.
+func (in *Interpreter) Call(name string, args []int) (int, error) {
+	if !in.ready {
+		if err := in.setup(); err != nil {
+			return 0, err
+		}
+		in.ready = true
+	}
+
+	addr, ok := in.funcAddrs[name]
+	if !ok {
+		return 0, fmt.Errorf("undefined function %s", name)
+	}
+
+	value, err := in.call(addr, args)
+	if exit, ok := err.(exitError); ok {
+		return exit.code, nil
+	}
+
+	return value, err
+}
+
+// Value of the word at addr
+func (in *Interpreter) Load(addr int) (int, error) {
+	if addr < 0 || addr >= len(in.mem) {
+		return 0, fmt.Errorf("address %d out of bounds", addr)
+	}
+	return in.mem[addr], nil
+}
+
+// Set the word at addr
+func (in *Interpreter) Store(addr, value int) error {
+	if addr < 0 || addr >= len(in.mem) {
+		return fmt.Errorf("address %d out of bounds", addr)
+	}
+	in.mem[addr] = value
+	return nil
+}
+
+// Read the string starting at addr
+func (in *Interpreter) String(addr int) (string, error) {
+	var str []byte
+
+	for {
+		char, err := in.Load(addr)
+		if err != nil {
+			return "", err
+		} else if char == EOT {
+			return string(str), nil
+		}
+
+		str = append(str, byte(char))
+		addr += 1
+	}
+}
+
+// Reserve n zeroed words, returning the address of the first
+func (in *Interpreter) alloc(n int) int {
+	addr := len(in.mem)
+	in.mem = append(in.mem, make([]int, n)...)
+	return addr
+}
+
+func (in *Interpreter) setup() error {
+	unit, err := in.unit.FoldSizes()
+	if err != nil {
+		return err
+	}
+	in.unit = unit
+
+	in.mem = nil
+	in.reader = bufio.NewReader(in.Input)
+	in.globals = map[string]int{}
+	in.strings = map[string]int{}
+	in.funcAddrs = map[string]int{}
+	in.funcs = map[int]string{}
+	in.calls = nil
+
+	// Word 0 is left unused, so no object has a null address
+	in.alloc(1)
+
+	addFunc := func(name string) {
+		if _, ok := in.funcAddrs[name]; !ok {
+			// The word holds its own address, so *&f is f
+			addr := in.alloc(1)
+			in.mem[addr] = addr
+			in.funcAddrs[name] = addr
+			in.funcs[addr] = name
+		}
+	}
+
+	for _, fn := range in.unit.Funcs {
+		addFunc(fn.Name)
+	}
+	for name := range in.Builtins {
+		addFunc(name)
+	}
+
+	// Strings are allocated up front, since memory past this point is
+	// reused by calls
+	addString := func(n parse.Node) bool {
+		if str, ok := n.(parse.StringNode); ok {
+			if _, ok := in.strings[str.Value]; !ok {
+				chars := str.BytesWithConfig(in.unit.Config)
+
+				addr := in.alloc(len(chars))
+				for i, char := range chars {
+					in.mem[addr+i] = int(char)
+				}
+
+				in.strings[str.Value] = addr
+			}
+		}
+		return true
+	}
+
+	for _, v := range in.unit.Vars {
+		parse.Walk(v, addString)
+	}
+	for _, fn := range in.unit.Funcs {
+		parse.Walk(fn.Body, addString)
+	}
+
+	for _, v := range in.unit.Vars {
+		switch node := v.(type) {
+		case parse.ExternVarInitNode:
+			addr := in.alloc(1)
+			in.globals[node.Name] = addr
+
+			value, err := in.constant(node.Value)
+			if err != nil {
+				return err
+			}
+			in.mem[addr] = value
+
+		case parse.ExternVecInitNode:
+			addr := in.alloc(1)
+			in.globals[node.Name] = addr
+
+			size := node.Size + 1
+			if len(node.Values) > size {
+				size = len(node.Values)
+			}
+
+			vec := in.alloc(size)
+			in.mem[addr] = vec
+
+			for i, val := range node.Values {
+				value, err := in.constant(val)
+				if err != nil {
+					return err
+				}
+				in.mem[vec+i] = value
+			}
+
+		default:
+			return fmt.Errorf("unexpected global: %v", v)
+		}
+	}
+
+	return nil
+}
+
+// Value of a literal in an initializer
+func (in *Interpreter) constant(node parse.Node) (int, error) {
+	switch n := node.(type) {
+	case parse.IntegerNode:
+		return int(n.Value), nil
+	case parse.CharacterNode:
+		return n.IntWithConfig(in.unit.Config)
+	case parse.StringNode:
+		return in.strings[n.Value], nil
+	}
+
+	return 0, fmt.Errorf("unexpected constant: %v", node)
+}
@@ -0,0 +1,356 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"strings"
+	"testing"
+)
+
+func parseUnit(t *testing.T, src string) parse.TranslationUnit {
+	unit, err := parse.NewParser("", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	return unit
+}
+
+func TestRunFactorial(t *testing.T) {
+	unit := parseUnit(t, `
+fact(n) {
+  if (n < 2)
+    return (1);
+  return (n * fact(n - 1));
+}
+`)
+
+	result, err := Run(unit, "fact", []int{6})
+	if err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	} else if result != 720 {
+		t.Errorf("Expected 720, got %d", result)
+	}
+}
+
+func TestRunPrograms(t *testing.T) {
+	var tests = []struct {
+		src      string
+		expected int
+		output   string
+	}{
+		// Vectors, pointers and loops
+		{`
+sum [4] 1, 2, 3, 4, 5;
+
+main() {
+  auto i, total, p;
+  total = 0;
+  p = &total;
+  i = 0;
+  while (i <= 4)
+    *p =+ sum[i++];
+  return (total);
+}`, 15, ""},
+
+		// Auto vectors and passing vectors by address
+		{`
+fill(v, n) {
+  while (n--)
+    v[n] = n * n;
+}
+
+main() {
+  auto v[3];
+  fill(v, 4);
+  return (v[0] + v[1] + v[2] + v[3]);
+}`, 14, ""},
+
+		// Output through the builtins
+		{`
+main() {
+  extrn putchar, printf;
+  putchar('hi');
+  putchar('*n');
+  printf("%d %s%c*n", 42, "ok", '!');
+}`, 0, "hi\n42 ok!\n"},
+
+		// Switch falls through until a break
+		{`
+main() {
+  auto n;
+  n = 0;
+  switch (2) {
+  case 1:
+    n =+ 1;
+  case 2:
+    n =+ 2;
+  case 3:
+    n =+ 3;
+    break;
+  default:
+    n =+ 100;
+  }
+  return (n);
+}`, 5, ""},
+
+		// Goto backwards and forwards, into a loop body
+		{`
+main() {
+  auto i;
+  i = 0;
+again:
+  if (++i < 3) goto again;
+  goto inside;
+  while (0) {
+inside:
+    i =* 10;
+  }
+  return (i);
+}`, 30, ""},
+
+		// Calls through a variable, and exit
+		{`
+twice(x) return (x * 2);
+
+main() {
+  auto f;
+  f = twice;
+  exit(f(21));
+  return (1);
+}`, 42, ""},
+
+		// Strings are words ending in *e
+		{`
+length(s) {
+  auto n;
+  n = 0;
+  while (char(s, n) != '*e')
+    n++;
+  return (n);
+}
+
+main() return (length("hello"));
+`, 5, ""},
+
+		// Initializers longer than the vector don't spill into the
+		// next variable
+		{`
+main() {
+  auto v[1] 1, 2, 3;
+  auto w;
+  w = 10;
+  return (v[0] + v[1] + v[2] + w);
+}`, 16, ""},
+		{`
+main() {
+  auto v[0] 1, 2, 3, 4, 5, 6, 7, 8;
+  return (v[7]);
+}`, 8, ""},
+
+		// Calling through the address of a function
+		{`
+twice(n) return (2 * n);
+
+main() {
+  auto p;
+  p = &twice;
+  return (p(3) + (*&twice)(4));
+}`, 14, ""},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+
+		in := New(parseUnit(t, test.src))
+		in.Output = &out
+
+		result, err := in.Call("main", nil)
+		if err != nil {
+			t.Errorf("%s\nFailed to run: %v", test.src, err)
+			continue
+		}
+
+		if result != test.expected {
+			t.Errorf("%s\nExpected %d, got %d", test.src, test.expected, result)
+		}
+
+		if out.String() != test.output {
+			t.Errorf("%s\nExpected output %q, got %q", test.src, test.output,
+				out.String())
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var tests = []struct {
+		src, err string
+	}{
+		{"main() return (1 / 0);", "main: division by zero"},
+		{"main() return (x);", "main: undefined name x"},
+		{"main() return (&1);", "main: 1 is not an lvalue"},
+		{"main() goto nowhere;", "main: undefined label nowhere"},
+		{"main() goto *0;", "main: computed goto not supported: goto *0;"},
+		{"main() break;", "main: break outside of a loop or switch"},
+	}
+
+	for _, test := range tests {
+		_, err := Run(parseUnit(t, test.src), "main", nil)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected %q, got %v", test.src, test.err, err)
+		}
+	}
+
+	recurse := parseUnit(t, "f(n) return (f(n + 1));\nmain() return (f(0));")
+	_, err := Run(recurse, "main", nil)
+	expected := fmt.Sprintf("stack overflow: main -> f (x%d)", DefaultMaxDepth)
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+
+	in := New(recurse)
+	in.MaxDepth = 3
+	if _, err := in.Call("f", []int{0}); err == nil ||
+		err.Error() != "stack overflow: f (x4)" {
+		t.Errorf("Expected a stack overflow after 3 calls, got %v", err)
+	}
+
+	if _, err := Run(parseUnit(t, "f() ;"), "main", nil); err == nil {
+		t.Errorf("Expected an error calling an undefined function")
+	}
+}
+
+func TestRunContinue(t *testing.T) {
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+odds(n) {
+  auto i, total;
+  total = 0;
+  for (i = 0; i < n; i++) {
+    switch (i % 2) {
+    case 0:
+      continue;
+    }
+    total =+ i;
+  }
+  return (total);
+}
+
+stray() continue;
+`), parse.ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if result, err := Run(unit, "odds", []int{10}); err != nil || result != 25 {
+		t.Errorf("Expected 25, got %d, %v", result, err)
+	}
+
+	if _, err := Run(unit, "stray", nil); err == nil ||
+		err.Error() != "stray: continue outside of a loop" {
+		t.Errorf("Expected a continue outside of a loop, got %v", err)
+	}
+}
+
+func TestRunElvis(t *testing.T) {
+	config := parse.ClassicConfig
+	config.AllowElvis = true
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+count 0;
+next() return (++count);
+main() {
+  auto a, b;
+  a = next() ?: 10;
+  b = 0 ?: 20;
+  return (a + b + count);
+}
+`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	// next() is called only once
+	if result, err := Run(unit, "main", nil); err != nil || result != 22 {
+		t.Errorf("Expected 22, got %d, %v", result, err)
+	}
+}
+
+func TestRunCommaExpressions(t *testing.T) {
+	config := parse.ModernConfig
+	config.CommaExpressions = true
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+meet(n) {
+  auto i, j;
+  for (i = 0, j = n; i < j; i++, j--)
+    ;
+  return ((i =* 10, i + j));
+}
+`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if result, err := Run(unit, "meet", []int{7}); err != nil || result != 43 {
+		t.Errorf("Expected 43, got %d, %v", result, err)
+	}
+}
+
+func TestRunSize(t *testing.T) {
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(`
+table[4] 1, 2;
+main() {
+  auto v[10];
+  extrn table;
+  return (size(v) + size(table));
+}`), parse.ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if result, err := Run(unit, "main", nil); err != nil || result != 14 {
+		t.Errorf("Expected 14, got %d, %v", result, err)
+	}
+}
+
+func TestRunEscapes(t *testing.T) {
+	config := parse.ModernConfig
+	config.Escapes = map[byte]byte{'e': 4, 'n': '\n', 'q': '"'}
+
+	unit, err := parse.NewParserWithConfig("", strings.NewReader(
+		`main() { putchar('*q'); return (char("a*q", 1)); }`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	var out bytes.Buffer
+	in := New(unit)
+	in.Output = &out
+
+	if result, err := in.Call("main", nil); err != nil || result != '"' {
+		t.Errorf("Expected %d, got %d, %v", '"', result, err)
+	}
+	if out.String() != `"` {
+		t.Errorf("Expected a quote, got %q", out.String())
+	}
+}
+
+func TestRunBuiltins(t *testing.T) {
+	unit := parseUnit(t, `main() { auto c; c = getchar(); return (twice(c)); }`)
+
+	in := New(unit)
+	in.Input = strings.NewReader("A")
+	in.Builtins = map[string]Builtin{
+		"getchar": DefaultBuiltins["getchar"],
+		"twice": func(in *Interpreter, args []int) (int, error) {
+			return args[0] * 2, nil
+		},
+	}
+
+	if result, err := in.Call("main", nil); err != nil {
+		t.Errorf("Failed to run: %v", err)
+	} else if result != 'A'*2 {
+		t.Errorf("Expected %d, got %d", 'A'*2, result)
+	}
+}
@@ -0,0 +1,587 @@
+package eval
+
+import (
+	"fmt"
+	"github.com/erik/gob/parse"
+)
+
+// Variables of a function call
+type frame struct {
+	vars map[string]int // Name to address
+	ret  int
+
+	// Label being searched for after a goto. Statements are skipped
+	// until it's found.
+	seek string
+}
+
+// How a statement finished
+type control int
+
+const (
+	proceed control = iota
+	breakOut
+	continueOut
+	returnOut
+	jumpOut // Goto, with frame.seek set
+)
+
+func (in *Interpreter) call(addr int, args []int) (int, error) {
+	name, ok := in.funcs[addr]
+	if !ok {
+		return 0, fmt.Errorf("call of non-function at %d", addr)
+	}
+
+	if fn, ok := in.unit.Function(name); ok {
+		return in.callFunction(fn, args)
+	}
+
+	return in.Builtins[name](in, args)
+}
+
+func (in *Interpreter) callFunction(fn parse.FunctionNode, args []int) (int, error) {
+	// Free the frame and leave the call on return
+	base, depth := len(in.mem), len(in.calls)
+	defer func() {
+		in.mem = in.mem[:base]
+		in.calls = in.calls[:depth]
+	}()
+
+	in.calls = append(in.calls, fn.Name)
+	if len(in.calls) > in.MaxDepth {
+		return 0, stackOverflow{append([]string{}, in.calls...)}
+	}
+
+	f := &frame{vars: map[string]int{}}
+
+	for i, param := range fn.Params {
+		f.vars[param] = in.alloc(1)
+
+		if i < len(args) {
+			in.mem[f.vars[param]] = args[i]
+		}
+	}
+
+	for _, local := range fn.Locals() {
+		if _, ok := f.vars[local.Name]; ok {
+			continue
+		}
+
+		addr := in.alloc(1)
+		f.vars[local.Name] = addr
+
+		if local.VecDecl {
+			size := local.Size + 1
+			if len(local.Values) > size {
+				size = len(local.Values)
+			}
+
+			in.mem[addr] = in.alloc(size)
+		}
+	}
+
+	for {
+		ctl, err := in.exec(f, fn.Body)
+		switch err.(type) {
+		case nil:
+		case exitError, stackOverflow:
+			return 0, err
+		default:
+			return 0, fmt.Errorf("%s: %v", fn.Name, err)
+		}
+
+		switch {
+		case f.seek != "":
+			if ctl != jumpOut {
+				return 0, fmt.Errorf("%s: undefined label %s", fn.Name, f.seek)
+			}
+			// Start again from the top, looking for the label
+		case ctl == breakOut:
+			return 0, fmt.Errorf("%s: break outside of a loop or switch", fn.Name)
+		case ctl == continueOut:
+			return 0, fmt.Errorf("%s: continue outside of a loop", fn.Name)
+		case ctl == returnOut:
+			return f.ret, nil
+		default:
+			return 0, nil
+		}
+	}
+}
+
+func (in *Interpreter) exec(f *frame, node parse.Node) (control, error) {
+	seeking := f.seek != ""
+
+	switch n := node.(type) {
+	case parse.BlockNode:
+		return in.execAll(f, n.Nodes)
+
+	case parse.LabelNode:
+		if f.seek == n.Name {
+			f.seek = ""
+		}
+
+	case parse.GotoNode:
+		if seeking {
+			break
+		}
+
+		name, ok := n.Name()
+		if !ok {
+			return proceed, fmt.Errorf("computed goto not supported: %v", n)
+		}
+
+		f.seek = name
+		return jumpOut, nil
+
+	case parse.IfNode:
+		if seeking {
+			// Look in both branches, but don't fall from the body
+			// into the else
+			ctl, err := in.exec(f, n.Body)
+			if err != nil || ctl != proceed || f.seek == "" || !n.HasElse {
+				return ctl, err
+			}
+			return in.exec(f, n.ElseBody)
+		}
+
+		cond, err := in.eval(f, n.Cond)
+		if err != nil {
+			return proceed, err
+		}
+
+		if cond != 0 {
+			return in.exec(f, n.Body)
+		} else if n.HasElse {
+			return in.exec(f, n.ElseBody)
+		}
+
+	case parse.WhileNode:
+		return in.loop(f, parse.NullNode{}, n.Cond, parse.NullNode{}, n.Body)
+
+	case parse.ForNode:
+		return in.loop(f, n.Init, n.Cond, n.Step, n.Body)
+
+	case parse.SwitchNode:
+		return in.execSwitch(f, n)
+
+	case parse.BreakNode:
+		if !seeking {
+			return breakOut, nil
+		}
+
+	case parse.ContinueNode:
+		if !seeking {
+			return continueOut, nil
+		}
+
+	case parse.ReturnNode:
+		if seeking {
+			break
+		}
+
+		if _, ok := n.Node.(parse.NullNode); !ok {
+			value, err := in.eval(f, n.Node)
+			if err != nil {
+				return proceed, err
+			}
+			f.ret = value
+		}
+
+		return returnOut, nil
+
+	case parse.StatementNode:
+		if !seeking {
+			_, err := in.eval(f, n.Expr)
+			return proceed, err
+		}
+
+	case parse.VarDeclNode:
+		if seeking {
+			break
+		}
+
+		for _, decl := range n.Vars {
+			vec := in.mem[f.vars[decl.Name]]
+
+			for i, val := range decl.Values {
+				value, err := in.constant(val)
+				if err != nil {
+					return proceed, err
+				}
+				if err := in.Store(vec+i, value); err != nil {
+					return proceed, err
+				}
+			}
+		}
+
+	case parse.NullNode, parse.ExternVarDeclNode:
+
+	default:
+		if parse.IsStatement(node) {
+			return proceed, fmt.Errorf("unexpected statement: %v", node)
+		}
+
+		if !seeking {
+			_, err := in.eval(f, node)
+			return proceed, err
+		}
+	}
+
+	return proceed, nil
+}
+
+// Execute statements in order until one doesn't proceed
+func (in *Interpreter) execAll(f *frame, stmts []parse.Node) (control, error) {
+	for _, stmt := range stmts {
+		if ctl, err := in.exec(f, stmt); err != nil || ctl != proceed {
+			return ctl, err
+		}
+	}
+
+	return proceed, nil
+}
+
+// Run a loop, where init, cond and step may each be a NullNode
+func (in *Interpreter) loop(f *frame, init, cond, step, body parse.Node) (control, error) {
+	if f.seek == "" {
+		if _, err := in.optional(f, init); err != nil {
+			return proceed, err
+		}
+	}
+
+	for {
+		// Jumping into the body skips the condition
+		if f.seek == "" {
+			if value, err := in.optional(f, cond); err != nil {
+				return proceed, err
+			} else if value == 0 {
+				return proceed, nil
+			}
+		}
+
+		ctl, err := in.exec(f, body)
+		if err != nil {
+			return ctl, err
+		} else if ctl == breakOut {
+			return proceed, nil
+		} else if (ctl != proceed && ctl != continueOut) || f.seek != "" {
+			return ctl, nil
+		}
+
+		if _, err := in.optional(f, step); err != nil {
+			return proceed, err
+		}
+	}
+}
+
+// Evaluate an expression which may be left out, in which case it is true
+func (in *Interpreter) optional(f *frame, node parse.Node) (int, error) {
+	if _, ok := node.(parse.NullNode); ok {
+		return 1, nil
+	}
+
+	return in.eval(f, node)
+}
+
+// Jump to the matching case and fall through the rest, default last
+func (in *Interpreter) execSwitch(f *frame, sw parse.SwitchNode) (control, error) {
+	var stmts []parse.Node
+	var starts []int
+
+	for _, c := range sw.Cases {
+		starts = append(starts, len(stmts))
+		stmts = append(stmts, c.Statements...)
+	}
+
+	start := len(stmts)
+	stmts = append(stmts, sw.DefaultCase...)
+
+	if f.seek != "" {
+		start = 0
+	} else {
+		value, err := in.eval(f, sw.Cond)
+		if err != nil {
+			return proceed, err
+		}
+
+		for i, c := range sw.Cases {
+			label, err := in.eval(f, c.Cond)
+			if err != nil {
+				return proceed, err
+			}
+
+			if label == value {
+				start = starts[i]
+				break
+			}
+		}
+	}
+
+	ctl, err := in.execAll(f, stmts[start:])
+	if ctl == breakOut {
+		ctl = proceed
+	}
+
+	return ctl, err
+}
+
+func (in *Interpreter) eval(f *frame, node parse.Node) (int, error) {
+	switch n := node.(type) {
+	case parse.IntegerNode, parse.CharacterNode, parse.StringNode:
+		return in.constant(node)
+
+	case parse.IdentNode:
+		if addr, ok := in.variable(f, n.Value); ok {
+			return in.mem[addr], nil
+		}
+
+		// A function's value is its address
+		if addr, ok := in.funcAddrs[n.Value]; ok {
+			return addr, nil
+		}
+
+		return 0, fmt.Errorf("undefined name %s", n.Value)
+
+	case parse.ParenNode:
+		return in.eval(f, n.Node)
+
+	case parse.CommaNode:
+		var value int
+		for _, expr := range n.Exprs {
+			var err error
+			if value, err = in.eval(f, expr); err != nil {
+				return 0, err
+			}
+		}
+		return value, nil
+
+	case parse.ArrayAccessNode:
+		addr, err := in.address(f, n)
+		if err != nil {
+			return 0, err
+		}
+		return in.Load(addr)
+
+	case parse.UnaryNode:
+		return in.unary(f, n)
+
+	case parse.AssignNode:
+		return in.assign(f, n)
+
+	case parse.BinaryNode:
+		left, err := in.eval(f, n.Left)
+		if err != nil {
+			return 0, err
+		}
+
+		right, err := in.eval(f, n.Right)
+		if err != nil {
+			return 0, err
+		}
+
+		return binary(n.Oper, left, right)
+
+	case parse.TernaryNode:
+		cond, err := in.eval(f, n.Cond)
+		if err != nil {
+			return 0, err
+		}
+
+		if cond != 0 && n.Elvis() {
+			return cond, nil
+		} else if cond != 0 {
+			return in.eval(f, n.TrueBody)
+		}
+		return in.eval(f, n.FalseBody)
+
+	case parse.FunctionCallNode:
+		callee, err := in.eval(f, n.Callable)
+		if err != nil {
+			return 0, err
+		}
+
+		args := make([]int, len(n.Args))
+		for i, arg := range n.Args {
+			if args[i], err = in.eval(f, arg); err != nil {
+				return 0, err
+			}
+		}
+
+		return in.call(callee, args)
+	}
+
+	return 0, fmt.Errorf("unexpected expression: %v", node)
+}
+
+func (in *Interpreter) unary(f *frame, n parse.UnaryNode) (int, error) {
+	switch n.Oper {
+	case "&":
+		return in.address(f, n.Node)
+
+	case "*":
+		addr, err := in.address(f, n)
+		if err != nil {
+			return 0, err
+		}
+		return in.Load(addr)
+
+	case "++", "--":
+		addr, err := in.address(f, n.Node)
+		if err != nil {
+			return 0, err
+		}
+
+		old, err := in.Load(addr)
+		if err != nil {
+			return 0, err
+		}
+
+		value := old + 1
+		if n.Oper == "--" {
+			value = old - 1
+		}
+		in.mem[addr] = value
+
+		if n.Postfix {
+			return old, nil
+		}
+		return value, nil
+	}
+
+	value, err := in.eval(f, n.Node)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.Oper {
+	case "-":
+		return -value, nil
+	case "!":
+		return truth(value == 0), nil
+	case "~":
+		return ^value, nil
+	}
+
+	return 0, fmt.Errorf("unexpected operator: %s", n.Oper)
+}
+
+// Assign with `=` or a compound operator such as `=+`
+func (in *Interpreter) assign(f *frame, n parse.AssignNode) (int, error) {
+	addr, err := in.address(f, n.Lhs)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := in.eval(f, n.Rhs)
+	if err != nil {
+		return 0, err
+	}
+
+	if n.Op != "=" {
+		old, err := in.Load(addr)
+		if err != nil {
+			return 0, err
+		}
+
+		if value, err = binary(n.Op[1:], old, value); err != nil {
+			return 0, err
+		}
+	}
+
+	return value, in.Store(addr, value)
+}
+
+// Address of a variable, vector element or dereferenced pointer
+func (in *Interpreter) address(f *frame, node parse.Node) (int, error) {
+	switch n := node.(type) {
+	case parse.IdentNode:
+		if addr, ok := in.variable(f, n.Value); ok {
+			return addr, nil
+		} else if addr, ok := in.funcAddrs[n.Value]; ok {
+			return addr, nil
+		}
+		return 0, fmt.Errorf("%s is not a variable", n.Value)
+
+	case parse.ParenNode:
+		return in.address(f, n.Node)
+
+	case parse.UnaryNode:
+		if n.Oper == "*" {
+			return in.eval(f, n.Node)
+		}
+
+	case parse.ArrayAccessNode:
+		vec, err := in.eval(f, n.Array)
+		if err != nil {
+			return 0, err
+		}
+
+		index, err := in.eval(f, n.Index)
+		if err != nil {
+			return 0, err
+		}
+
+		return vec + index, nil
+	}
+
+	return 0, fmt.Errorf("%v is not an lvalue", node)
+}
+
+// Address of a local or global variable
+func (in *Interpreter) variable(f *frame, name string) (int, bool) {
+	if addr, ok := f.vars[name]; ok {
+		return addr, true
+	}
+
+	addr, ok := in.globals[name]
+	return addr, ok
+}
+
+func binary(oper string, a, b int) (int, error) {
+	switch oper {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		} else if oper == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "<<":
+		return a << uint(b), nil
+	case ">>":
+		return a >> uint(b), nil
+	case "&":
+		return a & b, nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "==":
+		return truth(a == b), nil
+	case "!=":
+		return truth(a != b), nil
+	case "<":
+		return truth(a < b), nil
+	case "<=":
+		return truth(a <= b), nil
+	case ">":
+		return truth(a > b), nil
+	case ">=":
+		return truth(a >= b), nil
+	}
+
+	return 0, fmt.Errorf("unexpected operator: %s", oper)
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -10,7 +10,7 @@ convert(s,v) {
   auto m,i,j,c,sign;
 
   i = 0; /* vector index */
-  j =-1; /* character index */
+  j = -1; /* character index */
 
 init: /* initialize to convert an integer */
   m = 0; /* the integer value */
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	opt "github.com/droundy/goopt"
 	"github.com/erik/gob/emit"
 	"github.com/erik/gob/parse"
+	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strings"
 )
 
 const GOB_VERSION = "0.0.0"
@@ -17,6 +21,26 @@ var (
 	parseOnly = opt.Flag([]string{"-p", "--parse-only"}, []string{},
 		"Don't output anything, just parse", "")
 	outFile = opt.String([]string{"-o"}, "", "Name of output file")
+	dialect = opt.String([]string{"--dialect"}, "classic",
+		"Dialect of B to accept: classic or modern")
+	warnEmptyLoop = opt.Flag([]string{"--warn-empty-loop"}, []string{},
+		"Warn about loops with an empty body", "")
+	warnNoReturn = opt.Flag([]string{"--warn-missing-return"}, []string{},
+		"Warn about functions which can end without returning", "")
+	warnUninit = opt.Flag([]string{"--warn-uninitialized"}, []string{},
+		"Warn about autos which may be read before being assigned", "")
+	warnArity = opt.Flag([]string{"--warn-arity"}, []string{},
+		"Warn about calls passing the wrong number of arguments", "")
+	warnUnreachable = opt.Flag([]string{"--warn-unreachable"}, []string{},
+		"Warn about statements which can never run", "")
+	showMetrics = opt.Flag([]string{"--metrics"}, []string{},
+		"Print size and complexity metrics for each function as JSON", "")
+	dumpAST = opt.Flag([]string{"--dump-ast"}, []string{},
+		"Print each global and function as an S-expression", "")
+	dumpDOT = opt.Flag([]string{"--dump-dot"}, []string{},
+		"Print each global and function as a Graphviz digraph", "")
+	tagsFile = opt.String([]string{"--tags"}, "",
+		"Write a ctags style index of functions and globals to this file")
 )
 
 func main() {
@@ -32,21 +56,32 @@ func main() {
 		return
 	}
 
+	config, err := parse.Dialect(*dialect)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var tags []string
+
 	for _, name := range opt.Args {
 		if len(opt.Args) > 1 {
 			fmt.Printf("==== %s ====\n", name)
 		}
 
-		file, err := os.Open(name)
+		src, err := ioutil.ReadFile(name)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		parser := parse.NewParser(name, file)
+		parser := parse.NewParserWithConfig(name,
+			strings.NewReader(string(src)), config)
 
 		unit, err := parser.Parse()
-		if err != nil {
+		if perr, ok := err.(*parse.ParseError); ok {
+			fmt.Println(perr.PrettyError(string(src)))
+		} else if err != nil {
 			fmt.Println(err)
 		}
 
@@ -54,6 +89,54 @@ func main() {
 			fmt.Println(err)
 		}
 
+		for _, err := range parse.Analyze(unit) {
+			fmt.Println(err)
+		}
+
+		lint := parse.LintOptions{
+			EmptyLoopBody: *warnEmptyLoop,
+			MissingReturn: *warnNoReturn,
+			Uninitialized: *warnUninit,
+			Arity:         *warnArity,
+			Unreachable:   *warnUnreachable,
+		}
+		for _, warning := range unit.Lint(lint) {
+			fmt.Println(warning)
+		}
+
+		if *showMetrics {
+			metrics := make([]parse.FunctionMetrics, len(unit.Funcs))
+			for i, fn := range unit.Funcs {
+				metrics[i] = parse.Metrics(fn)
+			}
+
+			out, _ := json.MarshalIndent(metrics, "", "  ")
+			fmt.Println(string(out))
+		}
+
+		if *dumpAST {
+			for _, v := range unit.Vars {
+				fmt.Println(parse.SExpr(v))
+			}
+			for _, fn := range unit.Funcs {
+				fmt.Println(parse.SExpr(fn))
+			}
+		}
+
+		if *dumpDOT {
+			for _, v := range unit.Vars {
+				fmt.Print(parse.DOT(v))
+			}
+			for _, fn := range unit.Funcs {
+				fmt.Print(parse.DOT(fn))
+			}
+		}
+
+		for _, tag := range parse.Tags(unit) {
+			tags = append(tags, fmt.Sprintf("%s\t%s\t%d;\"\tkind:%s",
+				tag.Name, name, tag.Pos.Line, tag.Kind))
+		}
+
 		if *parseOnly {
 			continue
 		}
@@ -64,7 +147,8 @@ func main() {
 			outName = path.Base(name) + ".c"
 		}
 
-		if file, err = os.Create(outName); err != nil {
+		file, err := os.Create(outName)
+		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
@@ -74,4 +158,23 @@ func main() {
 
 		file.Close()
 	}
+
+	if *tagsFile != "" {
+		writeTags(*tagsFile, tags)
+	}
+}
+
+// Write tags sorted by name, as editors expect
+func writeTags(name string, tags []string) {
+	sort.Strings(tags)
+
+	out := "!_TAG_FILE_SORTED\t1\t//\n"
+	for _, tag := range tags {
+		out += tag + "\n"
+	}
+
+	if err := ioutil.WriteFile(name, []byte(out), 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -3,6 +3,7 @@ package parse
 import (
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 type SemanticError struct {
@@ -18,10 +19,79 @@ func NewSemanticError(node Node, msg string) error {
 	return &SemanticError{node, msg}
 }
 
+// Like a SemanticError, but for code that is legal and merely suspicious
+type SemanticWarning struct {
+	node Node
+	msg  string
+}
+
+func (s *SemanticWarning) Error() string {
+	return fmt.Sprintf("Warning on `%v`: %v", s.node, s.msg)
+}
+
+func NewSemanticWarning(node Node, msg string) error {
+	return &SemanticWarning{node, msg}
+}
+
+// Optional checks, all off by default
+type LintOptions struct {
+	EmptyLoopBody bool // `while(x);` may be a misplaced semicolon
+	MissingReturn bool // Function can reach its end without a return
+	Uninitialized bool // Auto is read before anything is assigned to it
+	Arity         bool // Call to a function of the unit with the wrong argument count
+	Unreachable   bool // Statement follows a return, goto, break or the like
+}
+
+// Functions provided by the B runtime library
+var Builtins = map[string]bool{
+	"char":    true,
+	"exit":    true,
+	"getchar": true,
+	"lchar":   true,
+	"printf":  true,
+	"putchar": true,
+}
+
+// Name of the runtime function n calls, if it is a direct call to one of
+// the unit's builtins which the unit doesn't define itself. Backends can
+// use this to expand calls like `char(s, i)` inline.
+func (t TranslationUnit) BuiltinCall(n Node) (string, bool) {
+	call, ok := n.(FunctionCallNode)
+	if !ok {
+		return "", false
+	}
+
+	ident, ok := call.Callable.(IdentNode)
+	if !ok {
+		return "", false
+	}
+
+	builtins := t.Builtins
+	if builtins == nil {
+		builtins = Builtins
+	}
+
+	if !builtins[ident.Value] {
+		return "", false
+	}
+
+	for _, fn := range t.Funcs {
+		if fn.Name == ident.Value {
+			return "", false
+		}
+	}
+
+	return ident.Value, true
+}
+
 type TranslationUnit struct {
 	File  string
 	Funcs []FunctionNode
 	Vars  []Node
+
+	// Dialect the unit was parsed in. Fields left unset fall back to
+	// those of ClassicConfig, such as Builtins if Builtins is nil.
+	Config
 }
 
 func (t TranslationUnit) String() string {
@@ -40,6 +110,37 @@ func (t TranslationUnit) String() string {
 	return str
 }
 
+// Function of the unit with the given name. If more than one function has
+// the name, the first is returned, and Verify reports the rest.
+func (t TranslationUnit) Function(name string) (FunctionNode, bool) {
+	for _, fn := range t.Funcs {
+		if fn.Name == name {
+			return fn, true
+		}
+	}
+
+	return FunctionNode{}, false
+}
+
+// Global variable or vector of the unit with the given name, the
+// ExternVarInitNode or ExternVecInitNode defining it
+func (t TranslationUnit) Variable(name string) (Node, bool) {
+	for _, v := range t.Vars {
+		switch node := v.(type) {
+		case ExternVarInitNode:
+			if node.Name == name {
+				return node, true
+			}
+		case ExternVecInitNode:
+			if node.Name == name {
+				return node, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
 func (t TranslationUnit) Verify() error {
 
 	if err := t.ResolveDuplicates(); err != nil {
@@ -65,18 +166,28 @@ func (t TranslationUnit) Verify() error {
 }
 
 func (t TranslationUnit) expectLHS(node Node) error {
-	switch node.(type) {
-	case ArrayAccessNode, IdentNode:
+	if isLvalue(node) {
 		return nil
-	case UnaryNode:
-		if node.(UnaryNode).Oper == "*" {
-			return nil
-		}
 	}
 
 	return NewSemanticError(node, "expected lvalue")
 }
 
+// Whether node can be assigned to: a name, a vector element or a
+// dereferenced pointer, in any number of parentheses, as in `(*p)++`
+func isLvalue(node Node) bool {
+	switch node := node.(type) {
+	case ArrayAccessNode, IdentNode:
+		return true
+	case ParenNode:
+		return isLvalue(node.Node)
+	case UnaryNode:
+		return node.Oper == "*"
+	}
+
+	return false
+}
+
 func (t TranslationUnit) expectRHS(node Node) error {
 	if IsExpr(node) {
 		return nil
@@ -154,6 +265,22 @@ func (t TranslationUnit) visitExpressions(node Node, visit func(Node) error) err
 			}
 		}
 
+	case ForNode:
+		for _, expr := range []Node{node.(ForNode).Init,
+			node.(ForNode).Cond, node.(ForNode).Step} {
+			if _, ok := expr.(NullNode); ok {
+				continue
+			}
+
+			if err := visit(expr); err != nil {
+				return err
+			}
+		}
+
+		if err := t.visitExpressions(node.(ForNode).Body, visit); err != nil {
+			return err
+		}
+
 	case WhileNode:
 		if err := visit(node.(WhileNode).Cond); err != nil {
 			return err
@@ -199,6 +326,10 @@ func (t TranslationUnit) visitStatements(node Node, visit func(Node) error) erro
 		}
 
 	case IfNode:
+		if err := visit(node); err != nil {
+			return err
+		}
+
 		if err := t.visitStatements(node.(IfNode).Body, visit); err != nil {
 			return err
 		}
@@ -209,12 +340,15 @@ func (t TranslationUnit) visitStatements(node Node, visit func(Node) error) erro
 			}
 		}
 
-	case BreakNode, ExternVarDeclNode, ExternVarInitNode,
+	case BreakNode, ContinueNode, ExternVarDeclNode, ExternVarInitNode,
 		ExternVecInitNode, LabelNode, ReturnNode, StatementNode, VarDeclNode:
 		if err := visit(node); err != nil {
 			return err
 		}
 	case SwitchNode:
+		if err := visit(node); err != nil {
+			return err
+		}
 
 		for _, stmt := range node.(SwitchNode).DefaultCase {
 			if err := t.visitStatements(stmt, visit); err != nil {
@@ -228,7 +362,20 @@ func (t TranslationUnit) visitStatements(node Node, visit func(Node) error) erro
 			}
 		}
 
+	case ForNode:
+		if err := visit(node); err != nil {
+			return err
+		}
+
+		if err := t.visitStatements(node.(ForNode).Body, visit); err != nil {
+			return err
+		}
+
 	case WhileNode:
+		if err := visit(node); err != nil {
+			return err
+		}
+
 		if err := t.visitStatements(node.(WhileNode).Body, visit); err != nil {
 			return err
 		}
@@ -243,23 +390,9 @@ func (t TranslationUnit) VerifyFunction(fn FunctionNode) error {
 		return err
 	}
 
-	// Ensure variables are declared at the beginning of functions
-	endDecls := false
-
-	visiter := func(stmt Node) error {
-		switch stmt.(type) {
-		case ExternVarDeclNode, VarDeclNode:
-			if endDecls {
-				return NewSemanticError(stmt, "var declaration in middle of block")
-			}
-		default:
-			endDecls = true
-		}
-		return nil
-	}
-
-	if err := t.visitStatements(fn.Body, visiter); err != nil {
-		return err
+	// Ensure variables are declared at the beginning of each block
+	if errs := CheckDeclOrder(fn); len(errs) > 0 {
+		return errs[0]
 	}
 
 	return nil
@@ -273,14 +406,12 @@ func (t TranslationUnit) VerifyAssignments(fn FunctionNode) error {
 		if !ok {
 			return nil
 		}
-		if bin, ok := stmt.Expr.(BinaryNode); ok {
-			if bin.Oper == "=" {
-				if err := t.expectLHS(bin.Left); err != nil {
-					return err
-				}
-				if err := t.expectRHS(bin.Right); err != nil {
-					return err
-				}
+		if assign, ok := stmt.Expr.(AssignNode); ok && assign.Op == "=" {
+			if err := t.expectLHS(assign.Lhs); err != nil {
+				return err
+			}
+			if err := t.expectRHS(assign.Rhs); err != nil {
+				return err
 			}
 		}
 
@@ -292,65 +423,508 @@ func (t TranslationUnit) VerifyAssignments(fn FunctionNode) error {
 
 // TODO: resolve auto variable declarations within function definitions
 func (t TranslationUnit) ResolveDuplicates() error {
-	idents := map[string]Node{}
-
-	for _, fn := range t.Funcs {
-		if _, ok := idents[fn.Name]; ok {
-			return NewSemanticError(fn, "Duplicate function name")
-		}
-
-		idents[fn.Name] = fn
-	}
-
 	for _, v := range t.Vars {
-		var name string
-
 		switch v.(type) {
-		case ExternVecInitNode:
-			name = v.(ExternVecInitNode).Name
-		case ExternVarInitNode:
-			name = v.(ExternVarInitNode).Name
+		case ExternVecInitNode, ExternVarInitNode:
 		default:
 			return NewSemanticError(v, "Not variable init")
 		}
+	}
 
-		if _, ok := idents[name]; ok {
-			return NewSemanticError(v, "Duplicate variable name")
-		}
-
-		idents[name] = v
+	if errs := t.CheckDuplicates(); len(errs) > 0 {
+		return errs[0]
 	}
 
 	return nil
 }
 
-// Make sure all goto jump to valid places
+// Report every name defined more than once at the top level of the unit,
+// whether as two functions, two globals, or a function and a global. The
+// first definition is taken to be the real one, and each later one gets
+// an error. ResolveDuplicates reports the first of these.
+func (t TranslationUnit) CheckDuplicates() []error {
+	var errors []error
+
+	first := map[string]Node{}
+	define := func(name string, def Node) {
+		if prev, ok := first[name]; ok {
+			pos, prevPos := def.Position(), prev.Position()
+			errors = append(errors, NewSemanticError(
+				IdentNode{baseNode: baseNode{pos}, Value: name},
+				fmt.Sprintf("%s defined twice at %d:%d, first at %d:%d", name,
+					pos.Line, pos.Column, prevPos.Line, prevPos.Column)))
+			return
+		}
+
+		first[name] = def
+	}
+
+	// Globals come first in the source as often as not, but are kept
+	// apart, so go through the definitions in the order they appear
+	var defs []Node
+	for _, v := range t.Vars {
+		defs = append(defs, v)
+	}
+	for _, fn := range t.Funcs {
+		defs = append(defs, fn)
+	}
+	sort.SliceStable(defs, func(i, j int) bool {
+		a, b := defs[i].Position(), defs[j].Position()
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	for _, def := range defs {
+		switch def := def.(type) {
+		case FunctionNode:
+			define(def.Name, def)
+		case ExternVarInitNode:
+			define(def.Name, def)
+		case ExternVecInitNode:
+			define(def.Name, def)
+		}
+	}
+
+	return errors
+}
+
+// Make sure all goto jump to valid places, and that no label is defined
+// twice. Labels are visible throughout the function, including before
+// their definition and inside nested blocks and switch cases.
 func (t TranslationUnit) ResolveLabels(fn FunctionNode) error {
-	labels := map[string]bool{}
+	labels := map[string]LabelNode{}
 	gotos := []GotoNode{}
 
-	visiter := func(node Node) error {
-		switch node.(type) {
+	var err error
+
+	Walk(fn.Body, func(node Node) bool {
+		switch node := node.(type) {
 		case LabelNode:
-			if _, ok := labels[node.(LabelNode).Name]; ok {
-				return NewSemanticError(node, "duplicate label definition")
+			if first, ok := labels[node.Name]; ok && err == nil {
+				err = NewSemanticError(node, fmt.Sprintf(
+					"duplicate label at %d:%d, first defined at %d:%d",
+					node.Pos.Line, node.Pos.Column,
+					first.Pos.Line, first.Pos.Column))
+			} else if !ok {
+				labels[node.Name] = node
 			}
-			labels[node.(LabelNode).Name] = true
 		case GotoNode:
-			gotos = append(gotos, node.(GotoNode))
+			gotos = append(gotos, node)
 		}
-		return nil
-	}
+		return true
+	})
 
-	if err := t.visitStatements(fn, visiter); err != nil {
+	if err != nil {
 		return err
 	}
 
 	for _, node := range gotos {
-		if _, ok := labels[node.Label]; !ok {
-			return NewSemanticError(node, "unresolved goto")
+		// Computed targets can only be checked at run time
+		name, named := node.Name()
+		if _, ok := labels[name]; named && !ok {
+			return NewSemanticError(node, fmt.Sprintf(
+				"undefined label at %d:%d", node.Pos.Line, node.Pos.Column))
 		}
 	}
 
 	return nil
 }
+
+// Make sure every break is inside a loop or switch, and every continue
+// inside a loop, at any depth. Returns an error for each one which isn't.
+func CheckBreaks(fn FunctionNode) []error {
+	var errors []error
+
+	var visit func(n Node, inSwitch, inLoop bool)
+	visit = func(n Node, inSwitch, inLoop bool) {
+		switch node := n.(type) {
+		case BreakNode:
+			if !inSwitch && !inLoop {
+				errors = append(errors, NewSemanticError(node, fmt.Sprintf(
+					"break outside of a loop or switch at %d:%d",
+					node.Pos.Line, node.Pos.Column)))
+			}
+		case ContinueNode:
+			if !inLoop {
+				errors = append(errors, NewSemanticError(node, fmt.Sprintf(
+					"continue outside of a loop at %d:%d",
+					node.Pos.Line, node.Pos.Column)))
+			}
+		case ForNode, WhileNode:
+			inLoop = true
+		case SwitchNode:
+			inSwitch = true
+		}
+
+		for _, child := range children(n) {
+			visit(child, inSwitch, inLoop)
+		}
+	}
+
+	visit(fn.Body, false, false)
+
+	return errors
+}
+
+// Make sure the operand of every &, ++ and -- is an lvalue, so `&1` and
+// `++5` are caught. Parentheses around the operand are allowed, as in
+// `(*p)++`, just as around the left side of an assignment. Returns an
+// error for each one which isn't.
+func CheckLvalues(fn FunctionNode) []error {
+	var errors []error
+
+	Walk(fn.Body, func(n Node) bool {
+		unary, ok := n.(UnaryNode)
+		if !ok || (unary.Oper != "&" && unary.Oper != "++" && unary.Oper != "--") {
+			return true
+		}
+
+		if !isLvalue(unary.Node) {
+			errors = append(errors, NewSemanticError(unary, fmt.Sprintf(
+				"%s of non-lvalue at %d:%d",
+				unary.Oper, unary.Pos.Line, unary.Pos.Column)))
+		}
+
+		return true
+	})
+
+	return errors
+}
+
+// Make sure the auto and extrn declarations of each block come before any
+// other statement, as B requires. Returns an error for each declaration
+// which doesn't. VerifyFunction reports the first of these.
+func CheckDeclOrder(fn FunctionNode) []error {
+	var errors []error
+
+	Walk(fn.Body, func(n Node) bool {
+		block, ok := n.(BlockNode)
+		if !ok {
+			return true
+		}
+
+		started := false
+
+		for _, stmt := range block.Nodes {
+			switch stmt.(type) {
+			case VarDeclNode, ExternVarDeclNode:
+				if started {
+					pos := stmt.Position()
+					errors = append(errors, NewSemanticError(stmt, fmt.Sprintf(
+						"declaration after a statement at %d:%d",
+						pos.Line, pos.Column)))
+				}
+			default:
+				started = true
+			}
+		}
+
+		return true
+	})
+
+	return errors
+}
+
+// Make sure no two cases of a switch have the same value, counting
+// characters by their packed value, so `case 'a':` and `case 97:` clash.
+// Returns an error for each repeat.
+func CheckSwitch(s SwitchNode) []error {
+	return CheckSwitchWithConfig(s, Config{})
+}
+
+// Check the cases of s for repeats as CheckSwitch does, folding labels
+// such as `case -1:` and decoding characters as config's dialect does
+func CheckSwitchWithConfig(s SwitchNode, config Config) []error {
+	var errors []error
+
+	seen := map[int64]CaseNode{}
+
+	for _, c := range s.Cases {
+		folded, err := FoldWithConfig(c.Cond, config)
+		if err != nil {
+			continue
+		}
+
+		value, ok := constantValue(folded, config)
+		if !ok {
+			continue
+		}
+
+		if first, ok := seen[value]; ok {
+			errors = append(errors, NewSemanticError(c, fmt.Sprintf(
+				"duplicate case %v at %d:%d, first at %d:%d", c.Cond,
+				c.Pos.Line, c.Pos.Column, first.Pos.Line, first.Pos.Column)))
+		} else {
+			seen[value] = c
+		}
+	}
+
+	return errors
+}
+
+// Collect warnings for the checks enabled in opts
+func (t TranslationUnit) Lint(opts LintOptions) []error {
+	var warnings []error
+
+	visit := func(node Node) error {
+		var body Node
+
+		switch loop := node.(type) {
+		case ForNode:
+			body = loop.Body
+		case WhileNode:
+			body = loop.Body
+		}
+
+		if _, ok := body.(NullNode); ok && opts.EmptyLoopBody {
+			warnings = append(warnings,
+				NewSemanticWarning(node, "empty loop body"))
+		}
+
+		return nil
+	}
+
+	for _, fn := range t.Funcs {
+		t.visitStatements(fn.Body, visit)
+
+		// Without a return, the caller gets whatever was left behind
+		if opts.MissingReturn && canComplete(fn.Body) {
+			warnings = append(warnings, NewSemanticWarning(
+				IdentNode{baseNode: fn.baseNode, Value: fn.Name},
+				"may reach the end without returning"))
+		}
+
+		if opts.Uninitialized {
+			for _, ident := range uninitializedReads(fn) {
+				warnings = append(warnings, NewSemanticWarning(ident,
+					fmt.Sprintf("may be used uninitialized at %d:%d",
+						ident.Pos.Line, ident.Pos.Column)))
+			}
+		}
+
+		if opts.Arity {
+			warnings = append(warnings, t.CheckArity(fn)...)
+		}
+
+		if opts.Unreachable {
+			warnings = append(warnings, CheckUnreachable(fn)...)
+		}
+	}
+
+	return warnings
+}
+
+// Warn about calls in fn to a function defined in the unit which pass a
+// different number of arguments than it has parameters. B doesn't
+// require them to match, so these are warnings. Calls through a variable
+// or to functions defined elsewhere aren't checked.
+func (t TranslationUnit) CheckArity(fn FunctionNode) []error {
+	var warnings []error
+
+	funcs := map[string]FunctionNode{}
+	for _, f := range t.Funcs {
+		funcs[f.Name] = f
+	}
+
+	locals := localNames(fn)
+
+	Walk(fn.Body, func(n Node) bool {
+		call, ok := n.(FunctionCallNode)
+		if !ok {
+			return true
+		}
+
+		ident, ok := call.Callable.(IdentNode)
+		if !ok || locals[ident.Value] {
+			return true
+		}
+
+		if callee, ok := funcs[ident.Value]; ok && len(call.Args) != len(callee.Params) {
+			warnings = append(warnings, NewSemanticWarning(call, fmt.Sprintf(
+				"%s takes %d arguments, called with %d at %d:%d",
+				callee.Name, len(callee.Params), len(call.Args),
+				call.Pos.Line, call.Pos.Column)))
+		}
+
+		return true
+	})
+
+	return warnings
+}
+
+// Warn about statements in fn which can't be reached, because they follow
+// a statement which never completes, such as a return, goto or break,
+// with no label in between. Only the first statement of each dead run is
+// reported.
+func CheckUnreachable(fn FunctionNode) []error {
+	var warnings []error
+
+	check := func(stmts []Node) {
+		reachable := true
+
+		for _, stmt := range stmts {
+			switch stmt.(type) {
+			case LabelNode:
+				reachable = true
+			case NullNode:
+				// Stray semicolons aren't worth a warning
+			default:
+				if !reachable {
+					pos := stmt.Position()
+					warnings = append(warnings, NewSemanticWarning(stmt,
+						fmt.Sprintf("unreachable at %d:%d", pos.Line, pos.Column)))
+				}
+				reachable = canComplete(stmt)
+			}
+		}
+	}
+
+	Walk(fn.Body, func(n Node) bool {
+		switch node := n.(type) {
+		case BlockNode:
+			check(node.Nodes)
+		case CaseNode:
+			check(node.Statements)
+		case SwitchNode:
+			check(node.DefaultCase)
+		}
+
+		return true
+	})
+
+	return warnings
+}
+
+// Best effort check of whether control can run off the end of a
+// statement. A return, goto, break, call to exit, or loop with a constant
+// true condition and no break doesn't complete. Anything after one of
+// those is unreachable until the next label.
+func canComplete(node Node) bool {
+	switch node := node.(type) {
+	case BlockNode:
+		reachable := true
+
+		for _, stmt := range node.Nodes {
+			if _, ok := stmt.(LabelNode); ok {
+				reachable = true
+			} else if reachable {
+				reachable = canComplete(stmt)
+			}
+		}
+
+		return reachable
+
+	case BreakNode, ContinueNode, GotoNode, ReturnNode:
+		return false
+
+	case IfNode:
+		return !node.HasElse || canComplete(node.Body) ||
+			canComplete(node.ElseBody)
+
+	case ForNode:
+		if _, ok := node.Cond.(NullNode); ok || isTrue(node.Cond) {
+			return breaks(node.Body)
+		}
+
+	case StatementNode:
+		if call, ok := node.Expr.(FunctionCallNode); ok {
+			if ident, ok := call.Callable.(IdentNode); ok && ident.Value == "exit" {
+				return false
+			}
+		}
+
+	case WhileNode:
+		if isTrue(node.Cond) {
+			return breaks(node.Body)
+		}
+	}
+
+	return true
+}
+
+// Whether an expression is a constant which is always true
+func isTrue(node Node) bool {
+	switch node := node.(type) {
+	case CharacterNode:
+		return packCharacter(node.Value, Config{}) != 0
+	case IntegerNode:
+		return node.Value != 0
+	case ParenNode:
+		return isTrue(node.Node)
+	}
+
+	return false
+}
+
+// Whether a loop body contains a break out of that loop, rather than one
+// belonging to a nested loop or switch
+func breaks(node Node) bool {
+	switch node.(type) {
+	case BreakNode:
+		return true
+	case ForNode, SwitchNode, WhileNode:
+		return false
+	}
+
+	for _, child := range children(node) {
+		if breaks(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Names referenced by the unit which it doesn't define itself and which
+// aren't builtins, so must be provided by another unit at link time.
+func RequiredExterns(unit TranslationUnit) []string {
+	builtins := unit.Builtins
+	if builtins == nil {
+		builtins = Builtins
+	}
+
+	defined := map[string]bool{}
+
+	for _, fn := range unit.Funcs {
+		defined[fn.Name] = true
+	}
+
+	for _, v := range unit.Vars {
+		switch v := v.(type) {
+		case ExternVarInitNode:
+			defined[v.Name] = true
+		case ExternVecInitNode:
+			defined[v.Name] = true
+		}
+	}
+
+	required := map[string]bool{}
+
+	for _, fn := range unit.Funcs {
+		locals := localNames(fn)
+
+		Walk(fn.Body, func(n Node) bool {
+			if label, ok := n.(LabelNode); ok {
+				locals[label.Name] = true
+			}
+			return true
+		})
+
+		Walk(fn.Body, func(n Node) bool {
+			if ident, ok := n.(IdentNode); ok {
+				name := ident.Value
+				if !locals[name] && !defined[name] && !builtins[name] {
+					required[name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	names := make([]string, 0, len(required))
+	for name := range required {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -36,35 +37,647 @@ func TestAnalyzeDuplicate(t *testing.T) {
 	}
 }
 
+func TestCheckDuplicates(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+main() return (0);
+count 1;
+main() return (1);
+count(x) return (x);
+names [1] 2;
+unique() return (names);
+`)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var errs []string
+	for _, err := range unit.CheckDuplicates() {
+		errs = append(errs, err.Error())
+	}
+
+	expected := []string{
+		"Semantic error on `main`: main defined twice at 4:1, first at 2:1",
+		"Semantic error on `count`: count defined twice at 5:1, first at 3:1",
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Expected %q, got %q", expected, errs)
+	}
+
+	if err := unit.ResolveDuplicates(); err == nil || err.Error() != expected[0] {
+		t.Errorf("Expected ResolveDuplicates to report %q, got %v", expected[0], err)
+	}
+
+	// Verify reports duplicates, so Analyze doesn't repeat them
+	if errs := Analyze(unit); len(errs) != 0 {
+		t.Errorf("Expected no errors from Analyze, got %v", errs)
+	}
+}
+
+func TestUnitLookup(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+count 3;
+names [2] "a", "b";
+main() return (count);
+`)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if fn, ok := unit.Function("main"); !ok || fn.Name != "main" {
+		t.Errorf("Expected main, got %v, %v", fn, ok)
+	}
+	if _, ok := unit.Function("count"); ok {
+		t.Errorf("Found a variable as a function")
+	}
+
+	if v, ok := unit.Variable("count"); !ok || v.(ExternVarInitNode).Name != "count" {
+		t.Errorf("Expected count, got %v, %v", v, ok)
+	}
+	if v, ok := unit.Variable("names"); !ok || v.(ExternVecInitNode).Size != 2 {
+		t.Errorf("Expected names, got %v, %v", v, ok)
+	}
+	if _, ok := unit.Variable("main"); ok {
+		t.Errorf("Found a function as a variable")
+	}
+}
+
 func TestLHS(t *testing.T) {
 	var unit TranslationUnit
 
 	// Simple lhs cases
-	if err := unit.expectLHS(IdentNode{"foo"}); err != nil {
+	if err := unit.expectLHS(IdentNode{Value: "foo"}); err != nil {
 		t.Errorf("ident node LHS")
 	}
-	if err := unit.expectLHS(ArrayAccessNode{IdentNode{"abc"}, IntegerNode{2}}); err != nil {
+	if err := unit.expectLHS(ArrayAccessNode{Array: IdentNode{Value: "abc"},
+		Index: IntegerNode{Value: 2}}); err != nil {
 		t.Errorf("array access lhs")
 	}
-	if err := unit.expectLHS(UnaryNode{"*", IntegerNode{1}, false}); err != nil {
+	if err := unit.expectLHS(UnaryNode{Oper: "*", Node: IntegerNode{Value: 1}, Postfix: false}); err != nil {
 		t.Errorf("unary node lhs")
 	}
 }
 
+func TestVerifyFunctionAddress(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+main() { auto p; p = &main; }`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	stmt := unit.Funcs[0].Body.(BlockNode).Nodes[1].(StatementNode)
+	expected := AssignNode{Lhs: IdentNode{Value: "p"}, Op: "=",
+		Rhs: UnaryNode{Oper: "&", Node: IdentNode{Value: "main"}, Postfix: false}}
+
+	if !Equal(stmt.Expr, expected) {
+		t.Errorf("Expected %v, got %v", expected, stmt.Expr)
+	}
+
+	if err := unit.Verify(); err != nil {
+		t.Errorf("Address of function rejected: %v", err)
+	}
+}
+
+func TestVerifyNestedDeclarations(t *testing.T) {
+	valid := []string{
+		"f() { if (x) { auto y; y = 1; } }",
+		"f() { while (1) { auto y; y = 1; } }",
+		"f() { auto a; a = 1; { extrn b; auto c; c = b; } }",
+		"f() { switch (x) { case 1: { auto y; } } }",
+	}
+
+	for _, src := range valid {
+		unit, err := NewParser("", strings.NewReader(src)).Parse()
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", src, err)
+		} else if err := unit.Verify(); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+
+	invalid := []string{
+		"f() { a = 1; auto b; }",
+		"f() { if (x) { y = 1; auto z; } }",
+	}
+
+	for _, src := range invalid {
+		unit, err := NewParser("", strings.NewReader(src)).Parse()
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", src, err)
+		} else if err := unit.Verify(); err == nil {
+			t.Errorf("%s: late declaration accepted", src)
+		}
+	}
+}
+
+func TestVerifyDeclOrderMatchesCheck(t *testing.T) {
+	tests := []string{
+		"g() { auto a; a = 1; { auto b; b = a; } }",
+		"k(x) { auto a; a = x; auto b; }",
+	}
+
+	for _, src := range tests {
+		unit, err := NewParser("", strings.NewReader(src)).Parse()
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", src, err)
+		}
+
+		errs := CheckDeclOrder(unit.Funcs[0])
+		err = unit.Verify()
+
+		if len(errs) == 0 && err != nil {
+			t.Errorf("%s: rejected by Verify only: %v", src, err)
+		} else if len(errs) > 0 && (err == nil || err.Error() != errs[0].Error()) {
+			t.Errorf("%s: Verify reported %v, CheckDeclOrder %v", src, err, errs[0])
+		}
+	}
+}
+
+func TestResolveLabels(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+valid() {
+	goto end;
+	switch (x) { case 1: again: goto again; }
+end:
+	;
+}
+undefined() {
+	if (x)
+		goto nowhere;
+	goto *x;
+}
+duplicate() {
+one: ;
+	{
+	  one: ;
+	}
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if err := unit.ResolveLabels(unit.Funcs[0]); err != nil {
+		t.Errorf("Valid labels: %v", err)
+	}
+
+	if err := unit.ResolveLabels(unit.Funcs[1]); err == nil ||
+		!strings.Contains(err.Error(), "undefined label at 10:3") {
+		t.Errorf("Undefined label: %v", err)
+	}
+
+	if err := unit.ResolveLabels(unit.Funcs[2]); err == nil ||
+		!strings.Contains(err.Error(), "duplicate label at 16:4, first defined at 14:1") {
+		t.Errorf("Duplicate label: %v", err)
+	}
+}
+
+func TestCheckBreaks(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+valid() {
+	while (x) {
+		while (y) break;
+		if (z) break;
+	}
+	switch (x) { case 1: break; }
+}
+invalid() {
+	if (x)
+		break;
+	while (y) ;
+	break;
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if errs := CheckBreaks(unit.Funcs[0]); len(errs) != 0 {
+		t.Errorf("Valid breaks: %v", errs)
+	}
+
+	errs := CheckBreaks(unit.Funcs[1])
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "break outside of a loop or switch at 11:3") ||
+		!strings.Contains(errs[1].Error(), "break outside of a loop or switch at 13:2") {
+		t.Errorf("Invalid breaks: %v", errs)
+	}
+}
+
+func TestCheckLvalues(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+valid(a, p) {
+	auto v[2];
+	f(&a, &v[1], &v);
+	a++;
+	--v[0];
+	(*p)++;
+	*p++;
+}
+invalid(a) {
+	f(&1, ++5);
+	(a + 1)--;
+	&f(a);
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if errs := CheckLvalues(unit.Funcs[0]); len(errs) != 0 {
+		t.Errorf("Valid lvalues: %v", errs)
+	}
+
+	var errs []string
+	for _, err := range CheckLvalues(unit.Funcs[1]) {
+		errs = append(errs, err.Error())
+	}
+
+	expected := []string{
+		"Semantic error on `&1`: & of non-lvalue at 11:4",
+		"Semantic error on `++5`: ++ of non-lvalue at 11:8",
+		"Semantic error on `(a + 1)--`: -- of non-lvalue at 12:2",
+		"Semantic error on `&f(a)`: & of non-lvalue at 13:2",
+	}
+
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Expected %q, got %q", expected, errs)
+	}
+}
+
+func TestCheckDeclOrder(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+valid(x) {
+	auto a;
+	extrn b;
+	auto c;
+	a = x;
+	if (a) {
+		auto d;
+		d = a;
+	}
+}
+invalid(x) {
+	auto a;
+	a = x;
+	auto b;
+	while (a) {
+		a--;
+		extrn c;
+	}
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if errs := CheckDeclOrder(unit.Funcs[0]); len(errs) != 0 {
+		t.Errorf("Valid declarations: %v", errs)
+	}
+
+	errs := CheckDeclOrder(unit.Funcs[1])
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "declaration after a statement at 15:2") ||
+		!strings.Contains(errs[1].Error(), "declaration after a statement at 18:3") {
+		t.Errorf("Late declarations: %v", errs)
+	}
+}
+
+func TestCheckContinues(t *testing.T) {
+	unit, err := NewParserWithConfig("", strings.NewReader(`
+valid() {
+	for (;;) {
+		switch (x) { case 1: continue; }
+		while (y) if (z) continue;
+	}
+}
+invalid() {
+	switch (x) { case 1: continue; }
+	while (y) ;
+	continue;
+}`), ModernConfig).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if errs := CheckBreaks(unit.Funcs[0]); len(errs) != 0 {
+		t.Errorf("Valid continues: %v", errs)
+	}
+
+	errs := CheckBreaks(unit.Funcs[1])
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "continue outside of a loop at 9:23") ||
+		!strings.Contains(errs[1].Error(), "continue outside of a loop at 11:2") {
+		t.Errorf("Invalid continues: %v", errs)
+	}
+}
+
+func TestCheckSwitch(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+f(x) {
+	switch (x) {
+	case 1: a();
+	case 2: b();
+	case 1: c();
+	case 'a': break;
+	case 97: break;
+	}
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	sw := unit.Funcs[0].Body.(BlockNode).Nodes[0].(SwitchNode)
+
+	errs := CheckSwitch(sw)
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "duplicate case 1 at 6:2, first at 4:2") ||
+		!strings.Contains(errs[1].Error(), "duplicate case 97 at 8:2, first at 7:2") {
+		t.Errorf("Duplicate cases: %v", errs)
+	}
+
+	if errs := Analyze(unit); len(errs) != 2 {
+		t.Errorf("Analyze: expected the duplicates, got %v", errs)
+	}
+
+	sw.Cases = sw.Cases[:2]
+	if errs := CheckSwitch(sw); len(errs) != 0 {
+		t.Errorf("Distinct cases: %v", errs)
+	}
+
+	// With case guards a negative label is an expression to fold
+	unit, err = NewParserWithConfig("", strings.NewReader(`
+f(x) {
+	switch (x) {
+	case -1: a();
+	case 1: b();
+	case -1: c();
+	case 0 - 1: break;
+	}
+}`), ModernConfig).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	errs = Analyze(unit)
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "duplicate case -1 at 6:2, first at 4:2") ||
+		!strings.Contains(errs[1].Error(), "duplicate case 0 - 1 at 7:2, first at 4:2") {
+		t.Errorf("Duplicate negative cases: %v", errs)
+	}
+}
+
+func TestLintEmptyLoop(t *testing.T) {
+	unit, err := NewParserWithConfig("", strings.NewReader(`
+busy() { while(x); }
+work() { while(x) y(); }
+spin() { for(;;); }`), ModernConfig).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if warnings := unit.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("Lint disabled, but got warnings: %v", warnings)
+	}
+
+	warnings := unit.Lint(LintOptions{EmptyLoopBody: true})
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got: %v", warnings)
+	}
+
+	if w := warnings[0].(*SemanticWarning); w.node.String() != "while(x) " {
+		t.Errorf("Warned about wrong loop: %v", w)
+	}
+
+	if w := warnings[1].(*SemanticWarning); w.node.String() != "for(;;) " {
+		t.Errorf("Warned about wrong loop: %v", w)
+	}
+}
+
+func TestLintMissingReturn(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+always(x) { if (x) return (1); else return (2); }
+maybe(x) { if (x) return (1); }
+forever() { while(1) putchar('x'); }
+escapes() { while(1) { if (getchar()) break; } }
+nested() { while(1) { while(x) break; } }
+quits() { exit(); }
+looped() { loop: if (x) return; goto loop; }
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if warnings := unit.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("Lint disabled, but got warnings: %v", warnings)
+	}
+
+	var warned []string
+	for _, w := range unit.Lint(LintOptions{MissingReturn: true}) {
+		warned = append(warned, w.(*SemanticWarning).node.String())
+	}
+
+	if expected := []string{"maybe", "escapes"}; !reflect.DeepEqual(warned, expected) {
+		t.Errorf("Expected warnings for %v, got %v", expected, warned)
+	}
+}
+
+func TestLintArity(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+pair(a, b) return (a + b);
+none() return (0);
+f(g) {
+	auto none;
+	pair(1, 2);
+	pair(1);
+	none(3);
+	g(1, 2, 3);
+	printf("%d*n", pair(1, 2, 3));
+}
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if warnings := unit.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("Lint disabled, but got warnings: %v", warnings)
+	}
+
+	var warned []string
+	for _, w := range unit.Lint(LintOptions{Arity: true}) {
+		warned = append(warned, w.Error())
+	}
+
+	expected := []string{
+		"Warning on `pair(1)`: pair takes 2 arguments, called with 1 at 7:2",
+		"Warning on `pair(1, 2, 3)`: pair takes 2 arguments, called with 3 at 10:17",
+	}
+
+	if !reflect.DeepEqual(warned, expected) {
+		t.Errorf("Expected %q, got %q", expected, warned)
+	}
+}
+
+func TestLintUninitialized(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+unset() { auto x; return (x + 1); }
+set(n) { auto x; x = n; return (x); }
+bumped() { auto x; x =+ 1; return (x); }
+branch(c) { auto x; if (c) x = 1; return (x); }
+looped(n) { auto x, i; i = 0; while (i < n) { if (i) putchar(x); x = i++; } }
+pointer() { auto x; init(&x); return (x); }
+vector() { auto v[2]; return (v); }
+jumps() { auto x; goto a; b: return (x); a: x = 1; goto b; }
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if warnings := unit.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("Lint disabled, but got warnings: %v", warnings)
+	}
+
+	var warned []string
+	for _, w := range unit.Lint(LintOptions{Uninitialized: true}) {
+		warned = append(warned, w.Error())
+	}
+
+	expected := []string{
+		"Warning on `x`: may be used uninitialized at 2:27",
+		"Warning on `x`: may be used uninitialized at 4:20",
+	}
+	if !reflect.DeepEqual(warned, expected) {
+		t.Errorf("Expected warnings %v, got %v", expected, warned)
+	}
+}
+
+func TestLintUnreachable(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+early() { return; a; b; }
+jumps(x) { goto out; x++; out: return (x); }
+cases(x) {
+	switch (x) {
+	case 1:
+		break;
+		x = 2;
+	case 2:
+		x = 3;
+	}
+	if (x) return (1); else return (0);
+	exit(x);
+}
+fine(x) { while (x) { if (x--) break; putchar(x); } return (x); ; }
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if warnings := unit.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("Lint disabled, but got warnings: %v", warnings)
+	}
+
+	var warned []string
+	for _, w := range unit.Lint(LintOptions{Unreachable: true}) {
+		warned = append(warned, w.Error())
+	}
+
+	expected := []string{
+		"Warning on `a;`: unreachable at 2:19",
+		"Warning on `x++;`: unreachable at 3:22",
+		"Warning on `exit(x);`: unreachable at 13:2",
+		"Warning on `x = 2;`: unreachable at 8:3",
+	}
+	if !reflect.DeepEqual(warned, expected) {
+		t.Errorf("Expected warnings %q, got %q", expected, warned)
+	}
+}
+
+func TestBuiltinCall(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+f(s) { lchar(s, 0, char(s, 1)); g(s); s(1); }
+g(s) ;
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var builtins []string
+	Walk(unit.Funcs[0].Body, func(n Node) bool {
+		if name, ok := unit.BuiltinCall(n); ok {
+			builtins = append(builtins, name)
+		}
+		return true
+	})
+
+	if expected := []string{"lchar", "char"}; !reflect.DeepEqual(builtins, expected) {
+		t.Errorf("Expected builtin calls %v, got %v", expected, builtins)
+	}
+
+	// Defining a function hides the builtin
+	unit.Funcs[1].Name = "char"
+	call := FunctionCallNode{Callable: IdentNode{Value: "char"}}
+	if name, ok := unit.BuiltinCall(call); ok {
+		t.Errorf("Defined function treated as builtin %s", name)
+	}
+
+	if len(Analyze(unit)) != 0 {
+		t.Errorf("Builtins reported as undeclared: %v", Analyze(unit))
+	}
+}
+
+func TestRequiredExterns(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+count 0;
+main(argc) {
+  extrn tbl, count;
+  auto i;
+  i = helper(tbl[argc]) + count;
+  putchar(i);
+  local();
+loop:
+  goto loop;
+}
+local() { }`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := []string{"helper", "tbl"}
+	if externs := RequiredExterns(unit); !reflect.DeepEqual(externs, expected) {
+		t.Errorf("Required externs: expected %v, got %v", expected, externs)
+	}
+}
+
 func TestRHS(t *testing.T) {
 	// TODO: write me
 }
 
 func TestVerifyAssignments(t *testing.T) {
 	unit, err := NewParser("", strings.NewReader(`
-good() { a = 1; a = 1 + 2; a = (1 + (a = 2)); a = a; a[0] = 1; a[1+2+a] = a;}
-bad() { 1 = a; 'this' = 'that';}`)).Parse()
+good() { a = 1; a = 1 + 2; a = (1 + (a = 2)); a = a; a[0] = 1; a[1+2+a] = a;}`)).Parse()
+
+	// The parser rejects these, so build them by hand
+	bad := FunctionNode{Name: "bad", Body: BlockNode{Nodes: []Node{
+		StatementNode{Expr: AssignNode{Lhs: IntegerNode{Value: 1}, Op: "=", Rhs: IdentNode{Value: "a"}}},
+		StatementNode{Expr: AssignNode{Lhs: CharacterNode{Value: "this"}, Op: "=",
+			Rhs: CharacterNode{Value: "that"}}},
+	}}}
 
 	if err != nil {
 		t.Errorf("Parse failed: %v", err)
 	} else if err = unit.VerifyAssignments(unit.Funcs[0]); err != nil {
 		t.Errorf("verify good assignments failed: %v", err)
-	} else if err = unit.VerifyAssignments(unit.Funcs[1]); err == nil {
-		t.Errorf("verify bad assignements passed", err)
+	} else if err = unit.VerifyAssignments(bad); err == nil {
+		t.Errorf("verify bad assignments passed")
 	}
 }
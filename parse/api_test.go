@@ -0,0 +1,104 @@
+package parse_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erik/gob/parse"
+)
+
+// Everything in a parsed tree can be read from outside the package
+func TestExportedFields(t *testing.T) {
+	unit, err := parse.NewParser("", strings.NewReader(`
+tbl [1] 'ab', "s";
+main(argc) {
+	extrn tbl;
+	auto v[2];
+	while (argc > 0) {
+		v[0] = tbl[argc--] ? -1 : f(argc);
+		if (argc) goto out; else break;
+	}
+	switch (argc) { case 1: ; default: ; }
+out:
+	return (v);
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	vec := unit.Vars[0].(parse.ExternVecInitNode)
+	if vec.Name != "tbl" || vec.Size != 1 ||
+		vec.Values[0].(parse.CharacterNode).Value != "ab" ||
+		vec.Values[1].(parse.StringNode).Value != "s" {
+		t.Errorf("Vector: %#v", vec)
+	}
+
+	fn := unit.Funcs[0]
+	if fn.Name != "main" || fn.Params[0] != "argc" {
+		t.Errorf("Function: %v(%v)", fn.Name, fn.Params)
+	}
+
+	stmts := fn.Body.(parse.BlockNode).Nodes
+
+	if extrn := stmts[0].(parse.ExternVarDeclNode); extrn.Names[0] != "tbl" {
+		t.Errorf("extrn: %#v", extrn)
+	}
+
+	if decl := stmts[1].(parse.VarDeclNode).Vars[0]; decl.Name != "v" || !decl.VecDecl || decl.Size != 2 {
+		t.Errorf("auto: %#v", decl)
+	}
+
+	loop := stmts[2].(parse.WhileNode)
+	if cond := loop.Cond.(parse.BinaryNode); cond.Left.(parse.IdentNode).Value != "argc" ||
+		cond.Oper != ">" || cond.Right.(parse.IntegerNode).Value != 0 {
+		t.Errorf("while: %#v", cond)
+	}
+
+	body := loop.Body.(parse.BlockNode).Nodes
+
+	assign := body[0].(parse.StatementNode).Expr.(parse.AssignNode)
+	if elem := assign.Lhs.(parse.ArrayAccessNode); elem.Array.(parse.IdentNode).Value != "v" ||
+		elem.Index.(parse.IntegerNode).Value != 0 {
+		t.Errorf("Array access: %#v", elem)
+	}
+
+	ternary := assign.Rhs.(parse.TernaryNode)
+	index := ternary.Cond.(parse.ArrayAccessNode).Index.(parse.UnaryNode)
+	if index.Oper != "--" || !index.Postfix || index.Node.(parse.IdentNode).Value != "argc" {
+		t.Errorf("Postfix: %#v", index)
+	}
+
+	if neg := ternary.TrueBody.(parse.UnaryNode); neg.Oper != "-" || neg.Postfix {
+		t.Errorf("Prefix: %#v", neg)
+	}
+
+	if call := ternary.FalseBody.(parse.FunctionCallNode); call.Callable.(parse.IdentNode).Value != "f" ||
+		len(call.Args) != 1 {
+		t.Errorf("Call: %#v", call)
+	}
+
+	ifNode := body[1].(parse.IfNode)
+	if name, _ := ifNode.Body.(parse.GotoNode).Name(); name != "out" || !ifNode.HasElse {
+		t.Errorf("if: %#v", ifNode)
+	}
+
+	if _, ok := ifNode.ElseBody.(parse.BreakNode); !ok {
+		t.Errorf("else: %#v", ifNode.ElseBody)
+	}
+
+	switch_ := stmts[3].(parse.SwitchNode)
+	if switch_.Cases[0].Cond.(parse.IntegerNode).Value != 1 ||
+		len(switch_.Cases[0].Statements) != 1 || len(switch_.DefaultCase) != 1 {
+		t.Errorf("switch: %#v", switch_)
+	}
+
+	if label := stmts[4].(parse.LabelNode); label.Name != "out" {
+		t.Errorf("Label: %#v", label)
+	}
+
+	ret := stmts[5].(parse.ReturnNode)
+	if ret.Node.(parse.ParenNode).Node.(parse.IdentNode).Value != "v" {
+		t.Errorf("return: %#v", ret)
+	}
+}
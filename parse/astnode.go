@@ -2,17 +2,32 @@ package parse
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"text/scanner"
 )
 
 type Node interface {
 	String() string
+
+	// Where the node starts in the source, the zero position for nodes
+	// built outside the parser
+	Position() scanner.Position
+}
+
+// Embedded in every node, holding the position of the token it was parsed
+// from
+type baseNode struct {
+	Pos scanner.Position
 }
 
+func (b baseNode) Position() scanner.Position { return b.Pos }
+
 func IsExpr(n Node) bool {
 	switch n.(type) {
-	case ArrayAccessNode, BinaryNode, IdentNode, IntegerNode, CharacterNode,
-		FunctionCallNode, ParenNode, TernaryNode, UnaryNode:
+	case ArrayAccessNode, AssignNode, BinaryNode, CommaNode, IdentNode,
+		IntegerNode, CharacterNode, FunctionCallNode, ParenNode, TernaryNode,
+		UnaryNode:
 		return true
 	}
 	return false
@@ -24,8 +39,8 @@ func IsStatement(n Node) bool {
 	}
 
 	switch n.(type) {
-	case BlockNode, BreakNode, CaseNode, ExternVarDeclNode,
-		ExternVarInitNode, ExternVecInitNode, FunctionNode, GotoNode,
+	case BlockNode, BreakNode, CaseNode, ContinueNode, ExternVarDeclNode,
+		ExternVarInitNode, ExternVecInitNode, ForNode, FunctionNode, GotoNode,
 		IfNode, LabelNode, NullNode, ReturnNode, StatementNode, SwitchNode,
 		VarDeclNode, WhileNode:
 		return true
@@ -34,7 +49,219 @@ func IsStatement(n Node) bool {
 	return false
 }
 
+// Immediate children of a node, in source order
+func children(n Node) []Node {
+	switch n := n.(type) {
+	case ArrayAccessNode:
+		return []Node{n.Array, n.Index}
+	case AssignNode:
+		return []Node{n.Lhs, n.Rhs}
+	case BinaryNode:
+		return []Node{n.Left, n.Right}
+	case BlockNode:
+		return n.Nodes
+	case CaseNode:
+		return append([]Node{n.Cond}, n.Statements...)
+	case CommaNode:
+		return n.Exprs
+	case ExternVarInitNode:
+		return []Node{n.Value}
+	case ExternVecInitNode:
+		return n.Values
+	case ForNode:
+		return []Node{n.Init, n.Cond, n.Step, n.Body}
+	case FunctionNode:
+		return []Node{n.Body}
+	case FunctionCallNode:
+		return append([]Node{n.Callable}, n.Args...)
+	case GotoNode:
+		return []Node{n.Label}
+	case IfNode:
+		if n.HasElse {
+			return []Node{n.Cond, n.Body, n.ElseBody}
+		}
+		return []Node{n.Cond, n.Body}
+	case ParenNode:
+		return []Node{n.Node}
+	case ReturnNode:
+		return []Node{n.Node}
+	case StatementNode:
+		return []Node{n.Expr}
+	case SwitchNode:
+		nodes := []Node{n.Cond}
+		for _, c := range n.Cases {
+			nodes = append(nodes, c)
+		}
+		return append(nodes, n.DefaultCase...)
+	case TernaryNode:
+		return []Node{n.Cond, n.TrueBody, n.FalseBody}
+	case UnaryNode:
+		return []Node{n.Node}
+	case VarDeclNode:
+		var nodes []Node
+		for _, decl := range n.Vars {
+			nodes = append(nodes, decl.Values...)
+		}
+		return nodes
+	case WhileNode:
+		return []Node{n.Cond, n.Body}
+	}
+
+	return nil
+}
+
+// Call fn on n and then on each of its descendants, in source order.
+// When fn returns false the children of that node are skipped.
+func Walk(n Node, fn func(Node) bool) {
+	WalkFunc(n, func(n Node) WalkAction {
+		if fn(n) {
+			return WalkContinue
+		}
+		return WalkSkip
+	})
+}
+
+// What WalkFunc does after calling its function on a node
+type WalkAction int
+
+const (
+	WalkContinue WalkAction = iota // Go on to the node's children
+	WalkSkip                       // Skip the node's children
+	WalkStop                       // End the walk, visiting nothing else
+)
+
+// Walk the tree under n as Walk does, except that fn can also end the
+// walk early by returning WalkStop. Returns false if it was stopped.
+func WalkFunc(n Node, fn func(Node) WalkAction) bool {
+	switch fn(n) {
+	case WalkSkip:
+		return true
+	case WalkStop:
+		return false
+	}
+
+	for _, child := range children(n) {
+		if !WalkFunc(child, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Return a copy of n with fn applied to every node, children first. The
+// original tree is left untouched.
+func rewrite(n Node, fn func(Node) Node) Node {
+	switch node := n.(type) {
+	case ArrayAccessNode:
+		node.Array = rewrite(node.Array, fn)
+		node.Index = rewrite(node.Index, fn)
+		n = node
+	case AssignNode:
+		node.Lhs = rewrite(node.Lhs, fn)
+		node.Rhs = rewrite(node.Rhs, fn)
+		n = node
+	case BinaryNode:
+		node.Left = rewrite(node.Left, fn)
+		node.Right = rewrite(node.Right, fn)
+		n = node
+	case BlockNode:
+		node.Nodes = rewriteAll(node.Nodes, fn)
+		n = node
+	case CaseNode:
+		node.Cond = rewrite(node.Cond, fn)
+		node.Statements = rewriteAll(node.Statements, fn)
+		n = node
+	case CommaNode:
+		node.Exprs = rewriteAll(node.Exprs, fn)
+		n = node
+	case ExternVarInitNode:
+		node.Value = rewrite(node.Value, fn)
+		n = node
+	case ExternVecInitNode:
+		node.Values = rewriteAll(node.Values, fn)
+		n = node
+	case ForNode:
+		node.Init = rewrite(node.Init, fn)
+		node.Cond = rewrite(node.Cond, fn)
+		node.Step = rewrite(node.Step, fn)
+		node.Body = rewrite(node.Body, fn)
+		n = node
+	case FunctionNode:
+		node.Body = rewrite(node.Body, fn)
+		n = node
+	case FunctionCallNode:
+		node.Callable = rewrite(node.Callable, fn)
+		node.Args = rewriteAll(node.Args, fn)
+		n = node
+	case GotoNode:
+		node.Label = rewrite(node.Label, fn)
+		n = node
+	case IfNode:
+		node.Cond = rewrite(node.Cond, fn)
+		node.Body = rewrite(node.Body, fn)
+		if node.HasElse {
+			node.ElseBody = rewrite(node.ElseBody, fn)
+		}
+		n = node
+	case ParenNode:
+		node.Node = rewrite(node.Node, fn)
+		n = node
+	case ReturnNode:
+		node.Node = rewrite(node.Node, fn)
+		n = node
+	case StatementNode:
+		node.Expr = rewrite(node.Expr, fn)
+		n = node
+	case SwitchNode:
+		node.Cond = rewrite(node.Cond, fn)
+		cases := make([]CaseNode, len(node.Cases))
+		for i, c := range node.Cases {
+			cases[i] = rewrite(c, fn).(CaseNode)
+		}
+		node.Cases = cases
+		node.DefaultCase = rewriteAll(node.DefaultCase, fn)
+		n = node
+	case TernaryNode:
+		node.Cond = rewrite(node.Cond, fn)
+		node.TrueBody = rewrite(node.TrueBody, fn)
+		node.FalseBody = rewrite(node.FalseBody, fn)
+		n = node
+	case UnaryNode:
+		node.Node = rewrite(node.Node, fn)
+		n = node
+	case VarDeclNode:
+		vars := make([]VarDecl, len(node.Vars))
+		for i, decl := range node.Vars {
+			decl.Values = rewriteAll(decl.Values, fn)
+			vars[i] = decl
+		}
+		node.Vars = vars
+		n = node
+	case WhileNode:
+		node.Cond = rewrite(node.Cond, fn)
+		node.Body = rewrite(node.Body, fn)
+		n = node
+	}
+
+	return fn(n)
+}
+
+func rewriteAll(nodes []Node, fn func(Node) Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+
+	rewritten := make([]Node, len(nodes))
+	for i, n := range nodes {
+		rewritten[i] = rewrite(n, fn)
+	}
+
+	return rewritten
+}
+
 type ArrayAccessNode struct {
+	baseNode
 	Array Node
 	Index Node
 }
@@ -43,7 +270,27 @@ func (a ArrayAccessNode) String() string {
 	return fmt.Sprintf("%s[%s]", a.Array, a.Index)
 }
 
+// Assignment with `=` or a compound operator such as `=+`, whose left
+// side is an lvalue
+type AssignNode struct {
+	baseNode
+	Lhs Node
+	Op  string
+	Rhs Node
+}
+
+func (a AssignNode) String() string {
+	return fmt.Sprintf("%v %s %v", a.Lhs, a.Op, a.Rhs)
+}
+
+// Use parens to make precedence more apparent
+func (a AssignNode) StringWithPrecedence() string {
+	return fmt.Sprintf("(%s %s %s)", withPrecedence(a.Lhs), a.Op,
+		withPrecedence(a.Rhs))
+}
+
 type BinaryNode struct {
+	baseNode
 	Left  Node
 	Oper  string
 	Right Node
@@ -56,26 +303,26 @@ func (b BinaryNode) String() string {
 
 // Use parens to make precedence more apparent
 func (b BinaryNode) StringWithPrecedence() string {
-	var left, right string
-
-	if bin, ok := b.Left.(BinaryNode); ok {
-		left = bin.StringWithPrecedence()
-	} else {
-		left = b.Left.String()
+	return fmt.Sprintf("(%s %s %s)", withPrecedence(b.Left), b.Oper,
+		withPrecedence(b.Right))
+}
+
+func withPrecedence(n Node) string {
+	switch node := n.(type) {
+	case AssignNode:
+		return node.StringWithPrecedence()
+	case BinaryNode:
+		return node.StringWithPrecedence()
+	case TernaryNode:
+		return node.StringWithPrecedence()
 	}
 
-	if bin, ok := b.Right.(BinaryNode); ok {
-		right = bin.StringWithPrecedence()
-	} else {
-		right = b.Right.String()
-	}
-
-	return fmt.Sprintf("(%v %s %v)",
-		left, b.Oper, right)
+	return n.String()
 }
 
 // '{' node* '}'
 type BlockNode struct {
+	baseNode
 	Nodes []Node
 }
 
@@ -83,33 +330,107 @@ func (b BlockNode) String() string {
 	str := "{\n"
 
 	for _, node := range b.Nodes {
-		str += fmt.Sprintf("\t%v\n", node)
+		str += "\t" + indent(node.String(), 1) + "\n"
 	}
 
 	str += "}"
 	return str
 }
 
-type BreakNode struct{}
+// Indent all but the first line of a nested statement by level more
+// tabs, so blocks within blocks keep their depth
+func indent(str string, level int) string {
+	return strings.Replace(str, "\n", "\n"+strings.Repeat("\t", level), -1)
+}
+
+type BreakNode struct{ baseNode }
 
 func (b BreakNode) String() string { return "break;" }
 
+// Skip to the next iteration of the innermost loop. Not in classic B.
+type ContinueNode struct{ baseNode }
+
+func (c ContinueNode) String() string { return "continue;" }
+
+// Expressions evaluated in order, the value being the last one's, as in
+// `i = 0, j = n`
+type CommaNode struct {
+	baseNode
+	Exprs []Node
+}
+
+func (c CommaNode) String() string {
+	strs := make([]string, len(c.Exprs))
+	for i, expr := range c.Exprs {
+		strs[i] = expr.String()
+	}
+
+	return strings.Join(strs, ", ")
+}
+
 type CharacterNode struct {
-	value string
+	baseNode
+	Value string // As written, with escapes
+}
+
+func (c CharacterNode) String() string { return fmt.Sprintf("'%s'", c.Value) }
+
+// Value of the constant as a word, the escape decoded characters packed
+// first into the highest byte. Errors if the characters don't fit into
+// a word of DefaultWordBits. Escapes are those of classic B.
+func (c CharacterNode) Int() (int, error) {
+	return c.IntWithConfig(Config{})
 }
 
-func (c CharacterNode) String() string { return fmt.Sprintf("'%s'", c.value) }
+// Value of the constant as a word of config's size, decoded with its
+// escapes. Errors if the characters don't fit into the word, or into an
+// int.
+func (c CharacterNode) IntWithConfig(config Config) (int, error) {
+	str, err := config.Unescape(c.Value)
+	if err != nil {
+		return 0, err
+	} else if len(str) > config.CharsPerWord() || len(str) > strconv.IntSize/8 {
+		return 0, fmt.Errorf("too many characters for a word: %v", c)
+	}
+
+	value := 0
+	for i := 0; i < len(str); i++ {
+		value = value<<8 | int(str[i])
+	}
+
+	return value, nil
+}
+
+// Placeholder for a production which failed to parse, produced in the
+// parser's tolerant mode. When the parser recovers by skipping past the
+// bad region, Tokens and Text hold what was skipped.
+type ErrorNode struct {
+	baseNode
+	Msg    string
+	Tokens []Token
+	Text   string // Source text of the skipped tokens
+}
+
+func (e ErrorNode) String() string {
+	if e.Text != "" {
+		return e.Text
+	}
+
+	return fmt.Sprintf("/* error: %s */", e.Msg)
+}
 
 type ExternVarDeclNode struct {
-	names []string
+	baseNode
+	Names []string
 }
 
 func (e ExternVarDeclNode) String() string {
-	return fmt.Sprintf("extrn %s;", strings.Join(e.names, ", "))
+	return fmt.Sprintf("extrn %s;", strings.Join(e.Names, ", "))
 }
 
 // name value ';'
 type ExternVarInitNode struct {
+	baseNode
 	Name  string
 	Value Node
 }
@@ -119,7 +440,12 @@ func (e ExternVarInitNode) String() string {
 }
 
 // name '[' size ']' value+ ';'
+// name '[' size? ']' value (',' value)* ';'
+//
+// As in B, the size is the highest index, so the vector holds Size + 1
+// words.
 type ExternVecInitNode struct {
+	baseNode
 	Name   string
 	Size   int
 	Values []Node
@@ -136,8 +462,34 @@ func (e ExternVecInitNode) String() string {
 		strings.Join(vals, ", "))
 }
 
+// 'for' '(' expr? ';' expr? ';' expr? ')' statement
+//
+// Empty clauses are NullNodes.
+type ForNode struct {
+	baseNode
+	Init Node
+	Cond Node
+	Step Node
+	Body Node
+}
+
+func (f ForNode) String() string {
+	return fmt.Sprintf("for(%v;%s;%s) %v", f.Init, forClause(f.Cond),
+		forClause(f.Step), f.Body)
+}
+
+// Condition or step of a for loop, spaced from the preceding semicolon
+// unless left out
+func forClause(n Node) string {
+	if _, ok := n.(NullNode); ok {
+		return ""
+	}
+	return " " + n.String()
+}
+
 // name '(' (var (',' var)*) ? ')' block
 type FunctionNode struct {
+	baseNode
 	Name   string
 	Params []string
 	Body   Node
@@ -148,7 +500,30 @@ func (f FunctionNode) String() string {
 		f.Name, strings.Join(f.Params, ", "), f.Body)
 }
 
+func (f FunctionNode) Parameters() []string { return f.Params }
+
+// Every auto declaration in the function body, in source order
+func (f FunctionNode) Locals() []VarDecl {
+	var locals []VarDecl
+
+	var visit func(Node)
+	visit = func(n Node) {
+		if decl, ok := n.(VarDeclNode); ok {
+			locals = append(locals, decl.Vars...)
+		}
+
+		for _, child := range children(n) {
+			visit(child)
+		}
+	}
+
+	visit(f.Body)
+
+	return locals
+}
+
 type FunctionCallNode struct {
+	baseNode
 	Callable Node
 	Args     []Node
 }
@@ -162,17 +537,30 @@ func (f FunctionCallNode) String() string {
 	return fmt.Sprintf("%s(%s)", f.Callable, strings.Join(args, ", "))
 }
 
-type GotoNode struct{ Label string }
+// Jump to a label, usually named directly. In B a label is a value, so
+// the target may also be computed, as in `goto *p;`.
+type GotoNode struct {
+	baseNode
+	Label Node
+}
+
+func (g GotoNode) String() string { return fmt.Sprintf("goto %v;", g.Label) }
 
-func (g GotoNode) String() string { return fmt.Sprintf("goto %s;", g.Label) }
+// Name of the label jumped to, or false if the target is computed
+func (g GotoNode) Name() (string, bool) {
+	ident, ok := g.Label.(IdentNode)
+	return ident.Value, ok
+}
 
 type IdentNode struct {
+	baseNode
 	Value string
 }
 
 func (i IdentNode) String() string { return i.Value }
 
 type IfNode struct {
+	baseNode
 	Cond     Node
 	Body     Node
 	HasElse  bool
@@ -189,58 +577,116 @@ func (i IfNode) String() string {
 	return fmt.Sprintf("if(%v) %v%s", i.Cond, i.Body, elseStr)
 }
 
+// Integer constant. Equal and Match only compare the Value, so 007 and 7
+// are the same node, while String gives the literal back as written.
 type IntegerNode struct {
-	Value int
+	baseNode
+	Value int64
+	Text  string `match:"-"` // As written, such as 007, if parsed from source
 }
 
-func (i IntegerNode) String() string { return fmt.Sprintf("%d", i.Value) }
+func (i IntegerNode) String() string {
+	if i.Text != "" {
+		return i.Text
+	}
+	return fmt.Sprintf("%d", i.Value)
+}
 
-type LabelNode struct{ Name string }
+type LabelNode struct {
+	baseNode
+	Name string
+}
 
 func (l LabelNode) String() string { return fmt.Sprintf("%s:", l.Name) }
 
-type NullNode struct{}
+type NullNode struct{ baseNode }
 
 func (n NullNode) String() string { return "" }
 
-type ParenNode struct{ Node Node }
+type ParenNode struct {
+	baseNode
+	Node Node
+}
 
 func (p ParenNode) String() string { return "(" + p.Node.String() + ")" }
 
-type ReturnNode struct{ Node Node }
+type ReturnNode struct {
+	baseNode
+	Node Node
+}
 
 func (r ReturnNode) String() string { return fmt.Sprintf("return %v;", r.Node) }
 
 type StatementNode struct {
+	baseNode
 	Expr Node
 }
 
 func (s StatementNode) String() string { return fmt.Sprintf("%v;", s.Expr) }
 
 type StringNode struct {
+	baseNode
 	Value string
 }
 
 func (s StringNode) String() string { return fmt.Sprintf("\"%s\"", s.Value) }
 
+// Characters of the string with the escapes of classic B replaced,
+// followed by the *e which B stores at the end of every string
+func (s StringNode) Bytes() []byte {
+	return s.BytesWithConfig(Config{})
+}
+
+// Characters of the string with config's escapes replaced, followed by
+// the *e which ends every string
+func (s StringNode) BytesWithConfig(config Config) []byte {
+	str, err := config.Unescape(s.Value)
+	if err != nil {
+		str = s.Value
+	}
+
+	return append([]byte(str), escapes['e'])
+}
+
+// Number of characters the string takes up in storage, including the
+// terminating *e
+func (s StringNode) Len() int {
+	return len(s.Bytes())
+}
+
+// Number of words the string takes up with its characters, decoded with
+// config's escapes, packed config.CharsPerWord() to a word, including the
+// terminating *e
+func (s StringNode) Words(config Config) int {
+	chars := config.CharsPerWord()
+	return (len(s.BytesWithConfig(config)) + chars - 1) / chars
+}
+
 type CaseNode struct {
+	baseNode
 	Cond       Node
 	Statements []Node
+
+	// Control can run off the end of the statements into the next case,
+	// as far as the parser can tell, rather than leaving with a break,
+	// return or goto
+	FallsThrough bool
 }
 
 func (c CaseNode) String() string {
 	str := fmt.Sprintf("\tcase %v:", c.Cond)
 
 	for _, stmt := range c.Statements {
-		str += fmt.Sprintf("\n\t\t%v", stmt)
+		str += "\n\t\t" + indent(stmt.String(), 2)
 	}
 
 	return str
 }
 
 type SwitchNode struct {
+	baseNode
 	Cond        Node
-	DefaultCase []Node
+	DefaultCase []Node // Nil without a default
 	Cases       []CaseNode
 }
 
@@ -252,28 +698,50 @@ func (s SwitchNode) String() string {
 	}
 
 	if s.DefaultCase != nil {
-		str += "\ndefault:"
+		str += "\n\tdefault:"
 		for _, stmt := range s.DefaultCase {
-			str += fmt.Sprintf("\n\t%v", stmt)
+			str += "\n\t\t" + indent(stmt.String(), 2)
 		}
 	}
 
-	return str
+	return str + "\n}"
 }
 
 // Yes, I know "ternary" is no more descriptive than binary op,
 // but there's only one.
 type TernaryNode struct {
+	baseNode
 	Cond      Node
-	TrueBody  Node
+	TrueBody  Node // NullNode in `a ?: b`, where the value is Cond's
 	FalseBody Node
 }
 
+// Whether the true branch is left out, as in `a ?: b`. Cond is then
+// evaluated only once and is the value when true.
+func (t TernaryNode) Elvis() bool {
+	_, ok := t.TrueBody.(NullNode)
+	return ok
+}
+
 func (t TernaryNode) String() string {
-	return fmt.Sprintf("(%v ? %v : %v)", t.Cond, t.TrueBody, t.FalseBody)
+	if t.Elvis() {
+		return fmt.Sprintf("%v ?: %v", t.Cond, t.FalseBody)
+	}
+	return fmt.Sprintf("%v ? %v : %v", t.Cond, t.TrueBody, t.FalseBody)
+}
+
+// Use parens to make precedence more apparent
+func (t TernaryNode) StringWithPrecedence() string {
+	if t.Elvis() {
+		return fmt.Sprintf("(%s ?: %s)", withPrecedence(t.Cond),
+			withPrecedence(t.FalseBody))
+	}
+	return fmt.Sprintf("(%s ? %s : %s)", withPrecedence(t.Cond),
+		withPrecedence(t.TrueBody), withPrecedence(t.FalseBody))
 }
 
 type UnaryNode struct {
+	baseNode
 	Oper    string
 	Node    Node
 	Postfix bool
@@ -283,16 +751,25 @@ func (u UnaryNode) String() string {
 	if u.Postfix {
 		return fmt.Sprintf("%v%s", u.Node, u.Oper)
 	}
-	return fmt.Sprintf("%s%v", u.Oper, u.Node)
+
+	// Keep - -a from becoming --a
+	operand := fmt.Sprint(u.Node)
+	if operand != "" && operand[0] == u.Oper[len(u.Oper)-1] &&
+		(operand[0] == '-' || operand[0] == '+') {
+		return u.Oper + " " + operand
+	}
+	return u.Oper + operand
 }
 
 type VarDecl struct {
 	Name    string
 	VecDecl bool
 	Size    int
+	Values  []Node // Initial values of a vector, if any
 }
 
 type VarDeclNode struct {
+	baseNode
 	Vars []VarDecl
 }
 
@@ -304,6 +781,15 @@ func (v VarDeclNode) String() string {
 
 		if decl.VecDecl {
 			str = fmt.Sprintf("%s[%d]", decl.Name, decl.Size)
+
+			for i, val := range decl.Values {
+				if i == 0 {
+					str += " "
+				} else {
+					str += ", "
+				}
+				str += val.String()
+			}
 		} else {
 			str = decl.Name
 		}
@@ -315,6 +801,7 @@ func (v VarDeclNode) String() string {
 }
 
 type WhileNode struct {
+	baseNode
 	Cond Node
 	Body Node
 }
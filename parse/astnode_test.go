@@ -2,6 +2,8 @@ package parse
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -12,82 +14,105 @@ var nodefmt = []struct {
 	expr bool
 }{
 	// ArrayAccessNode
-	{ArrayAccessNode{IdentNode{"abc"}, IntegerNode{2}}, "abc[2]", true},
+	{ArrayAccessNode{Array: IdentNode{Value: "abc"}, Index: IntegerNode{Value: 2}}, "abc[2]", true},
 
 	// BinaryNode
-	{BinaryNode{IdentNode{"a"}, "==", IdentNode{"b"}}, "a == b", true},
+	{BinaryNode{Left: IdentNode{Value: "a"}, Oper: "==", Right: IdentNode{Value: "b"}}, "a == b", true},
 
 	// IdentNode
-	{IdentNode{"abcd"}, "abcd", true},
+	{IdentNode{Value: "abcd"}, "abcd", true},
 
 	// IfNode
-	{IfNode{Cond: BinaryNode{IdentNode{"a"}, "<", IdentNode{"b"}},
-		Body: StatementNode{FunctionCallNode{IdentNode{"do_this"},
-			[]Node{}}},
+	{IfNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "<", Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: FunctionCallNode{Callable: IdentNode{Value: "do_this"},
+			Args: []Node{}}},
 		HasElse: false},
 		"if(a < b) do_this();",
 		false},
-	{IfNode{Cond: BinaryNode{IdentNode{"a"}, "<", IdentNode{"b"}},
-		Body: StatementNode{FunctionCallNode{IdentNode{"do_this"},
-			[]Node{}}},
+	{IfNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "<", Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: FunctionCallNode{Callable: IdentNode{Value: "do_this"},
+			Args: []Node{}}},
 		HasElse: true,
-		ElseBody: StatementNode{FunctionCallNode{IdentNode{"do_that"},
-			[]Node{}}}},
+		ElseBody: StatementNode{Expr: FunctionCallNode{Callable: IdentNode{Value: "do_that"},
+			Args: []Node{}}}},
 		"if(a < b) do_this(); else do_that();",
 		false},
 
 	// IntegerNode
-	{IntegerNode{1234567890}, "1234567890", true},
+	{IntegerNode{Value: 1234567890}, "1234567890", true},
 
 	// CharacterNode
-	{CharacterNode{""}, "''", true},
-	{CharacterNode{"1"}, "'1'", true},
-	{CharacterNode{"1234"}, "'1234'", true},
+	{CharacterNode{Value: ""}, "''", true},
+	{CharacterNode{Value: "1"}, "'1'", true},
+	{CharacterNode{Value: "1234"}, "'1234'", true},
 
 	// FunctionNode
-	{FunctionNode{"fn", []string{"a", "b", "c"}, BlockNode{}},
+	{FunctionNode{Name: "fn", Params: []string{"a", "b", "c"}, Body: BlockNode{}},
 		"fn(a, b, c) {\n}", false},
-	{FunctionNode{"fn", []string{}, BlockNode{}}, "fn() {\n}", false},
+	{FunctionNode{Name: "fn", Params: []string{}, Body: BlockNode{}}, "fn() {\n}", false},
 
 	// FunctionCallNode
-	{FunctionCallNode{IdentNode{"fn"}, []Node{IntegerNode{1},
-		CharacterNode{"123"}}},
+	{FunctionCallNode{Callable: IdentNode{Value: "fn"}, Args: []Node{IntegerNode{Value: 1},
+		CharacterNode{Value: "123"}}},
 		"fn(1, '123')", true},
 
 	// BlockNode
-	{BlockNode{[]Node{IntegerNode{1}, IntegerNode{2},
-		IntegerNode{3}}},
+	{BlockNode{Nodes: []Node{IntegerNode{Value: 1}, IntegerNode{Value: 2},
+		IntegerNode{Value: 3}}},
 		"{\n\t1\n\t2\n\t3\n}", false},
 
+	{BlockNode{Nodes: []Node{StatementNode{Expr: IdentNode{Value: "a"}},
+		BlockNode{Nodes: []Node{StatementNode{Expr: IdentNode{Value: "b"}},
+			BlockNode{Nodes: []Node{StatementNode{Expr: IdentNode{Value: "c"}}}}}}}},
+		"{\n\ta;\n\t{\n\t\tb;\n\t\t{\n\t\t\tc;\n\t\t}\n\t}\n}", false},
+
+	// SwitchNode
+	{SwitchNode{Cond: IdentNode{Value: "x"},
+		Cases: []CaseNode{{Cond: IntegerNode{Value: 1}, Statements: []Node{
+			BlockNode{Nodes: []Node{StatementNode{Expr: IdentNode{Value: "a"}}}}}}},
+		DefaultCase: []Node{BreakNode{}}},
+		"switch(x) {\n\tcase 1:\n\t\t{\n\t\t\ta;\n\t\t}\n\tdefault:\n\t\tbreak;\n}", false},
+
 	// ExternVarInitNode
-	{ExternVarInitNode{"var", IntegerNode{2}}, "var 2;", false},
+	{ExternVarInitNode{Name: "var", Value: IntegerNode{Value: 2}}, "var 2;", false},
 
 	// ExternVecInitNode
-	{ExternVecInitNode{"var", 2, []Node{IntegerNode{2}}}, "var [2] 2;", false},
-	{ExternVecInitNode{"var", 2, []Node{IntegerNode{2}, IntegerNode{3}}},
+	{ExternVecInitNode{Name: "var", Size: 2, Values: []Node{IntegerNode{Value: 2}}}, "var [2] 2;", false},
+	{ExternVecInitNode{Name: "var", Size: 2, Values: []Node{IntegerNode{Value: 2}, IntegerNode{Value: 3}}},
 		"var [2] 2, 3;", false},
 
 	// ExternVarDeclNode
-	{ExternVarDeclNode{[]string{"a", "b", "c"}}, "extrn a, b, c;", false},
+	{ExternVarDeclNode{Names: []string{"a", "b", "c"}}, "extrn a, b, c;", false},
+
+	// ForNode
+	{ForNode{Init: NullNode{}, Cond: NullNode{}, Step: NullNode{}, Body: NullNode{}}, "for(;;) ", false},
+	{ForNode{Init: BinaryNode{Left: IdentNode{Value: "i"}, Oper: "=", Right: IntegerNode{Value: 0}},
+		Cond: BinaryNode{Left: IdentNode{Value: "i"}, Oper: "<", Right: IntegerNode{Value: 3}},
+		Step: UnaryNode{Oper: "++", Node: IdentNode{Value: "i"}, Postfix: true},
+		Body: StatementNode{Expr: IdentNode{Value: "i"}}},
+		"for(i = 0; i < 3; i++) i;", false},
 
 	// StatementNode
-	{StatementNode{IntegerNode{1}}, "1;", false},
+	{StatementNode{Expr: IntegerNode{Value: 1}}, "1;", false},
 
 	// UnaryNode
-	{UnaryNode{"++", IntegerNode{1}, false}, "++1", true},
-	{UnaryNode{"++", IntegerNode{1}, true}, "1++", true},
+	{UnaryNode{Oper: "++", Node: IntegerNode{Value: 1}, Postfix: false}, "++1", true},
+	{UnaryNode{Oper: "++", Node: IntegerNode{Value: 1}, Postfix: true}, "1++", true},
 
 	// VarDeclNode
-	{VarDeclNode{[]VarDecl{{"a", false, 0},
-		{"b", true, 12},
-		{"c", false, 0}}},
+	{VarDeclNode{Vars: []VarDecl{{"a", false, 0, nil},
+		{"b", true, 12, nil},
+		{"c", false, 0, nil}}},
 		"auto a, b[12], c;", false},
+	{VarDeclNode{Vars: []VarDecl{{"v", true, 2,
+		[]Node{IntegerNode{Value: 1}, IntegerNode{Value: 2}}}}},
+		"auto v[2] 1, 2;", false},
 
 	// WhileNode
-	{WhileNode{BinaryNode{IdentNode{"a"}, ">", IdentNode{"b"}},
-		StatementNode{BinaryNode{IdentNode{"a"}, "=",
-			BinaryNode{IdentNode{"a"}, "-",
-				IdentNode{"b"}}}}},
+	{WhileNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: ">", Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "=",
+			Right: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "-",
+				Right: IdentNode{Value: "b"}}}}},
 		"while(a > b) a = a - b;", false},
 }
 
@@ -104,3 +129,186 @@ func TestNodeString(t *testing.T) {
 
 	}
 }
+
+func TestStringBytes(t *testing.T) {
+	var tests = []struct {
+		str      StringNode
+		expected []byte
+	}{
+		{StringNode{Value: "a*nb"}, []byte{'a', '\n', 'b', 4}},
+		{StringNode{Value: ""}, []byte{4}},
+		{StringNode{Value: "*(*e*0*)"}, []byte{'{', 4, 0, '}', 4}},
+	}
+
+	for _, test := range tests {
+		if bytes := test.str.Bytes(); !reflect.DeepEqual(bytes, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.str, test.expected, bytes)
+		}
+
+		if test.str.Len() != len(test.expected) {
+			t.Errorf("%v: expected length %d, got %d", test.str,
+				len(test.expected), test.str.Len())
+		}
+	}
+
+	if str := (StringNode{Value: "a*nb"}).String(); str != `"a*nb"` {
+		t.Errorf("Expected the source form, got %s", str)
+	}
+}
+
+func TestCharacterInt(t *testing.T) {
+	var tests = []struct {
+		char     string
+		expected int
+	}{
+		{"a", 'a'},
+		{"ab", 'a'<<8 | 'b'},
+		{"*n*0", '\n' << 8},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		value, err := CharacterNode{Value: test.char}.Int()
+		if err != nil {
+			t.Errorf("'%s': %v", test.char, err)
+		} else if value != test.expected {
+			t.Errorf("'%s': expected %d, got %d", test.char, test.expected, value)
+		}
+	}
+
+	for _, char := range []string{"abcdefghi", "*x"} {
+		if _, err := (CharacterNode{Value: char}).Int(); err == nil {
+			t.Errorf("'%s': expected an error", char)
+		}
+	}
+}
+
+func TestFunctionFrame(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+fn(a, b) {
+  auto x, v[10];
+  extrn y;
+  if (a) {
+    auto z;
+    z = b;
+  }
+}`))
+
+	node, err := parser.parseFuncDeclaration()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	fn := (*node).(FunctionNode)
+
+	if params := fn.Parameters(); !reflect.DeepEqual(params, []string{"a", "b"}) {
+		t.Errorf("Parameters: %v", params)
+	}
+
+	expected := []VarDecl{{"x", false, 0, nil}, {"v", true, 10, nil}, {"z", false, 0, nil}}
+	if locals := fn.Locals(); !reflect.DeepEqual(locals, expected) {
+		t.Errorf("Locals: %v", locals)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+main(argc) {
+  auto i;
+  i = argc;
+  while (i > 0) {
+    if (i % 2) putchar(i); else x[i] = i ? -i : f(i, argc);
+    i--;
+  }
+  switch (i) { case 1: return (i); }
+}`))
+
+	node, err := parser.parseFuncDeclaration()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	idents := 0
+	Walk(*node, func(n Node) bool {
+		if _, ok := n.(IdentNode); ok {
+			idents += 1
+		}
+		return true
+	})
+
+	if idents != 16 {
+		t.Errorf("Expected 16 identifiers, got %d", idents)
+	}
+
+	// Returning false skips the children of the while loop
+	idents = 0
+	Walk(*node, func(n Node) bool {
+		if _, ok := n.(IdentNode); ok {
+			idents += 1
+		}
+		_, loop := n.(WhileNode)
+		return !loop
+	})
+
+	if idents != 4 {
+		t.Errorf("Expected 4 identifiers outside the loop, got %d", idents)
+	}
+}
+
+func TestWalkFirstReturn(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+f(x) {
+  if (x) return (1);
+  while (x) { g(x); return (2); }
+  return (3);
+}`))
+
+	node, err := parser.parseFuncDeclaration()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Nothing is visited once the first return stops the walk
+	var first Node
+	calls := 0
+	finished := WalkFunc(*node, func(n Node) WalkAction {
+		calls += 1
+		if _, ok := n.(ReturnNode); ok {
+			first = n
+			return WalkStop
+		}
+
+		return WalkContinue
+	})
+
+	if finished {
+		t.Errorf("Walk finished despite being stopped")
+	}
+
+	if first == nil || first.String() != "return (1);" {
+		t.Errorf("Expected the first return, got %v", first)
+	}
+
+	// f, block, if, x, return
+	if calls != 5 {
+		t.Errorf("Expected 5 nodes up to the return, got %d", calls)
+	}
+
+	// Skipping a node's children carries on with its siblings
+	calls = 0
+	if !WalkFunc(*node, func(n Node) WalkAction {
+		calls += 1
+		if _, ok := n.(IfNode); ok {
+			return WalkSkip
+		}
+		return WalkContinue
+	}) {
+		t.Errorf("Walk stopped without WalkStop")
+	}
+
+	// f, block, if, while, x, block, g(x);, g(x), g, x, return, (2), 2,
+	// return, (3), 3
+	if calls != 16 {
+		t.Errorf("Expected 16 nodes, got %d", calls)
+	}
+}
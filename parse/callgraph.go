@@ -0,0 +1,127 @@
+package parse
+
+import (
+	"sort"
+)
+
+// Callee recorded for calls made through anything but a plain name
+const IndirectCall = "<indirect>"
+
+// Map each function in the unit to the sorted set of functions it calls
+// directly. Calls through an expression are recorded as IndirectCall.
+func CallGraph(unit TranslationUnit) map[string][]string {
+	graph := map[string][]string{}
+
+	for _, fn := range unit.Funcs {
+		callees := map[string]bool{}
+
+		Walk(fn.Body, func(n Node) bool {
+			if call, ok := n.(FunctionCallNode); ok {
+				if ident, ok := call.Callable.(IdentNode); ok {
+					callees[ident.Value] = true
+				} else {
+					callees[IndirectCall] = true
+				}
+			}
+
+			return true
+		})
+
+		names := make([]string, 0, len(callees))
+		for name := range callees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		graph[fn.Name] = names
+	}
+
+	return graph
+}
+
+// Groups of functions which call each other recursively: the strongly
+// connected components of the call graph with more than one member,
+// plus any function which calls itself. Each cycle and the list of
+// cycles are sorted.
+func Cycles(unit TranslationUnit) [][]string {
+	graph := CallGraph(unit)
+
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Tarjan's algorithm
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	cycles := [][]string{}
+
+	var connect func(string)
+	connect = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+
+		for _, callee := range graph[name] {
+			if _, defined := graph[callee]; !defined {
+				continue
+			}
+
+			if _, seen := index[callee]; !seen {
+				connect(callee)
+				if lowlink[callee] < lowlink[name] {
+					lowlink[name] = lowlink[callee]
+				}
+			} else if onStack[callee] && index[callee] < lowlink[name] {
+				lowlink[name] = index[callee]
+			}
+		}
+
+		if lowlink[name] != index[name] {
+			return
+		}
+
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+
+			if top == name {
+				break
+			}
+		}
+
+		if len(component) > 1 || callsSelf(graph, name) {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, name := range names {
+		if _, seen := index[name]; !seen {
+			connect(name)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+
+	return cycles
+}
+
+func callsSelf(graph map[string][]string, name string) bool {
+	for _, callee := range graph[name] {
+		if callee == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCallGraph(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+main() { a(); b(1); (tbl[2])(); }
+a() { return b() + b(); }
+b() { }`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"main": {IndirectCall, "a", "b"},
+		"a":    {"b"},
+		"b":    {},
+	}
+
+	if graph := CallGraph(unit); !reflect.DeepEqual(graph, expected) {
+		t.Errorf("Call graph: expected %v, got %v", expected, graph)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+main() { f(1); fact(5); h(); }
+f(n) { if (n) g(n - 1); }
+g(n) { f(n); }
+fact(n) { return n ? n * fact(n - 1) : 1; }
+h() { }`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := [][]string{{"f", "g"}, {"fact"}}
+
+	if cycles := Cycles(unit); !reflect.DeepEqual(cycles, expected) {
+		t.Errorf("Cycles: expected %v, got %v", expected, cycles)
+	}
+}
@@ -0,0 +1,142 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Settings for a dialect of B, shared by the lexer, parser and later
+// passes
+type Config struct {
+	Keywords map[string]bool
+	Escapes  map[byte]byte   // Character following '*' to what it stands for
+	Builtins map[string]bool // Functions provided by the runtime library
+
+	// Bits in a machine word, which limits the range of integer
+	// literals, how many characters can be packed into a character
+	// constant and how many words a string takes up. Zero means
+	// DefaultWordBits, 64, as in both dialects.
+	WordBits int
+
+	// Language extensions
+
+	// Allow any expression as a case label, e.g. `case x > 0:`.
+	// Backends are expected to lower guarded cases to comparisons.
+	CaseGuards bool
+
+	// Join adjacent string literals into one, as in C
+	ConcatStrings bool
+
+	// Allow the true branch of a ternary to be left out, as in GNU C,
+	// so `a ?: b` is a if it's true and b otherwise
+	AllowElvis bool
+
+	// Allow the comma operator, as in C, where a comma can't be a
+	// separator instead: inside parentheses, in expression statements
+	// and in the clauses of a for loop, as in `i = 0, j = n`
+	CommaExpressions bool
+
+	// Allow hexadecimal integer literals, as in C: 0xff or 0XFF
+	HexLiterals bool
+}
+
+// B as described in the manual, the default
+var ClassicConfig = Config{
+	Keywords: Keywords,
+	Escapes:  escapes,
+	Builtins: Builtins,
+	WordBits: DefaultWordBits,
+}
+
+// B with the extensions common in later dialects: C style for loops,
+// continue, case guards, string concatenation, hexadecimal literals and
+// size(v), the declared size of a vector
+var ModernConfig = Config{
+	Keywords:      withNames(Keywords, "for", "continue"),
+	Escapes:       escapes,
+	Builtins:      withNames(Builtins, "size"),
+	WordBits:      DefaultWordBits,
+	CaseGuards:    true,
+	ConcatStrings: true,
+	HexLiterals:   true,
+}
+
+// Word size of a Config which leaves WordBits unset
+const DefaultWordBits = 64
+
+// Bits in a machine word, DefaultWordBits if unset. Words are at most 64
+// bits, the size of an IntegerNode.
+func (c Config) Bits() int {
+	if c.WordBits <= 0 || c.WordBits > 64 {
+		return DefaultWordBits
+	}
+	return c.WordBits
+}
+
+// Keywords of the dialect, those of classic B if unset
+func (c Config) keywordTable() map[string]bool {
+	if c.Keywords == nil {
+		return Keywords
+	}
+	return c.Keywords
+}
+
+// Escapes of the dialect, those of classic B if unset
+func (c Config) escapeTable() map[byte]byte {
+	if c.Escapes == nil {
+		return escapes
+	}
+	return c.Escapes
+}
+
+// Replace the escape sequences in str, a literal as written, with the
+// characters they stand for in the dialect
+func (c Config) Unescape(str string) (string, error) {
+	return unescape(str, c.escapeTable())
+}
+
+// Number of characters which pack into a word, at least one
+func (c Config) CharsPerWord() int {
+	if chars := c.Bits() / 8; chars > 1 {
+		return chars
+	}
+	return 1
+}
+
+// Named profiles, for selecting a dialect from the command line
+var Dialects = map[string]Config{
+	"classic": ClassicConfig,
+	"modern":  ModernConfig,
+}
+
+// Look up a dialect by name
+func Dialect(name string) (Config, error) {
+	if config, ok := Dialects[name]; ok {
+		return config, nil
+	}
+
+	names := make([]string, 0, len(Dialects))
+	for name := range Dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return Config{}, fmt.Errorf("unknown dialect %q, expected one of: %s",
+		name, strings.Join(names, ", "))
+}
+
+// Copy of a set of names with more names added
+func withNames(set map[string]bool, extra ...string) map[string]bool {
+	copied := make(map[string]bool, len(set)+len(extra))
+
+	for word := range set {
+		copied[word] = true
+	}
+
+	for _, word := range extra {
+		copied[word] = true
+	}
+
+	return copied
+}
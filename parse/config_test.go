@@ -0,0 +1,161 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDialectKeywords(t *testing.T) {
+	kinds := map[string]TokenType{"classic": tkIdent, "modern": tkKeyword}
+
+	for name, kind := range kinds {
+		config, err := Dialect(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		lex := NewLexer("", strings.NewReader("for"))
+		lex.Config = config
+
+		if tok, err := lex.NextToken(); err != nil || tok.kind != kind {
+			t.Errorf("%s: expected %v, got %v, %v", name, kind, tok, err)
+		}
+	}
+
+	// The default is classic B
+	if tok, _ := NewLexer("", strings.NewReader("for")).NextToken(); tok.kind != tkIdent {
+		t.Errorf("for is a keyword by default: %v", tok)
+	}
+
+	if _, err := Dialect("ancient"); err == nil {
+		t.Errorf("unknown dialect accepted")
+	}
+}
+
+func TestDialectWordSize(t *testing.T) {
+	// Both dialects have 64 bit words by default
+	for name, config := range Dialects {
+		if bits := config.Bits(); bits != 64 {
+			t.Errorf("%s: expected 64 bit words, got %d", name, bits)
+		}
+
+		src := `main() { putchar('hello'); }`
+		if _, err := NewParserWithConfig("", strings.NewReader(src), config).Parse(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		src = `main() { putchar('abcdefghi'); }`
+		if _, err := NewParserWithConfig("", strings.NewReader(src), config).Parse(); err == nil {
+			t.Errorf("%s: nine character constant accepted", name)
+		}
+	}
+
+	// NewParser agrees with CharacterNode.Int
+	node, err := NewParser("", strings.NewReader("'abcdefgh'")).ParseExpression()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	} else if _, err := node.(CharacterNode).Int(); err != nil {
+		t.Errorf("Int: %v", err)
+	}
+}
+
+func TestConfigWordBits(t *testing.T) {
+	src := `main() { putchar('abc'); }`
+	narrow, wide := ModernConfig, ModernConfig
+	narrow.WordBits, wide.WordBits = 16, 64
+
+	if _, err := NewParserWithConfig("", strings.NewReader(src), narrow).Parse(); err == nil {
+		t.Errorf("16 bits: three character constant accepted")
+	}
+
+	if _, err := NewParserWithConfig("", strings.NewReader(src), wide).Parse(); err != nil {
+		t.Errorf("64 bits: %v", err)
+	}
+
+	char := CharacterNode{Value: "abc"}
+	if _, err := char.IntWithConfig(narrow); err == nil {
+		t.Errorf("16 bits: 'abc' packed into a word")
+	}
+	if value, err := char.IntWithConfig(wide); err != nil || value != 'a'<<16|'b'<<8|'c' {
+		t.Errorf("64 bits: expected %d, got %d, %v", 'a'<<16|'b'<<8|'c', value, err)
+	}
+
+	// Zero is the default, 64 bits
+	if bits := (Config{}).Bits(); bits != 64 {
+		t.Errorf("expected a default of 64 bits, got %d", bits)
+	}
+
+	// Five characters and *e
+	str := StringNode{Value: "hello"}
+	for config, words := range map[*Config]int{&narrow: 3, &wide: 1, &ClassicConfig: 1} {
+		if n := str.Words(*config); n != words {
+			t.Errorf("%d bits: expected %d words, got %d", config.Bits(), words, n)
+		}
+	}
+
+	// Integer literals must fit into a signed word
+	for _, test := range []struct {
+		src string
+		ok  bool
+	}{
+		{"32767", true},
+		{"32768", false},
+		{"077777", true},
+		{"0100000", false},
+	} {
+		_, err := NewParserWithConfig("", strings.NewReader(test.src), narrow).ParseExpression()
+		if test.ok && err != nil {
+			t.Errorf("16 bits: %s: %v", test.src, err)
+		} else if !test.ok && (err == nil ||
+			!strings.Contains(err.Error(), "larger than the maximum 32767")) {
+			t.Errorf("16 bits: %s: expected an overflow, got %v", test.src, err)
+		}
+	}
+
+	if _, err := NewParserWithConfig("", strings.NewReader("32768"), wide).ParseExpression(); err != nil {
+		t.Errorf("64 bits: %v", err)
+	}
+
+	// A Config with only the word size set still has the keywords and
+	// escapes of classic B
+	bare := Config{WordBits: 16}
+	src = `main() { auto x; x = '*n'; if (x) return (x); }`
+	if _, err := NewParserWithConfig("", strings.NewReader(src), bare).Parse(); err != nil {
+		t.Errorf("16 bits without keywords: %v", err)
+	}
+}
+
+func TestConfigEscapes(t *testing.T) {
+	// A dialect with its own escape for a double quote
+	config := ModernConfig
+	config.Escapes = map[byte]byte{'e': 4, 'n': '\n', 'q': '"'}
+
+	char := CharacterNode{Value: "*q"}
+	if value, err := char.IntWithConfig(config); err != nil || value != '"' {
+		t.Errorf("Expected %d, got %d, %v", '"', value, err)
+	}
+	if _, err := char.Int(); err == nil {
+		t.Errorf("*q decoded with the classic escapes")
+	}
+
+	str := StringNode{Value: "*q*n"}
+	if chars := string(str.BytesWithConfig(config)); chars != "\"\n\x04" {
+		t.Errorf("Expected %q, got %q", "\"\n\x04", chars)
+	}
+
+	unit, err := NewParserWithConfig("", strings.NewReader(
+		`f() { return ('*q' + 1); }`), config).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if _, chars, _ := Constants(unit); len(chars) != 1 || chars[0] != '"' {
+		t.Errorf("Expected the character %d, got %v", '"', chars)
+	}
+
+	ret := unit.Funcs[0].Body.(BlockNode).Nodes[0].(ReturnNode)
+	folded, err := FoldWithConfig(ret.Node, config)
+	if num, ok := folded.(IntegerNode); err != nil || !ok || num.Value != '"'+1 {
+		t.Errorf("Expected %d, got %v, %v", '"'+1, folded, err)
+	}
+}
@@ -0,0 +1,73 @@
+package parse
+
+import (
+	"sort"
+)
+
+// Pack the characters of a character literal, decoded with config's
+// escapes, into a word, first character in the highest byte, so 'ab' is
+// 'a'<<8 | 'b'
+func packCharacter(raw string, config Config) int64 {
+	str, err := config.Unescape(raw)
+	if err != nil {
+		str = raw
+	}
+
+	var value int64
+	for i := 0; i < len(str); i++ {
+		value = value<<8 | int64(str[i])
+	}
+
+	return value
+}
+
+// Every distinct literal used in the unit, in both global initializers
+// and function bodies. Characters are given as packed words and strings
+// with their escapes decoded, both using the unit's dialect. Each list is
+// sorted.
+func Constants(unit TranslationUnit) (ints []int64, chars []int64, strs []string) {
+	seenInts := map[int64]bool{}
+	seenChars := map[int64]bool{}
+	seenStrs := map[string]bool{}
+
+	visit := func(n Node) bool {
+		switch n := n.(type) {
+		case IntegerNode:
+			if !seenInts[n.Value] {
+				seenInts[n.Value] = true
+				ints = append(ints, n.Value)
+			}
+		case CharacterNode:
+			if value := packCharacter(n.Value, unit.Config); !seenChars[value] {
+				seenChars[value] = true
+				chars = append(chars, value)
+			}
+		case StringNode:
+			str, err := unit.Unescape(n.Value)
+			if err != nil {
+				str = n.Value
+			}
+
+			if !seenStrs[str] {
+				seenStrs[str] = true
+				strs = append(strs, str)
+			}
+		}
+
+		return true
+	}
+
+	for _, v := range unit.Vars {
+		Walk(v, visit)
+	}
+
+	for _, fn := range unit.Funcs {
+		Walk(fn, visit)
+	}
+
+	sort.Slice(ints, func(i, j int) bool { return ints[i] < ints[j] })
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+	sort.Strings(strs)
+
+	return ints, chars, strs
+}
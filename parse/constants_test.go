@@ -0,0 +1,39 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConstants(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+limit 10;
+table[3] 1, 'a', "x*n";
+main() {
+	auto i;
+	i = 10 + 2;
+	putchar('a');
+	putchar('ab');
+	printf("x*n", 'b', 1);
+	printf("done");
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	ints, chars, strs := Constants(unit)
+
+	if expected := []int64{1, 2, 10}; !reflect.DeepEqual(ints, expected) {
+		t.Errorf("ints: expected %v, got %v", expected, ints)
+	}
+
+	if expected := []int64{'a', 'b', 'a'<<8 | 'b'}; !reflect.DeepEqual(chars, expected) {
+		t.Errorf("chars: expected %v, got %v", expected, chars)
+	}
+
+	if expected := []string{"done", "x\n"}; !reflect.DeepEqual(strs, expected) {
+		t.Errorf("strings: expected %q, got %q", expected, strs)
+	}
+}
@@ -0,0 +1,189 @@
+package parse
+
+import (
+	"text/scanner"
+)
+
+// Where a variable is assigned and where it is read, in source order
+type DefUseInfo struct {
+	Defs []scanner.Position
+	Uses []scanner.Position
+}
+
+// Definitions and uses of each parameter, auto and extrn variable of fn.
+// A plain assignment `x = e` defines x, while `x =+ e`, `x++` and the like
+// both use and define it. Any other mention of x, including taking its
+// address, is a use. Parameters are not given a definition.
+func DefUse(fn FunctionNode) map[string]DefUseInfo {
+	info := map[string]DefUseInfo{}
+
+	for _, param := range fn.Params {
+		info[param] = DefUseInfo{}
+	}
+
+	for _, local := range fn.Locals() {
+		info[local.Name] = DefUseInfo{}
+	}
+
+	Walk(fn.Body, func(n Node) bool {
+		if extrn, ok := n.(ExternVarDeclNode); ok {
+			for _, name := range extrn.Names {
+				info[name] = DefUseInfo{}
+			}
+		}
+		return true
+	})
+
+	record := func(ident IdentNode, def, use bool) {
+		entry, ok := info[ident.Value]
+		if !ok {
+			return
+		}
+
+		if use {
+			entry.Uses = append(entry.Uses, ident.Pos)
+		}
+		if def {
+			entry.Defs = append(entry.Defs, ident.Pos)
+		}
+
+		info[ident.Value] = entry
+	}
+
+	var visit func(Node) bool
+	visit = func(n Node) bool {
+		if ident, ok := assignedIdent(n); ok {
+			assign, isAssign := n.(AssignNode)
+
+			record(ident, true, !isAssign || assign.Op != "=")
+			if isAssign {
+				Walk(assign.Rhs, visit)
+			}
+			return false
+		}
+
+		if ident, ok := n.(IdentNode); ok {
+			record(ident, false, true)
+		}
+		return true
+	}
+
+	Walk(fn.Body, visit)
+
+	return info
+}
+
+// First read of each auto which happens before anything is assigned to
+// it, going by source order. This errs on the side of silence: functions
+// with labels are skipped, as are vectors, autos whose address is taken,
+// and reads inside a loop which assigns the variable anywhere.
+func uninitializedReads(fn FunctionNode) []IdentNode {
+	candidates := map[string]bool{}
+	for _, local := range fn.Locals() {
+		candidates[local.Name] = !local.VecDecl
+	}
+
+	hasLabel := false
+	Walk(fn.Body, func(n Node) bool {
+		switch node := n.(type) {
+		case LabelNode:
+			hasLabel = true
+		case UnaryNode:
+			if ident, ok := node.Node.(IdentNode); ok && node.Oper == "&" {
+				candidates[ident.Value] = false
+			}
+		}
+		return true
+	})
+
+	if hasLabel {
+		return nil
+	}
+
+	// Variables assigned in each loop enclosing the current node
+	var loops []map[string]bool
+
+	assigned := map[string]bool{}
+	var reads []IdentNode
+
+	read := func(ident IdentNode) {
+		if !candidates[ident.Value] || assigned[ident.Value] {
+			return
+		}
+
+		for _, loop := range loops {
+			if loop[ident.Value] {
+				return
+			}
+		}
+
+		reads = append(reads, ident)
+
+		// Only report the first read
+		assigned[ident.Value] = true
+	}
+
+	var visit func(Node) bool
+	visit = func(n Node) bool {
+		switch node := n.(type) {
+		case ForNode, WhileNode:
+			loop := map[string]bool{}
+			Walk(node, func(n Node) bool {
+				if ident, ok := assignedIdent(n); ok {
+					loop[ident.Value] = true
+				}
+				return true
+			})
+
+			loops = append(loops, loop)
+			for _, child := range children(node) {
+				Walk(child, visit)
+			}
+			loops = loops[:len(loops)-1]
+
+			return false
+
+		case AssignNode:
+			if ident, ok := assignedIdent(node); ok {
+				Walk(node.Rhs, visit)
+				if node.Op != "=" {
+					read(ident)
+				}
+				assigned[ident.Value] = true
+				return false
+			}
+
+		case UnaryNode:
+			if ident, ok := assignedIdent(node); ok {
+				read(ident)
+				assigned[ident.Value] = true
+				return false
+			}
+
+		case IdentNode:
+			read(node)
+		}
+
+		return true
+	}
+
+	Walk(fn.Body, visit)
+
+	return reads
+}
+
+// Variable assigned by an assignment, ++ or --, if n is one
+func assignedIdent(n Node) (IdentNode, bool) {
+	switch node := n.(type) {
+	case AssignNode:
+		if ident, ok := node.Lhs.(IdentNode); ok {
+			return ident, true
+		}
+	case UnaryNode:
+		if ident, ok := node.Node.(IdentNode); ok && (node.Oper == "++" || node.Oper == "--") {
+			return ident, true
+		}
+	}
+
+	return IdentNode{}, false
+}
@@ -0,0 +1,53 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"text/scanner"
+)
+
+func TestDefUse(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+f(n) {
+  extrn limit;
+  auto x, v[2];
+  x = n;
+  v[0] = x + limit;
+  n =+ x;
+  g(&v);
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	positions := func(list []scanner.Position) string {
+		strs := make([]string, len(list))
+		for i, pos := range list {
+			strs[i] = fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+		}
+		return strings.Join(strs, " ")
+	}
+
+	expected := map[string][2]string{
+		"x":     {"5:3", "6:10 7:8"},
+		"n":     {"7:3", "5:7 7:3"},
+		"v":     {"", "6:3 8:6"},
+		"limit": {"", "6:14"},
+	}
+
+	info := DefUse(unit.Funcs[0])
+	if len(info) != len(expected) {
+		t.Errorf("Expected %d variables, got %v", len(expected), info)
+	}
+
+	for name, exp := range expected {
+		if defs := positions(info[name].Defs); defs != exp[0] {
+			t.Errorf("%s: expected definitions %q, got %q", name, exp[0], defs)
+		}
+		if uses := positions(info[name].Uses); uses != exp[1] {
+			t.Errorf("%s: expected uses %q, got %q", name, exp[1], uses)
+		}
+	}
+}
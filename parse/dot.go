@@ -0,0 +1,98 @@
+package parse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Render the tree under n as a Graphviz digraph, with a box for each node
+// labeled by its type and telling attribute, such as the operator of a
+// BinaryNode, and an edge from every node to each of its children. Pipe
+// it through `dot -Tpng` to draw the tree.
+func DOT(n Node) string {
+	var b strings.Builder
+	b.WriteString("digraph ast {\n\tnode [shape=box];\n")
+
+	next := 0
+
+	var visit func(n Node) int
+	visit = func(n Node) int {
+		id := next
+		next += 1
+
+		fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n", id, dotEscape(dotLabel(n)))
+
+		for _, child := range children(n) {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", id, visit(child))
+		}
+
+		return id
+	}
+
+	visit(n)
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Type of the node, then the attribute which tells it apart from others
+// of the same type on a second line
+func dotLabel(n Node) string {
+	if n == nil {
+		return "nil"
+	}
+
+	var attr string
+
+	switch node := n.(type) {
+	case AssignNode:
+		attr = node.Op
+	case BinaryNode:
+		attr = node.Oper
+	case CharacterNode, IntegerNode, StringNode:
+		attr = node.String()
+	case ErrorNode:
+		attr = node.Msg
+	case ExternVarDeclNode:
+		attr = strings.Join(node.Names, ", ")
+	case ExternVarInitNode:
+		attr = node.Name
+	case ExternVecInitNode:
+		attr = fmt.Sprintf("%s[%d]", node.Name, node.Size)
+	case FunctionNode:
+		attr = fmt.Sprintf("%s(%s)", node.Name, strings.Join(node.Params, ", "))
+	case IdentNode:
+		attr = node.Value
+	case LabelNode:
+		attr = node.Name
+	case UnaryNode:
+		attr = node.Oper
+		if node.Postfix {
+			attr += " (postfix)"
+		}
+	case VarDeclNode:
+		names := make([]string, len(node.Vars))
+		for i, decl := range node.Vars {
+			names[i] = decl.Name
+			if decl.VecDecl {
+				names[i] += fmt.Sprintf("[%d]", decl.Size)
+			}
+		}
+		attr = strings.Join(names, ", ")
+	}
+
+	label := reflect.TypeOf(n).Name()
+	if attr != "" {
+		label += "\n" + attr
+	}
+
+	return label
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Escape a label for a double quoted DOT string
+func dotEscape(label string) string {
+	return dotEscaper.Replace(label)
+}
@@ -0,0 +1,32 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDOT(t *testing.T) {
+	node, err := NewParser("", strings.NewReader("a + b")).ParseExpression()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := `digraph ast {
+	node [shape=box];
+	n0 [label="BinaryNode\n+"];
+	n1 [label="IdentNode\na"];
+	n0 -> n1;
+	n2 [label="IdentNode\nb"];
+	n0 -> n2;
+}
+`
+	if dot := DOT(node); dot != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, dot)
+	}
+
+	// Quotes in labels are escaped
+	dot := DOT(StringNode{Value: `say *"hi*"`})
+	if !strings.Contains(dot, `n0 [label="StringNode\n\"say *\"hi*\"\""];`) {
+		t.Errorf("Expected an escaped label, got:\n%s", dot)
+	}
+}
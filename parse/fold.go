@@ -0,0 +1,223 @@
+package parse
+
+import (
+	"fmt"
+)
+
+// Replace each expression in n whose operands are all constant with its
+// value, working outwards, so `2 + 3 * 4` becomes `14`. A ternary with a
+// constant condition becomes the branch it selects. Dividing anything by
+// a constant zero is an error. Arithmetic is done on 64 bit words, and
+// character constants are decoded with the escapes of classic B.
+func Fold(n Node) (Node, error) {
+	return FoldWithConfig(n, Config{})
+}
+
+// Fold n, decoding character constants with config's escapes
+func FoldWithConfig(n Node, config Config) (Node, error) {
+	var err error
+
+	folded := rewrite(n, func(n Node) Node {
+		if err != nil {
+			return n
+		}
+
+		switch node := n.(type) {
+		case ParenNode:
+			if num, ok := node.Node.(IntegerNode); ok {
+				return num
+			}
+
+		case UnaryNode:
+			if value, ok := constantValue(node.Node, config); ok {
+				switch node.Oper {
+				case "-":
+					return IntegerNode{Value: -value}
+				case "!":
+					return IntegerNode{Value: truth(value == 0)}
+				case "~":
+					return IntegerNode{Value: ^value}
+				}
+			}
+
+		case BinaryNode:
+			left, lok := constantValue(node.Left, config)
+			right, rok := constantValue(node.Right, config)
+
+			if rok && right == 0 && (node.Oper == "/" || node.Oper == "%") {
+				err = NewSemanticError(node, "division by zero")
+				break
+			} else if !lok || !rok {
+				break
+			}
+
+			if value, ok := foldBinary(node.Oper, left, right); ok {
+				return IntegerNode{Value: value}
+			}
+
+		case TernaryNode:
+			if cond, ok := constantValue(node.Cond, config); ok {
+				if cond != 0 && node.Elvis() {
+					return node.Cond
+				} else if cond != 0 {
+					return node.TrueBody
+				}
+				return node.FalseBody
+			}
+		}
+
+		return n
+	})
+
+	if err != nil {
+		return n, err
+	}
+
+	return folded, nil
+}
+
+// Replace each call to the size builtin, `size(v)`, with the declared
+// size of the vector v, which must be an auto or external vector. The
+// unit must have size as one of its builtins, as in ModernConfig. The
+// tree is left as written by the parser, so each backend folds the sizes
+// before generating code.
+func (t TranslationUnit) FoldSizes() (TranslationUnit, error) {
+	// Size of each vector, and -1 for other variables
+	globals := map[string]int{}
+
+	for _, v := range t.Vars {
+		switch v := v.(type) {
+		case ExternVarInitNode:
+			globals[v.Name] = -1
+		case ExternVecInitNode:
+			globals[v.Name] = v.Size
+		}
+	}
+
+	folded := t
+	folded.Funcs = make([]FunctionNode, len(t.Funcs))
+
+	for i, fn := range t.Funcs {
+		sizes := map[string]int{}
+		for name, size := range globals {
+			sizes[name] = size
+		}
+
+		for _, param := range fn.Params {
+			sizes[param] = -1
+		}
+
+		for _, local := range fn.Locals() {
+			if local.VecDecl {
+				sizes[local.Name] = local.Size
+			} else {
+				sizes[local.Name] = -1
+			}
+		}
+
+		// A variable named size hides the builtin
+		if _, ok := sizes["size"]; ok {
+			folded.Funcs[i] = fn
+			continue
+		}
+
+		var err error
+
+		fn.Body = rewrite(fn.Body, func(n Node) Node {
+			if name, ok := t.BuiltinCall(n); !ok || name != "size" || err != nil {
+				return n
+			}
+
+			call := n.(FunctionCallNode)
+
+			if len(call.Args) != 1 {
+				err = NewSemanticError(call, "size expects one argument")
+				return n
+			}
+
+			ident, ok := call.Args[0].(IdentNode)
+			if size, known := sizes[ident.Value]; !ok || !known {
+				err = NewSemanticError(call, fmt.Sprintf(
+					"size of %v, which isn't a declared vector", call.Args[0]))
+			} else if size < 0 {
+				err = NewSemanticError(call, fmt.Sprintf(
+					"size of %s, which isn't a vector", ident.Value))
+			} else {
+				return IntegerNode{Value: int64(size)}
+			}
+
+			return n
+		})
+
+		if err != nil {
+			return t, err
+		}
+
+		folded.Funcs[i] = fn
+	}
+
+	return folded, nil
+}
+
+// Value of an integer or character literal
+func constantValue(n Node, config Config) (int64, bool) {
+	switch node := n.(type) {
+	case IntegerNode:
+		return node.Value, true
+	case CharacterNode:
+		return packCharacter(node.Value, config), true
+	}
+
+	return 0, false
+}
+
+// Apply a binary operator to constants. Assignments and shifts by a
+// negative or oversized amount aren't folded.
+func foldBinary(oper string, a, b int64) (int64, bool) {
+	switch oper {
+	case "+":
+		return a + b, true
+	case "-":
+		return a - b, true
+	case "*":
+		return a * b, true
+	case "/":
+		return a / b, true
+	case "%":
+		return a % b, true
+	case "<<", ">>":
+		if b < 0 || b >= 64 {
+			return 0, false
+		} else if oper == "<<" {
+			return a << uint(b), true
+		}
+		return a >> uint(b), true
+	case "&":
+		return a & b, true
+	case "|":
+		return a | b, true
+	case "^":
+		return a ^ b, true
+	case "==":
+		return truth(a == b), true
+	case "!=":
+		return truth(a != b), true
+	case "<":
+		return truth(a < b), true
+	case "<=":
+		return truth(a <= b), true
+	case ">":
+		return truth(a > b), true
+	case ">=":
+		return truth(a >= b), true
+	}
+
+	return 0, false
+}
+
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
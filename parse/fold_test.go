@@ -0,0 +1,96 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	var tests = []struct {
+		src, expected string
+	}{
+		{"2 + 3 * 4", "14"},
+		{"(1 + 2) * -(3 - 5)", "6"},
+		{"'a' + 1", "98"},
+		{"x + 2 * 3", "x + 6"},
+		{"x = 1 << 4 | 1", "x = 17"},
+		{"1 < 2 ? a : b", "a"},
+		{"x ? 1 + 1 : 0", "x ? 2 : 0"},
+		{"f(10 / 3, 10 % 3, !5, ~0)", "f(3, 1, 0, -1)"},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+
+		folded, err := Fold(*node)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if folded.String() != test.expected {
+			t.Errorf("%s: expected %s, got %v", test.src, test.expected, folded)
+		}
+	}
+}
+
+func TestFoldDivisionByZero(t *testing.T) {
+	for _, src := range []string{"1 / 0", "x = 5 % (2 - 2)", "x / 0"} {
+		node, err := NewParser("", strings.NewReader(src)).parseExpression()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+
+		if _, err := Fold(*node); err == nil ||
+			!strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%s: expected division by zero, got %v", src, err)
+		}
+	}
+}
+
+func TestFoldSizes(t *testing.T) {
+	src := `table [4] 1, 2;
+count 0;
+
+f(p) {
+	auto v[10], n;
+	extrn table;
+	n = size(v) + size(table);
+	return (size(v));
+}
+`
+
+	unit, err := NewParserWithConfig("", strings.NewReader(src), ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	folded, err := unit.FoldSizes()
+	if err != nil {
+		t.Fatalf("FoldSizes: %v", err)
+	}
+
+	body := folded.Funcs[0].Body.(BlockNode).Nodes
+	if str := body[2].String(); str != "n = 10 + 4;" {
+		t.Errorf("Expected n = 10 + 4;, got %s", str)
+	}
+
+	ret := body[3].(ReturnNode)
+	if !Equal(ret.Node, ParenNode{Node: IntegerNode{Value: 10}}) {
+		t.Errorf("Expected size(v) to fold to 10, got %v", ret.Node)
+	}
+
+	for _, call := range []string{"size(n)", "size(p)", "size(count)",
+		"size(v[1])", "size(v, v)"} {
+		src := "f(p) { auto v[10], n; extrn count; return (" + call + "); }\ncount 0;"
+
+		unit, err := NewParserWithConfig("", strings.NewReader(src), ModernConfig).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+
+		if _, err := unit.FoldSizes(); err == nil {
+			t.Errorf("%s: expected an error", call)
+		}
+	}
+}
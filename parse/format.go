@@ -0,0 +1,153 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where the opening brace of a function or statement body goes
+type BraceStyle int
+
+const (
+	KRBraces     BraceStyle = iota // On the same line: `if(a) {`
+	AllmanBraces                   // On the next line, at the same indent
+)
+
+type FormatOptions struct {
+	BraceStyle BraceStyle
+}
+
+// Source text of the unit, with one tab of indentation per level of
+// nesting, which parses back to the same tree
+func (t TranslationUnit) Format() string {
+	return t.FormatWith(FormatOptions{})
+}
+
+// Source text of the unit formatted as Format, laid out following opts
+func (t TranslationUnit) FormatWith(opts FormatOptions) string {
+	var str string
+
+	for _, v := range t.Vars {
+		str += v.String() + "\n"
+	}
+
+	for i, f := range t.Funcs {
+		if i > 0 || len(t.Vars) > 0 {
+			str += "\n"
+		}
+
+		str += opts.formatNode(f, 0) + "\n"
+	}
+
+	return str
+}
+
+// Source text of a statement or function which starts on a line indented
+// to level. Lines nested inside it are indented further.
+func (opts FormatOptions) formatNode(n Node, level int) string {
+	indent := strings.Repeat("\t", level)
+
+	switch node := n.(type) {
+	case FunctionNode:
+		return fmt.Sprintf("%s(%s)%s", node.Name,
+			strings.Join(node.Params, ", "), opts.body(node.Body, level))
+
+	case BlockNode:
+		str := "{\n"
+
+		for _, stmt := range node.Nodes {
+			str += indent + "\t" + opts.formatNode(stmt, level+1) + "\n"
+		}
+
+		return str + indent + "}"
+
+	case IfNode:
+		// Keep an else from attaching to an if nested in the body
+		body := node.Body
+		if node.HasElse && danglingIf(body) {
+			body = BlockNode{Nodes: []Node{body}}
+		}
+
+		str := fmt.Sprintf("if(%v)%s", node.Cond, opts.body(body, level))
+
+		if node.HasElse {
+			if _, ok := body.(BlockNode); ok && opts.BraceStyle == AllmanBraces {
+				str += "\n" + indent + "else"
+			} else {
+				str += " else"
+			}
+
+			str += opts.body(node.ElseBody, level)
+		}
+
+		return str
+
+	case WhileNode:
+		return fmt.Sprintf("while(%v)%s", node.Cond,
+			opts.body(node.Body, level))
+
+	case ForNode:
+		return fmt.Sprintf("for(%v;%s;%s)%s", node.Init,
+			forClause(node.Cond), forClause(node.Step),
+			opts.body(node.Body, level))
+
+	case SwitchNode:
+		str := fmt.Sprintf("switch(%v)%s{", node.Cond, opts.brace(level))
+
+		for _, c := range node.Cases {
+			str += fmt.Sprintf("\n%s\tcase %v:", indent, c.Cond)
+
+			for _, stmt := range c.Statements {
+				str += "\n" + indent + "\t\t" + opts.formatNode(stmt, level+2)
+			}
+		}
+
+		if node.DefaultCase != nil {
+			str += "\n" + indent + "\tdefault:"
+
+			for _, stmt := range node.DefaultCase {
+				str += "\n" + indent + "\t\t" + opts.formatNode(stmt, level+2)
+			}
+		}
+
+		return str + "\n" + indent + "}"
+
+	case NullNode:
+		return ";"
+	}
+
+	return n.String()
+}
+
+// Body of a function or statement which starts on a line indented to
+// level, along with the space separating it from what comes before
+func (opts FormatOptions) body(n Node, level int) string {
+	if _, ok := n.(BlockNode); ok {
+		return opts.brace(level) + opts.formatNode(n, level)
+	}
+
+	return " " + opts.formatNode(n, level)
+}
+
+// What goes before an opening brace
+func (opts FormatOptions) brace(level int) string {
+	if opts.BraceStyle == AllmanBraces {
+		return "\n" + strings.Repeat("\t", level)
+	}
+
+	return " "
+}
+
+// Whether a statement ends with an if that has no else
+func danglingIf(n Node) bool {
+	switch node := n.(type) {
+	case IfNode:
+		return !node.HasElse || danglingIf(node.ElseBody)
+	case WhileNode:
+		return danglingIf(node.Body)
+	case ForNode:
+		return danglingIf(node.Body)
+	}
+
+	return false
+}
@@ -0,0 +1,206 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	src := `limit 10;
+table [2] 'a', "b*n", 3;
+f(a, b) {
+	auto x, v[3];
+	if (a) {
+		while (b) {
+			if (a == 2) {
+				{ x =+ 1; }
+			} else
+				g(x);
+			b--;
+		}
+	}
+	switch (a) { case 1: x = 2; case 2: { break; } default: ; }
+	for (;;) if (x) if (b) break; else ; else return;
+	while (x) ;
+	x = - -1; x = - -a; x = - --a; x = -(-a);
+	switch (b) { case 1: x = 1; default: }
+done:
+	return (x ? -a : v[1]);
+}
+g(x) return (x);
+`
+
+	unit, err := NewParserWithConfig("", strings.NewReader(src), ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	formatted := unit.Format()
+
+	expected := `limit 10;
+table [2] 'a', "b*n", 3;
+
+f(a, b) {
+	auto x, v[3];
+	if(a) {
+		while(b) {
+			if(a == 2) {
+				{
+					x =+ 1;
+				}
+			} else g(x);
+			b--;
+		}
+	}
+	switch(a) {
+		case 1:
+			x = 2;
+		case 2:
+			{
+				break;
+			}
+		default:
+			;
+	}
+	for(;;) if(x) if(b) break; else ; else return ;
+	while(x) ;
+	x = - -1;
+	x = - -a;
+	x = - --a;
+	x = -(-a);
+	switch(b) {
+		case 1:
+			x = 1;
+		default:
+	}
+	done:
+	return (x ? -a : v[1]);
+}
+
+g(x) return (x);
+`
+	if formatted != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+
+	reparsed, err := NewParserWithConfig("", strings.NewReader(formatted), ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Formatted source doesn't parse: %v", err)
+	}
+
+	if len(reparsed.Funcs) != len(unit.Funcs) || len(reparsed.Vars) != len(unit.Vars) {
+		t.Fatalf("Round trip: expected %v, got %v", unit, reparsed)
+	}
+
+	for i, v := range unit.Vars {
+		if !Equal(v, reparsed.Vars[i]) {
+			t.Errorf("Round trip: expected %v, got %v", v, reparsed.Vars[i])
+		}
+	}
+
+	for i, fn := range unit.Funcs {
+		if !Equal(fn, reparsed.Funcs[i]) {
+			t.Errorf("Round trip: expected %v, got %v", fn, reparsed.Funcs[i])
+		}
+	}
+
+	if reparsed.Format() != formatted {
+		t.Errorf("Formatting is not stable:\n%s", reparsed.Format())
+	}
+}
+
+// An if without an else inside one with an else can't be written without
+// braces
+func TestFormatDanglingElse(t *testing.T) {
+	inner := IfNode{Cond: IdentNode{Value: "b"}, Body: BreakNode{}}
+	outer := IfNode{Cond: IdentNode{Value: "a"},
+		Body:    WhileNode{Cond: IdentNode{Value: "c"}, Body: inner},
+		HasElse: true, ElseBody: ReturnNode{Node: NullNode{}}}
+
+	expected := "if(a) {\n\twhile(c) if(b) break;\n} else return ;"
+	formatted := FormatOptions{}.formatNode(outer, 0)
+	if formatted != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+}
+
+func TestFormatBraceStyle(t *testing.T) {
+	src := `f(a) {
+	if (a) { g(); } else if (a > 1) { h(); } else g();
+	while (a--) { switch (a) { case 1: g(); } }
+	for (;;) return;
+}
+`
+
+	var tests = []struct {
+		style    BraceStyle
+		expected string
+	}{
+		{KRBraces, `f(a) {
+	if(a) {
+		g();
+	} else if(a > 1) {
+		h();
+	} else g();
+	while(a--) {
+		switch(a) {
+			case 1:
+				g();
+		}
+	}
+	for(;;) return ;
+}
+`},
+		{AllmanBraces, `f(a)
+{
+	if(a)
+	{
+		g();
+	}
+	else if(a > 1)
+	{
+		h();
+	}
+	else g();
+	while(a--)
+	{
+		switch(a)
+		{
+			case 1:
+				g();
+		}
+	}
+	for(;;) return ;
+}
+`},
+	}
+
+	unit, err := NewParserWithConfig("", strings.NewReader(src), ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	for _, test := range tests {
+		opts := FormatOptions{BraceStyle: test.style}
+
+		formatted := unit.FormatWith(opts)
+		if formatted != test.expected {
+			t.Errorf("Expected:\n%s\ngot:\n%s", test.expected, formatted)
+			continue
+		}
+
+		reparsed, err := NewParserWithConfig("", strings.NewReader(formatted), ModernConfig).Parse()
+		if err != nil {
+			t.Fatalf("Formatted source doesn't parse: %v", err)
+		}
+
+		if !Equal(unit.Funcs[0], reparsed.Funcs[0]) {
+			t.Errorf("Round trip: expected %v, got %v", unit.Funcs[0],
+				reparsed.Funcs[0])
+		}
+
+		if again := reparsed.FormatWith(opts); again != formatted {
+			t.Errorf("Formatting is not stable:\n%s", again)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// CSS class used for each kind of token
+func (t TokenType) class() string {
+	switch t {
+	case tkError:
+		return "error"
+	case tkNumber:
+		return "number"
+	case tkIdent:
+		return "ident"
+	case tkString:
+		return "string"
+	case tkCharacter:
+		return "character"
+	case tkKeyword:
+		return "keyword"
+	case tkOperator, tkTernary:
+		return "operator"
+	}
+
+	return "punctuation"
+}
+
+// Write src as HTML with every token wrapped in a <span> whose class
+// names the kind of token, for coloring with a stylesheet. Whitespace is
+// kept as is and comments are wrapped in a "comment" span. Malformed
+// tokens are wrapped in an "error" span rather than stopping output.
+func EmitHTML(name string, src []byte, w io.Writer) error {
+	lex := NewLexer(name, bytes.NewReader(src))
+	lex.KeepTrivia = true
+
+	for {
+		tok, _ := lex.NextToken()
+
+		if _, err := io.WriteString(w, triviaHTML(tok.trivia)); err != nil {
+			return err
+		}
+
+		if tok.kind == tkEof {
+			return nil
+		}
+
+		text := lex.text(tok.start.Offset, tok.end.Offset)
+
+		if _, err := fmt.Fprintf(w, `<span class="%s">%s</span>`,
+			tok.kind.class(), html.EscapeString(text)); err != nil {
+			return err
+		}
+	}
+}
+
+func triviaHTML(trivia string) string {
+	out := ""
+
+	for {
+		start := strings.Index(trivia, "/*")
+		if start < 0 {
+			return out + html.EscapeString(trivia)
+		}
+
+		end := strings.Index(trivia[start:], "*/")
+		if end < 0 {
+			end = len(trivia)
+		} else {
+			end += start + 2
+		}
+
+		out += html.EscapeString(trivia[:start]) +
+			`<span class="comment">` +
+			html.EscapeString(trivia[start:end]) + `</span>`
+
+		trivia = trivia[end:]
+	}
+}
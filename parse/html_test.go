@@ -0,0 +1,32 @@
+package parse
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEmitHTML(t *testing.T) {
+	var out bytes.Buffer
+
+	src := "main() {\n  /* hi */ auto a;\n  a = \"<b>\" ¿;\n}\n"
+
+	if err := EmitHTML("", []byte(src), &out); err != nil {
+		t.Fatalf("EmitHTML: %v", err)
+	}
+
+	html := out.String()
+
+	for _, expected := range []string{
+		`<span class="keyword">auto</span>`,
+		`<span class="ident">main</span>`,
+		`<span class="comment">/* hi */</span> `,
+		`<span class="string">&#34;&lt;b&gt;&#34;</span>`,
+		`<span class="error">¿</span>`,
+		"<span class=\"punctuation\">}</span>\n",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("EmitHTML: expected %s in %s", expected, html)
+		}
+	}
+}
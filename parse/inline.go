@@ -0,0 +1,254 @@
+package parse
+
+// Replace calls to small functions with the functions' bodies.
+//
+// A function is inlined when its body is a single `return expr;` of at
+// most maxNodes nodes and it is not recursive. Arguments are substituted
+// for parameters, so calls are skipped where that would change meaning:
+// an argument with side effects which isn't used exactly once and
+// unconditionally, more than one such argument, a parameter that is
+// modified, or a name in the body that the caller shadows with a local.
+func Inline(unit TranslationUnit, maxNodes int) TranslationUnit {
+	recursive := map[string]bool{}
+	for _, cycle := range Cycles(unit) {
+		for _, name := range cycle {
+			recursive[name] = true
+		}
+	}
+
+	candidates := map[string]FunctionNode{}
+	for _, fn := range unit.Funcs {
+		if _, ok := inlineBody(fn); ok && !recursive[fn.Name] &&
+			NodeCount(TranslationUnit{Funcs: []FunctionNode{fn}}) <= maxNodes {
+			candidates[fn.Name] = fn
+		}
+	}
+
+	inlined := unit
+	inlined.Funcs = make([]FunctionNode, len(unit.Funcs))
+
+	for i, fn := range unit.Funcs {
+		locals := localNames(fn)
+		addressed := addressedNames(fn)
+
+		inlined.Funcs[i] = rewrite(fn, func(n Node) Node {
+			call, ok := n.(FunctionCallNode)
+			if !ok {
+				return n
+			}
+
+			ident, ok := call.Callable.(IdentNode)
+			if !ok || locals[ident.Value] {
+				return n
+			}
+
+			callee, ok := candidates[ident.Value]
+			if !ok {
+				return n
+			}
+
+			if expr, ok := substitute(callee, call.Args, locals, addressed); ok {
+				return ParenNode{Node: expr}
+			}
+
+			return n
+		}).(FunctionNode)
+	}
+
+	return inlined
+}
+
+// Expression returned by a function consisting of a lone return
+func inlineBody(fn FunctionNode) (Node, bool) {
+	block, ok := fn.Body.(BlockNode)
+	if !ok || len(block.Nodes) != 1 {
+		return nil, false
+	}
+
+	ret, ok := block.Nodes[0].(ReturnNode)
+	if !ok {
+		return nil, false
+	}
+
+	if _, ok := ret.Node.(NullNode); ok {
+		return nil, false
+	}
+
+	return ret.Node, true
+}
+
+// Names declared inside fn, which would capture references to globals
+func localNames(fn FunctionNode) map[string]bool {
+	locals := map[string]bool{}
+
+	for _, param := range fn.Params {
+		locals[param] = true
+	}
+
+	Walk(fn.Body, func(n Node) bool {
+		if decl, ok := n.(VarDeclNode); ok {
+			for _, v := range decl.Vars {
+				locals[v.Name] = true
+			}
+		}
+		return true
+	})
+
+	return locals
+}
+
+// Names whose address is taken in fn, which may change without being
+// assigned by name
+func addressedNames(fn FunctionNode) map[string]bool {
+	addressed := map[string]bool{}
+
+	Walk(fn.Body, func(n Node) bool {
+		if unary, ok := n.(UnaryNode); ok && unary.Oper == "&" {
+			if ident, ok := unary.Node.(IdentNode); ok {
+				addressed[ident.Value] = true
+			}
+		}
+		return true
+	})
+
+	return addressed
+}
+
+// Callee's return expression with args in place of its parameters
+func substitute(callee FunctionNode, args []Node, locals, addressed map[string]bool) (Node, bool) {
+	if len(args) != len(callee.Params) {
+		return nil, false
+	}
+
+	expr, _ := inlineBody(callee)
+
+	params := map[string]Node{}
+	for i, param := range callee.Params {
+		params[param] = args[i]
+	}
+
+	uses := map[string]int{}
+	conditional := map[string]bool{}
+	safe := true
+
+	// Parameters used in a branch of a ternary may never be evaluated
+	var visit func(n Node, inBranch bool)
+	visit = func(n Node, inBranch bool) {
+		switch node := n.(type) {
+		case IdentNode:
+			if _, ok := params[node.Value]; ok {
+				uses[node.Value] += 1
+				conditional[node.Value] = conditional[node.Value] || inBranch
+			} else if locals[node.Value] {
+				safe = false
+			}
+		case AssignNode:
+			if _, ok := params[node.Lhs.String()]; ok {
+				safe = false
+			}
+		case UnaryNode:
+			if _, ok := params[node.Node.String()]; ok {
+				switch node.Oper {
+				case "&", "++", "--":
+					safe = false
+				}
+			}
+		case TernaryNode:
+			visit(node.Cond, inBranch)
+			visit(node.TrueBody, true)
+			visit(node.FalseBody, true)
+			return
+		}
+
+		for _, child := range children(n) {
+			visit(child, inBranch)
+		}
+	}
+
+	visit(expr, false)
+
+	// An argument with side effects must still run exactly once, and
+	// with more than one their order could change
+	impure := 0
+	for param, arg := range params {
+		if hasSideEffects(arg) {
+			impure += 1
+			if uses[param] != 1 || conditional[param] {
+				safe = false
+			}
+		}
+	}
+
+	if !safe || impure > 1 {
+		return nil, false
+	}
+
+	// Called, the arguments are evaluated before the body. Inlined, the
+	// body's own side effects could run first, and change what an
+	// argument reads.
+	if hasSideEffects(expr) {
+		for _, arg := range params {
+			if !isStable(arg, locals, addressed) {
+				return nil, false
+			}
+		}
+	}
+
+	return rewrite(expr, func(n Node) Node {
+		if ident, ok := n.(IdentNode); ok {
+			if arg, ok := params[ident.Value]; ok {
+				if isSimple(arg) {
+					return arg
+				}
+				return ParenNode{Node: arg}
+			}
+		}
+		return n
+	}), true
+}
+
+// Whether evaluating n may do more than produce a value: call a
+// function, assign, or increment or decrement
+func hasSideEffects(n Node) bool {
+	return !WalkFunc(n, func(n Node) WalkAction {
+		switch node := n.(type) {
+		case FunctionCallNode, AssignNode:
+			return WalkStop
+		case UnaryNode:
+			if node.Oper == "++" || node.Oper == "--" {
+				return WalkStop
+			}
+		}
+		return WalkContinue
+	})
+}
+
+// Whether n has no side effects and reads only locals which nothing
+// else can reach, so its value can't change under a call
+func isStable(n Node, locals, addressed map[string]bool) bool {
+	return !hasSideEffects(n) && WalkFunc(n, func(n Node) WalkAction {
+		switch node := n.(type) {
+		case IdentNode:
+			if !locals[node.Value] || addressed[node.Value] {
+				return WalkStop
+			}
+		case ArrayAccessNode:
+			return WalkStop
+		case UnaryNode:
+			if node.Oper == "*" {
+				return WalkStop
+			}
+		}
+		return WalkContinue
+	})
+}
+
+// Expressions which can be substituted without parentheses
+func isSimple(n Node) bool {
+	switch n.(type) {
+	case IdentNode, IntegerNode, CharacterNode, StringNode:
+		return true
+	}
+
+	return false
+}
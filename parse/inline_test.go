@@ -0,0 +1,124 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+square(x) { return x * x; }
+twice(x) { return x + x; }
+main() {
+  auto a;
+  a = square(3) + square(a);
+  return twice(getchar());
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	inlined := Inline(unit, 10)
+	main := inlined.Funcs[2]
+
+	if graph := CallGraph(inlined); len(graph["main"]) != 2 ||
+		graph["main"][0] != "getchar" || graph["main"][1] != "twice" {
+		t.Errorf("Inlined calls: %v", graph["main"])
+	}
+
+	body := main.Body.(BlockNode)
+	if str := body.Nodes[1].String(); str != "a = (3 * 3) + (a * a);" {
+		t.Errorf("Inlined square: %s", str)
+	}
+
+	// The original tree is untouched
+	if str := unit.Funcs[2].Body.(BlockNode).Nodes[1].String(); str !=
+		"a = square(3) + square(a);" {
+		t.Errorf("Original modified: %s", str)
+	}
+}
+
+func TestInlineSideEffects(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+zero(x) { return (0); }
+pick(c, x) { return (c ? x : 0); }
+next(x) { return (x + 1); }
+diff(a, b) { return (b - a); }
+main() {
+  zero(putchar('a'));
+  pick(0, getchar());
+  next(getchar());
+  diff(getchar(), getchar());
+  return (zero(1) + pick(1, 2));
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	body := Inline(unit, 10).Funcs[4].Body.(BlockNode)
+
+	expected := []string{
+		// Dropping the argument would drop the call
+		"zero(putchar('a'));",
+		// The argument may never be evaluated
+		"pick(0, getchar());",
+		// Used once, unconditionally
+		"(((getchar()) + 1));",
+		// The arguments would be read in the other order
+		"diff(getchar(), getchar());",
+		// Arguments without side effects are fine either way
+		"return (((0)) + ((1 ? 2 : 0)));",
+	}
+
+	for i, str := range expected {
+		if body.Nodes[i].String() != str {
+			t.Errorf("Expected %s, got %s", str, body.Nodes[i])
+		}
+	}
+}
+
+func TestInlineCalleeSideEffects(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+count;
+next(x) { return (putchar('a') + x); }
+bump(x) { return ((count = count + 1) + x); }
+main() {
+  auto a, p;
+  p = &a;
+  next(putchar('b'));
+  bump(count);
+  bump(a);
+  next(p);
+  next(1);
+  return (next(*p));
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	body := Inline(unit, 10).Funcs[2].Body.(BlockNode)
+
+	expected := []string{
+		"p = &a;",
+		// The callee's putchar would run before the argument's
+		"next(putchar('b'));",
+		// The callee changes the global before it's read
+		"bump(count);",
+		// The callee could reach a through p
+		"bump(a);",
+		// Locals whose address isn't taken and literals can't change
+		"((putchar('a') + p));",
+		"((putchar('a') + 1));",
+		// Memory read through a pointer can
+		"return (next(*p));",
+	}
+
+	for i, str := range expected {
+		if body.Nodes[i+1].String() != str {
+			t.Errorf("Expected %s, got %s", str, body.Nodes[i+1])
+		}
+	}
+}
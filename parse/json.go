@@ -0,0 +1,69 @@
+package parse
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Encode the unit's AST as JSON. Every node becomes an object with a
+// "Type" field naming its node type, such as "BinaryNode", alongside its
+// fields, with child nodes nested.
+func (t TranslationUnit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"File":  t.File,
+		"Vars":  jsonValue(reflect.ValueOf(t.Vars)),
+		"Funcs": jsonValue(reflect.ValueOf(t.Funcs)),
+	})
+}
+
+var (
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+	tokenType = reflect.TypeOf(Token{})
+)
+
+// Convert a value from the AST into maps, slices and scalars which
+// encoding/json can handle
+func jsonValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return v.Interface().(Token).String()
+		}
+
+		obj := map[string]interface{}{}
+		if v.Type().Implements(nodeType) {
+			obj["Type"] = v.Type().Name()
+		}
+
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+
+			if field.Anonymous {
+				// The position every node embeds
+				for name, value := range jsonValue(v.Field(i)).(map[string]interface{}) {
+					obj[name] = value
+				}
+			} else if field.PkgPath == "" {
+				obj[field.Name] = jsonValue(v.Field(i))
+			}
+		}
+
+		return obj
+
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = jsonValue(v.Index(i))
+		}
+		return list
+	}
+
+	return v.Interface()
+}
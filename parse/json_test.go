@@ -0,0 +1,67 @@
+package parse
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	unit, err := NewParser("file.b", strings.NewReader(`
+limit 10;
+main(argc, argv) {
+	extrn limit;
+	if (argc > limit) putchar('x');
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	data, err := json.Marshal(unit)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, data)
+	}
+
+	if decoded["File"] != "file.b" {
+		t.Errorf("File: %v", decoded["File"])
+	}
+
+	fn := decoded["Funcs"].([]interface{})[0].(map[string]interface{})
+
+	if fn["Type"] != "FunctionNode" || fn["Name"] != "main" {
+		t.Errorf("Function: %v", fn)
+	}
+
+	if params := fn["Params"]; !reflect.DeepEqual(params, []interface{}{"argc", "argv"}) {
+		t.Errorf("Params: %v", params)
+	}
+
+	// Children are nested, down to the leaves
+	body := fn["Body"].(map[string]interface{})
+	stmts := body["Nodes"].([]interface{})
+
+	if extrn := stmts[0].(map[string]interface{}); extrn["Type"] != "ExternVarDeclNode" ||
+		!reflect.DeepEqual(extrn["Names"], []interface{}{"limit"}) {
+		t.Errorf("extrn: %v", extrn)
+	}
+
+	call := stmts[1].(map[string]interface{})["Body"].(map[string]interface{})["Expr"].(map[string]interface{})
+	arg := call["Args"].([]interface{})[0].(map[string]interface{})
+
+	if arg["Type"] != "CharacterNode" || arg["Value"] != "x" {
+		t.Errorf("Call argument: %v", arg)
+	}
+
+	global := decoded["Vars"].([]interface{})[0].(map[string]interface{})
+	if global["Type"] != "ExternVarInitNode" ||
+		global["Value"].(map[string]interface{})["Value"] != 10.0 {
+		t.Errorf("Global: %v", global)
+	}
+}
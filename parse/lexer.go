@@ -1,9 +1,12 @@
 package parse
 
 import (
+	"bufio"
+	"bytes"
 	"container/list"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"text/scanner"
 	"unicode"
@@ -15,9 +18,29 @@ type Lexer struct {
 	name      string
 	scanner   scanner.Scanner
 	lookahead *list.List
+
+	// Copy of the input read so far, with its line endings as they
+	// were, and the offset where the last token ended. Offsets are into
+	// the input as the scanner sees it, with line endings normalized,
+	// and newlines maps them back.
+	src      bytes.Buffer
+	newlines *newlineReader
+	lastEnd  int
+
+	// Record the whitespace and comments preceding each token
+	KeepTrivia bool
+
+	// Return each comment as a token holding the text between its /* and
+	// */, for tools such as documentation generators. The parser doesn't
+	// accept these, so they're skipped by default.
+	KeepComments bool
+
+	// Dialect of B to lex, ClassicConfig by default
+	Config Config
 }
 
-var keywords = map[string]bool{
+// Keywords of classic B, which can't be used as names
+var Keywords = map[string]bool{
 	"auto":    true,
 	"break":   true,
 	"case":    true,
@@ -34,6 +57,7 @@ var keywords = map[string]bool{
 type LexError struct {
 	pos scanner.Position
 	msg string
+	eof bool // The input ended inside a comment or literal
 }
 
 func (l *LexError) Error() string {
@@ -43,51 +67,183 @@ func (l *LexError) Error() string {
 }
 
 func NewLexError(pos scanner.Position, msg string) error {
-	return &LexError{pos, msg}
+	return &LexError{pos: pos, msg: msg}
+}
+
+// Whether the input ended partway through a comment or literal, so more
+// input could complete it
+func (l *LexError) UnexpectedEOF() bool {
+	return l.eof
 }
 
 func NewLexer(name string, input io.Reader) *Lexer {
 	lex := &Lexer{
-		name:      name,
 		lookahead: list.New(),
+		Config:    ClassicConfig,
 	}
 
-	lex.scanner.Init(input)
-	lex.scanner.Mode = scanner.ScanIdents | scanner.ScanInts |
-		scanner.ScanStrings
-
+	lex.Reset(name, input)
 	return lex
 }
 
+// Start lexing a new input from its first line, discarding anything read
+// or peeked from the last. KeepTrivia, KeepComments and Config are left
+// as they are.
+func (lex *Lexer) Reset(name string, input io.Reader) {
+	lex.name = name
+	lex.lookahead.Init()
+	lex.src.Reset()
+	lex.lastEnd = 0
+
+	lex.newlines = &newlineReader{r: bufio.NewReader(io.TeeReader(input, &lex.src))}
+	lex.scanner.Init(lex.newlines)
+	// Strings are scanned by hand, since B escapes with '*', and so are
+	// numbers, which follow B's rules rather than Go's
+	lex.scanner.Mode = scanner.ScanIdents
+}
+
+// Reader turning \r\n and lone \r line endings into \n, so lines are
+// counted the same whichever a file was saved with
+type newlineReader struct {
+	r *bufio.Reader
+
+	read  int   // Bytes given so far
+	crlfs []int // Offsets of the \n given for each \r\n, in order
+}
+
+// Offset into the original input of an offset into what was read from nl
+func (nl *newlineReader) rawOffset(offset int) int {
+	// Each \r\n before offset is a byte shorter once read
+	return offset + sort.SearchInts(nl.crlfs, offset)
+}
+
+func (nl *newlineReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		char, err := nl.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				nl.read += n
+				return n, nil
+			}
+			return 0, err
+		}
+
+		if char == '\r' {
+			char = '\n'
+			if next, err := nl.r.Peek(1); err == nil && next[0] == '\n' {
+				nl.r.ReadByte()
+				nl.crlfs = append(nl.crlfs, nl.read+n)
+			}
+		}
+
+		p[n] = char
+		n += 1
+
+		// Don't wait on more input than is already at hand
+		if nl.r.Buffered() == 0 {
+			break
+		}
+	}
+
+	nl.read += n
+	return n, nil
+}
+
+// The text newlineReader gives for src
+func normalizeNewlines(src string) string {
+	return strings.Replace(strings.Replace(src, "\r\n", "\n", -1), "\r", "\n", -1)
+}
+
+// Source text read so far between two offsets, with its line endings as
+// they were written. Only the range is copied, not the whole source.
+func (lex *Lexer) text(start, end int) string {
+	return string(lex.src.Bytes()[lex.newlines.rawOffset(start):lex.newlines.rawOffset(end)])
+}
+
+// Token and error from lexing ahead of the parser
+type lexResult struct {
+	tok Token
+	err error
+}
+
+// Return the token NextToken would return without consuming it
 func (lex *Lexer) PeekToken() (Token, error) {
-	tok, err := lex.lexToken()
+	return lex.PeekTokenN(1)
+}
 
-	if err != nil {
-		return tok.Error(), err
+// Return the nth upcoming token without consuming it, where n = 1 is the
+// token NextToken would return. If lexing any token up to the nth fails,
+// that error is returned.
+func (lex *Lexer) PeekTokenN(n int) (Token, error) {
+	if n < 1 {
+		return Token{}, fmt.Errorf("can't peek at token %d, the next is 1", n)
 	}
 
-	lex.lookahead.PushBack(tok)
-	return tok, nil
+	for lex.lookahead.Len() < n {
+		tok, err := lex.lexToken()
+		lex.lookahead.PushBack(lexResult{tok, err})
+	}
+
+	elem := lex.lookahead.Front()
+	for i := 1; i < n; i++ {
+		if res := elem.Value.(lexResult); res.err != nil {
+			return res.tok, res.err
+		}
+
+		elem = elem.Next()
+	}
+
+	res := elem.Value.(lexResult)
+	return res.tok, res.err
 }
 
 func (lex *Lexer) NextToken() (Token, error) {
 	if lex.lookahead.Front() != nil {
 		node := lex.lookahead.Front()
-		tok := node.Value.(Token)
+		res := node.Value.(lexResult)
 
 		lex.lookahead.Remove(node)
 
-		return tok, nil
+		return res.tok, res.err
 	}
 
 	return lex.lexToken()
 }
 
+// Lex the rest of the input, up to and including the EOF token. Stops
+// at the first token which fails to lex.
+func (lex *Lexer) Tokenize() ([]Token, error) {
+	var tokens []Token
+
+	for {
+		tok, err := lex.NextToken()
+		if err != nil {
+			return tokens, err
+		}
+
+		tokens = append(tokens, tok)
+
+		if tok.kind == tkEof {
+			return tokens, nil
+		}
+	}
+}
+
 func (lex *Lexer) lexToken() (tok Token, err error) {
 	tok = Token{
 		start: lex.scanner.Pos(),
 	}
 
+	// Bad tokens end wherever the scanner gave up
+	defer func() {
+		if err != nil {
+			tok.end = lex.scanner.Pos()
+			lex.lastEnd = tok.end.Offset
+		}
+	}()
+
 	// Remove error handler
 	defer func() { lex.scanner.Error = nil }()
 
@@ -107,32 +263,21 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 
 	scan := lex.scanner.Scan()
 
+	tok.start = lex.scanner.Position
 	tok.value = lex.scanner.TokenText()
 
+	if lex.KeepTrivia {
+		tok.trivia = lex.text(lex.lastEnd, tok.start.Offset)
+	}
+
 	switch scan {
 	case scanner.EOF:
 		tok.kind = tkEof
 
-	case scanner.Int:
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		tok.kind = tkNumber
-		// TODO: this isn't all inclusive
-		if next := lex.scanner.Peek(); unicode.IsLetter(next) {
-			lex.scanner.Scan() // run until end of token
-
-			err = NewLexError(
-				lex.scanner.Pos(),
-				fmt.Sprintf("bad number: %s%s", tok.value,
-					lex.scanner.TokenText()))
 
-			return tok.Error(), err
-		}
-
-	case scanner.String:
-		tok.kind = tkString
-		// cut out leading/trailing "
-		tok.value = tok.value[1 : len(tok.value)-1]
-
-		if _, err := lex.checkEscapes(tok.value); err != nil {
+		if err := lex.lexNumber(&tok); err != nil {
 			return tok.Error(), err
 		}
 
@@ -150,7 +295,7 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 			r = lex.scanner.Peek()
 		}
 
-		if keywords[tok.value] {
+		if lex.Config.keywordTable()[tok.value] {
 			tok.kind = tkKeyword
 		} else {
 			tok.kind = tkIdent
@@ -186,54 +331,43 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 	case '?':
 		tok.kind = tkTernary
 
-	case '\'':
-		tok.kind = tkCharacter
-		tok.value = ""
+	case '"':
+		tok.kind = tkString
 
-	endstring:
-		for {
-			switch char := lex.scanner.Next(); char {
-			case '\n', scanner.EOF:
-				return tok.Error(), NewLexError(lex.scanner.Pos(),
-					fmt.Sprintf("unterminated character: %s",
-						tok.value))
-			case '\'':
-				break endstring
-			default:
-				tok.value += string(char)
-			}
+		if err := lex.lexQuoted(&tok, '"', "string"); err != nil {
+			return tok.Error(), err
 		}
 
-		numChars, err := lex.checkEscapes(tok.value)
-		if err != nil {
+	case '\'':
+		tok.kind = tkCharacter
+
+		if err := lex.lexQuoted(&tok, '\'', "character"); err != nil {
 			return tok.Error(), err
 		}
 
-		if numChars > 4 {
+		if len(tok.value) > lex.Config.CharsPerWord() {
 			return tok.Error(), NewLexError(lex.scanner.Pos(),
 				fmt.Sprintf("oversized character literal: %s",
-					tok.value))
+					tok.raw))
 		}
 
 	case '/':
 		if lex.scanner.Peek() == '*' {
 			lex.scanner.Next() // eat '*'
-		endcomment:
-			for {
-				switch char := lex.scanner.Next(); char {
-				case scanner.EOF:
-					return tok.Error(),
-						NewLexError(lex.scanner.Pos(),
-							"unterminated comment")
-				case '*':
-					if lex.scanner.Peek() == '/' {
-						lex.scanner.Next()
-						break endcomment
-					}
-				}
+
+			if err := lex.skipComment(tok.start); err != nil {
+				return tok.Error(), err
+			}
+
+			if lex.KeepComments {
+				text := lex.text(tok.start.Offset, lex.scanner.Pos().Offset)
+				tok.kind = tkComment
+				tok.value = text[2 : len(text)-2]
+				break
 			}
 
-			return lex.NextToken()
+			// Comment becomes part of the next token's trivia
+			return lex.lexToken()
 		} else {
 			tok.kind = tkOperator
 		}
@@ -247,12 +381,54 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 			tok.kind = tkOperator
 		}
 
-		// XXX: some other operators still unhandled
-	case '=', '>', '<', '!':
+	// An '=' immediately followed by an operator is a compound
+	// assignment, so `a =- b` is `a = a - b`. To assign a unary
+	// expression, separate the two: `a = -b`.
+	case '=':
 		tok.kind = tkOperator
-		if lex.scanner.Peek() == '=' {
+
+		switch lex.scanner.Peek() {
+		case '=', '+', '-', '*', '%', '&', '|':
+			tok.value += string(lex.scanner.Next())
+
+		case '/':
+			end := lex.scanner.Pos()
+			lex.scanner.Next()
+
+			// `=/*` is an assignment followed by a comment
+			if lex.scanner.Peek() == '*' {
+				lex.scanner.Next()
+				if err := lex.skipComment(end); err != nil {
+					return tok.Error(), err
+				}
+
+				tok.end = end
+				lex.lastEnd = end.Offset
+				return tok, nil
+			}
+
+			tok.value = "=/"
+
+		case '<', '>':
+			shift := lex.scanner.Next()
+			if lex.scanner.Next() != shift {
+				return tok.Error(), NewLexError(lex.scanner.Pos(),
+					fmt.Sprintf("unexpected operator: =%c", shift))
+			}
+
+			tok.value += string(shift) + string(shift)
+		}
+
+	case '>', '<':
+		tok.kind = tkOperator
+		if next := lex.scanner.Peek(); next == '=' || next == scan {
 			tok.value += string(lex.scanner.Next())
+		}
 
+	case '!':
+		tok.kind = tkOperator
+		if lex.scanner.Peek() == '=' {
+			tok.value += string(lex.scanner.Next())
 		}
 
 	case '+', '-':
@@ -265,7 +441,7 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 
 		}
 
-	case '%', '&', '~':
+	case '%', '&', '|', '^', '~':
 		tok.kind = tkOperator
 
 	default:
@@ -275,10 +451,97 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 	}
 
 	tok.end = lex.scanner.Pos()
+	lex.lastEnd = tok.end.Offset
 
 	return tok, nil
 }
 
+// Skip to the end of a comment whose opening "/*" has been read
+func (lex *Lexer) skipComment(start scanner.Position) error {
+	for {
+		switch char := lex.scanner.Next(); char {
+		case scanner.EOF:
+			return &LexError{pos: lex.scanner.Pos(), eof: true,
+				msg: fmt.Sprintf("unterminated comment starting on line %d",
+					start.Line)}
+		case '*':
+			if lex.scanner.Peek() == '/' {
+				lex.scanner.Next()
+				return nil
+			}
+		}
+	}
+}
+
+// Read the rest of a number whose first digit has been scanned. A leading
+// zero makes it octal, and with Config.HexLiterals a leading 0x or 0X
+// makes it hexadecimal.
+func (lex *Lexer) lexNumber(tok *Token) error {
+	for r := lex.scanner.Peek(); r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r); r = lex.scanner.Peek() {
+		tok.value += string(lex.scanner.Next())
+	}
+
+	digits, valid, kind := tok.value, "0123456789", ""
+
+	if lex.Config.HexLiterals && (strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")) {
+		digits, valid, kind = digits[2:], "0123456789abcdefABCDEF", "hexadecimal"
+
+		if digits == "" {
+			return NewLexError(lex.scanner.Pos(),
+				"hexadecimal literal has no digits")
+		}
+	} else if digits[0] == '0' {
+		valid, kind = "01234567", "octal"
+	}
+
+	for _, r := range digits {
+		if strings.ContainsRune(valid, r) {
+			continue
+		}
+
+		if kind == "" || (kind == "octal" && !unicode.IsDigit(r)) {
+			return NewLexError(lex.scanner.Pos(),
+				fmt.Sprintf("bad number: %s", tok.value))
+		}
+
+		return NewLexError(lex.scanner.Pos(),
+			fmt.Sprintf("invalid digit '%c' in %s literal", r, kind))
+	}
+
+	return nil
+}
+
+// Read the rest of a string or character literal up to the closing
+// quote, storing the source text in tok.raw and the decoded text in
+// tok.value
+func (lex *Lexer) lexQuoted(tok *Token, quote rune, what string) error {
+	tok.raw = ""
+
+	for {
+		switch char := lex.scanner.Next(); char {
+		case '\n', scanner.EOF:
+			return &LexError{pos: lex.scanner.Pos(), eof: char == scanner.EOF,
+				msg: fmt.Sprintf("unterminated %s: %s", what, tok.raw)}
+		case quote:
+			value, err := unescape(tok.raw, lex.Config.escapeTable())
+			if err != nil {
+				return NewLexError(lex.scanner.Pos(), err.Error())
+			}
+
+			tok.value = value
+			return nil
+		case '*':
+			// Escaped character can't end the literal
+			tok.raw += string(char)
+			if next := lex.scanner.Peek(); next != '\n' && next != scanner.EOF {
+				tok.raw += string(lex.scanner.Next())
+			}
+		default:
+			tok.raw += string(char)
+		}
+	}
+}
+
 // *0	null
 // *e	end-of-file
 // *(	{
@@ -288,30 +551,47 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 // *'	'
 // *"	"
 // *n	new line
-func (lex *Lexer) checkEscapes(str string) (int, error) {
-	escaped := ""
-
-	if str[len(str)-1] == '*' {
-		return -1, NewLexError(lex.scanner.Pos(), "invalid escape sequence")
-	}
+var escapes = map[byte]byte{
+	'0':  0,
+	'e':  4, // EOT
+	'(':  '{',
+	')':  '}',
+	't':  '\t',
+	'*':  '*',
+	'\'': '\'',
+	'"':  '"',
+	'n':  '\n',
+}
 
-	numChars := 0
+// Replace B escape sequences in str with the characters they stand for
+func unescape(str string, escapes map[byte]byte) (string, error) {
+	unescaped := make([]byte, 0, len(str))
 
 	for i := 0; i < len(str); i++ {
-		if str[i] == '*' {
-			switch str[i+1] {
-			case '0', 'e', '(', ')', 't', '*', '\'', '"', 'n':
-			default:
-				return -1, NewLexError(lex.scanner.Pos(), fmt.Sprintf("invalid escape: %c", str[i+1]))
-			}
+		if str[i] != '*' {
+			unescaped = append(unescaped, str[i])
+			continue
+		}
 
-			i += 1
-		} else {
-			escaped += string(str[i])
+		if i+1 >= len(str) {
+			return "", fmt.Errorf("unterminated escape sequence")
+		}
+
+		i += 1
+
+		char, ok := escapes[str[i]]
+		if !ok {
+			return "", fmt.Errorf("invalid escape: *%c", str[i])
 		}
 
-		numChars += 1
+		unescaped = append(unescaped, char)
 	}
 
-	return numChars, nil
+	return string(unescaped), nil
+}
+
+// Decode the escapes of a literal as written, such as StringNode.Value,
+// using the escapes of classic B
+func Unescape(str string) (string, error) {
+	return unescape(str, escapes)
 }
@@ -18,7 +18,7 @@ func TestLexSanity(t *testing.T) {
 	}
 
 	tok, err = lex.PeekToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "b" {
+	if err != nil || tok.kind != tkIdent || tok.value != "a" {
 		t.Errorf("Double PeekToken: %v", tok)
 	}
 
@@ -43,6 +43,164 @@ func TestLexSanity(t *testing.T) {
 	}
 }
 
+func TestLexColumn(t *testing.T) {
+	lex := NewLexer("", strings.NewReader("a   b\n\t\tc"))
+
+	expected := []struct{ line, column int }{{1, 1}, {1, 5}, {2, 3}}
+
+	for _, pos := range expected {
+		tok, err := lex.NextToken()
+		if err != nil || tok.start.Line != pos.line ||
+			tok.start.Column != pos.column {
+			t.Errorf("Column: expected %d:%d, got %v at %d:%d", pos.line,
+				pos.column, tok, tok.start.Line, tok.start.Column)
+		}
+	}
+}
+
+func TestLexReset(t *testing.T) {
+	lex := NewLexer("first", strings.NewReader("a\nb c"))
+	lex.KeepTrivia = true
+
+	lex.NextToken()
+	lex.NextToken()
+	if _, err := lex.PeekTokenN(2); err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+
+	lex.Reset("second", strings.NewReader("  x y"))
+
+	tok, err := lex.NextToken()
+	if err != nil || tok.kind != tkIdent || tok.value != "x" {
+		t.Errorf("Expected x from the new input, got %v, %v", tok, err)
+	}
+
+	if tok.start.Line != 1 || tok.start.Column != 3 || tok.trivia != "  " {
+		t.Errorf("Expected x at 1:3 after %q, got %d:%d after %q",
+			"  ", tok.start.Line, tok.start.Column, tok.trivia)
+	}
+
+	if lex.name != "second" || !lex.KeepTrivia {
+		t.Errorf("Expected the new name and the same options")
+	}
+}
+
+func TestLexLineEndings(t *testing.T) {
+	for _, src := range []string{"a\r\nb\r\n'c'", "a\rb\r'c'", "a\nb\n'c'"} {
+		tokens, err := NewLexer("", strings.NewReader(src)).Tokenize()
+		if err != nil || len(tokens) != 4 {
+			t.Errorf("%q: expected 4 tokens, got %v, %v", src, tokens, err)
+			continue
+		}
+
+		for i, value := range []string{"a", "b", "c"} {
+			tok := tokens[i]
+			if tok.value != value || tok.start.Line != i+1 || tok.start.Column != 1 {
+				t.Errorf("%q: expected %s at %d:1, got %q at %d:%d", src, value,
+					i+1, tok.value, tok.start.Line, tok.start.Column)
+			}
+		}
+	}
+}
+
+func TestLexKeepComments(t *testing.T) {
+	src := "/* Add\n * one */\nf(x) /**/ return (x + 1);"
+
+	lex := NewLexer("", strings.NewReader(src))
+	lex.KeepComments = true
+
+	tokens, err := lex.Tokenize()
+	if err != nil || len(tokens) < 3 {
+		t.Fatalf("Tokenize failed: %v, %v", tokens, err)
+	}
+
+	if tok := tokens[0]; tok.kind != tkComment || tok.value != " Add\n * one " ||
+		tok.start.Line != 1 || tok.end.Line != 2 {
+		t.Errorf("Expected the leading comment, got %v %q", tok.kind, tok.value)
+	}
+
+	if tok := tokens[1]; tok.kind != tkIdent || tok.value != "f" {
+		t.Errorf("Expected f after the comment, got %v", tok)
+	}
+
+	if tok := tokens[5]; tok.kind != tkComment || tok.value != "" {
+		t.Errorf("Expected an empty comment, got %v %q", tok.kind, tok.value)
+	}
+
+	// Skipped by default
+	tokens, err = NewLexer("", strings.NewReader(src)).Tokenize()
+	for _, tok := range tokens {
+		if tok.kind == tkComment {
+			t.Errorf("Comment kept by default: %q", tok.value)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	lex := NewLexer("", strings.NewReader(`main() { return 'a' + 1; }`))
+
+	tokens, err := lex.Tokenize()
+	if err != nil {
+		t.Fatalf("Tokenize: %v", err)
+	}
+
+	expected := []TokenType{tkIdent, tkOpenParen, tkCloseParen,
+		tkOpenBrace, tkKeyword, tkCharacter, tkOperator, tkNumber,
+		tkSemicolon, tkCloseBrace, tkEof}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("Tokenize: expected %d tokens, got %v", len(expected), tokens)
+	}
+
+	for i, tok := range tokens {
+		if tok.kind != expected[i] {
+			t.Errorf("Tokenize: expected %v, got %v", expected[i], tok)
+		}
+	}
+
+	lex = NewLexer("", strings.NewReader(`a ¿ b`))
+	if tokens, err := lex.Tokenize(); err == nil || len(tokens) != 1 {
+		t.Errorf("Tokenize error: %v, %v", tokens, err)
+	}
+}
+
+func TestPeekTokenN(t *testing.T) {
+	lex := NewLexer("", strings.NewReader(`a b c d ¿ e`))
+
+	if tok, err := lex.PeekTokenN(3); err != nil || tok.value != "c" {
+		t.Errorf("Peek 3: %v, %v", tok, err)
+	}
+
+	if tok, err := lex.PeekTokenN(1); err != nil || tok.value != "a" {
+		t.Errorf("Peek 1: %v, %v", tok, err)
+	}
+
+	for _, n := range []int{0, -1} {
+		if tok, err := lex.PeekTokenN(n); err == nil {
+			t.Errorf("Peek %d: %v", n, tok)
+		}
+	}
+
+	for _, value := range []string{"a", "b", "c", "d"} {
+		if tok, err := lex.NextToken(); err != nil || tok.value != value {
+			t.Errorf("Next after peek: expected %s, got %v, %v",
+				value, tok, err)
+		}
+	}
+
+	if tok, err := lex.PeekTokenN(2); err == nil {
+		t.Errorf("Peek past bad token: %v", tok)
+	}
+
+	if tok, err := lex.NextToken(); err == nil || tok.kind != tkError {
+		t.Errorf("Next bad token: %v", tok)
+	}
+
+	if tok, err := lex.NextToken(); err != nil || tok.value != "e" {
+		t.Errorf("Next after bad token: %v, %v", tok, err)
+	}
+}
+
 // Test lexing a few basic types
 func TestBasicLex(t *testing.T) {
 	in := strings.NewReader(`
@@ -99,12 +257,36 @@ other_ident.123__
 	}
 }
 
+func TestLexKeywords(t *testing.T) {
+	names := []string{"auto", "break", "case", "default", "else", "extrn",
+		"goto", "if", "return", "switch", "while"}
+
+	if len(Keywords) != len(names) {
+		t.Errorf("Expected %d keywords, got %v", len(names), Keywords)
+	}
+
+	lex := NewLexer("", strings.NewReader(strings.Join(names, " ")+" auto_ If"))
+
+	for _, name := range names {
+		if tok, err := lex.NextToken(); err != nil || tok.kind != tkKeyword || tok.value != name {
+			t.Errorf("Expected keyword %s, got %v, %v", name, tok, err)
+		}
+	}
+
+	for _, name := range []string{"auto_", "If"} {
+		if tok, err := lex.NextToken(); err != nil || tok.kind != tkIdent || tok.value != name {
+			t.Errorf("Expected identifier %s, got %v, %v", name, tok, err)
+		}
+	}
+}
+
 func TestEscapeSequences(t *testing.T) {
-	in := strings.NewReader(` '*(*)*t*n' '*bad' 'bad*(*('`)
+	in := strings.NewReader(` '*(*)*t*n' '*bad' 'abcdefg*(*('`)
 	lex := NewLexer("file", in)
 
 	tok, err := lex.NextToken()
-	if err != nil || tok.kind != tkCharacter || tok.value != "*(*)*t*n" {
+	if err != nil || tok.kind != tkCharacter || tok.raw != "*(*)*t*n" ||
+		tok.value != "{}\t\n" {
 		t.Errorf("escapes: %v %v", tok, err)
 	}
 
@@ -119,6 +301,37 @@ func TestEscapeSequences(t *testing.T) {
 	}
 }
 
+func TestDecodeEscapes(t *testing.T) {
+	in := strings.NewReader(`"*0*e*(*)*t***'*"*n" '*''
+"line *z"`)
+	lex := NewLexer("file", in)
+
+	tok, err := lex.NextToken()
+	if err != nil || tok.kind != tkString ||
+		tok.value != "\x00\x04{}\t*'\"\n" {
+		t.Errorf("all escapes: %q %v", tok.value, err)
+	}
+
+	if tok.raw != `*0*e*(*)*t***'*"*n` {
+		t.Errorf("all escapes raw: %q", tok.raw)
+	}
+
+	tok, err = lex.NextToken()
+	if err != nil || tok.kind != tkCharacter || tok.value != "'" {
+		t.Errorf("escaped quote: %v %v", tok, err)
+	}
+
+	tok, err = lex.NextToken()
+	if err == nil || tok.kind != tkError {
+		t.Fatalf("unknown escape: %v", tok)
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "line: 2") ||
+		!strings.Contains(msg, "*z") {
+		t.Errorf("unknown escape message: %s", msg)
+	}
+}
+
 // Test operator lexing
 func TestLexOp(t *testing.T) {
 	lex := NewLexer("", strings.NewReader(`> = >= + ++ ---`))
@@ -160,6 +373,32 @@ func TestLexOp(t *testing.T) {
 
 }
 
+func TestLexAssignOp(t *testing.T) {
+	lex := NewLexer("", strings.NewReader(
+		`=+ =- =* =/ =% =& =| =<< =>> == << >> a = -b a =- b a =/* c */ b`))
+
+	expected := []string{
+		"=+", "=-", "=*", "=/", "=%", "=&", "=|", "=<<", "=>>",
+		"==", "<<", ">>",
+		"a", "=", "-", "b",
+		"a", "=-", "b",
+		"a", "=", "b",
+	}
+
+	for _, value := range expected {
+		tok, err := lex.NextToken()
+		if err != nil || tok.value != value {
+			t.Errorf("Assign op: expected %s, got %v, %v", value, tok, err)
+		}
+	}
+
+	lex = NewLexer("", strings.NewReader(`a =< b`))
+	lex.NextToken()
+	if tok, err := lex.NextToken(); err == nil || tok.kind != tkError {
+		t.Errorf("Bad assign op: %v", tok)
+	}
+}
+
 func TestComment(t *testing.T) {
 	lex := NewLexer("",
 		strings.NewReader(`1 /* comment * /* (no nesting) */ 2`))
@@ -171,6 +410,27 @@ func TestComment(t *testing.T) {
 	if tok, err := lex.NextToken(); err != nil || tok.value != "2" {
 		t.Errorf("Comment (post): %v, %v", tok, err)
 	}
+
+	lex = NewLexer("", strings.NewReader("1 /* multi\nline\n*/ 2\n/* open\n"))
+
+	if tok, err := lex.NextToken(); err != nil || tok.start.Line != 1 {
+		t.Errorf("Multiline comment (pre): %v, %v", tok, err)
+	}
+
+	if tok, err := lex.NextToken(); err != nil || tok.value != "2" ||
+		tok.start.Line != 3 {
+		t.Errorf("Multiline comment (post): %v, line %d, %v", tok,
+			tok.start.Line, err)
+	}
+
+	tok, err := lex.NextToken()
+	if err == nil || tok.kind != tkError {
+		t.Fatalf("Unterminated comment: %v", tok)
+	}
+
+	if !strings.Contains(err.Error(), "starting on line 4") {
+		t.Errorf("Unterminated comment line: %v", err)
+	}
 }
 
 // Test some exceptional conditions
@@ -224,3 +484,34 @@ func TestExceptional(t *testing.T) {
 	}
 
 }
+
+func TestTrivia(t *testing.T) {
+	srcs := []string{
+		"  /* lead */ main( )\n{\tauto  x ;\n\n  x=  'c'; /* trail */ }  \n",
+		// Line endings are kept as written
+		"main( )\r\n{\r\n\tauto x; /* a\r\n b */\r\n\r\n  x = 1;\r}\r\n",
+	}
+
+	for _, src := range srcs {
+		lex := NewLexer("", strings.NewReader(src))
+		lex.KeepTrivia = true
+
+		out := ""
+		for {
+			tok, err := lex.NextToken()
+			if err != nil {
+				t.Fatalf("Trivia: %v, %v", tok, err)
+			}
+
+			out += tok.trivia + lex.text(tok.start.Offset, tok.end.Offset)
+
+			if tok.kind == tkEof {
+				break
+			}
+		}
+
+		if out != src {
+			t.Errorf("Trivia round trip: expected <%q>, got <%q>", src, out)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package parse
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"text/scanner"
+)
+
+// Wildcard for Match, standing in for any single node
+type AnyNode struct{ baseNode }
+
+func (a AnyNode) String() string { return "_" }
+
+// Whether two trees are the same, node for node. Source positions and
+// fields tagged `match:"-"`, such as the spelling of an IntegerNode, are
+// not compared.
+func Equal(a, b Node) bool {
+	return match(reflect.ValueOf(a), reflect.ValueOf(b), false)
+}
+
+// Where two trees first differ, as the path of fields leading there from
+// the root and the two values found, such as
+// `BinaryNode.Right.Oper: "*" != "+"`. Empty if the trees are Equal.
+func Diff(a, b Node) string {
+	path := "nil"
+	if a != nil {
+		path = reflect.TypeOf(a).Name()
+	}
+
+	return diff(reflect.ValueOf(a), reflect.ValueOf(b), path)
+}
+
+// Whether in has the shape of pattern. An AnyNode in the pattern matches
+// any subtree, and so does a Node field or slice left nil, so
+// FunctionCallNode{Callable: IdentNode{Value: "printf"}} matches every
+// call to printf whatever its arguments.
+func Match(pattern, in Node) bool {
+	return match(reflect.ValueOf(pattern), reflect.ValueOf(in), true)
+}
+
+// Every node in the unit matching pattern, outermost first, in source
+// order
+func FindAll(pattern Node, unit TranslationUnit) []Node {
+	var found []Node
+
+	visit := func(n Node) bool {
+		if Match(pattern, n) {
+			found = append(found, n)
+		}
+		return true
+	}
+
+	for _, v := range unit.Vars {
+		Walk(v, visit)
+	}
+
+	for _, fn := range unit.Funcs {
+		Walk(fn, visit)
+	}
+
+	return found
+}
+
+var (
+	anyNodeType  = reflect.TypeOf(AnyNode{})
+	positionType = reflect.TypeOf(scanner.Position{})
+)
+
+// Whether a field is left out of comparisons
+func ignored(field reflect.StructField) bool {
+	return field.Tag.Get("match") == "-"
+}
+
+func match(p, v reflect.Value, wild bool) bool {
+	if p.Kind() == reflect.Interface {
+		if p.IsNil() {
+			return wild || (v.Kind() == reflect.Interface && v.IsNil())
+		}
+		p = p.Elem()
+	}
+
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+
+	if !p.IsValid() || !v.IsValid() {
+		return p.IsValid() == v.IsValid()
+	}
+
+	if wild && p.Type() == anyNodeType {
+		return true
+	}
+
+	if p.Type() != v.Type() {
+		return false
+	}
+
+	switch p.Kind() {
+	case reflect.Struct:
+		if p.Type() == positionType {
+			return true
+		}
+
+		for i := 0; i < p.NumField(); i++ {
+			if ignored(p.Type().Field(i)) {
+				continue
+			}
+
+			if !match(p.Field(i), v.Field(i), wild) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Slice:
+		if p.IsNil() && wild {
+			return true
+		}
+
+		if p.Len() != v.Len() {
+			return false
+		}
+
+		for i := 0; i < p.Len(); i++ {
+			if !match(p.Index(i), v.Index(i), wild) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.String:
+		return p.String() == v.String()
+	case reflect.Bool:
+		return p.Bool() == v.Bool()
+	case reflect.Int, reflect.Int64:
+		return p.Int() == v.Int()
+	}
+
+	return false
+}
+
+func diff(a, b reflect.Value, path string) string {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+
+	differ := func() string {
+		return fmt.Sprintf("%s: %s != %s", path, describe(a), describe(b))
+	}
+
+	if !a.IsValid() || !b.IsValid() {
+		if a.IsValid() == b.IsValid() {
+			return ""
+		}
+		return differ()
+	}
+
+	if a.Type() != b.Type() {
+		return differ()
+	}
+
+	switch a.Kind() {
+	case reflect.Struct:
+		if a.Type() == positionType {
+			return ""
+		}
+
+		for i := 0; i < a.NumField(); i++ {
+			if ignored(a.Type().Field(i)) {
+				continue
+			}
+
+			field := path
+			if !a.Type().Field(i).Anonymous {
+				field += "." + a.Type().Field(i).Name
+			}
+
+			if d := diff(a.Field(i), b.Field(i), field); d != "" {
+				return d
+			}
+		}
+		return ""
+
+	case reflect.Slice:
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			if d := diff(a.Index(i), b.Index(i), fmt.Sprintf("%s[%d]", path, i)); d != "" {
+				return d
+			}
+		}
+
+		if a.Len() != b.Len() {
+			return fmt.Sprintf("%s: %d elements != %d", path, a.Len(), b.Len())
+		}
+		return ""
+	}
+
+	if a.Interface() != b.Interface() {
+		return differ()
+	}
+	return ""
+}
+
+// A value in a Diff, with its type if it's a node
+func describe(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	} else if n, ok := v.Interface().(Node); ok {
+		return fmt.Sprintf("%s %v", v.Type().Name(), n)
+	}
+
+	return fmt.Sprintf("%#v", v.Interface())
+}
+
+// Return a copy of n with every source position zeroed, for comparing or
+// serializing trees regardless of layout
+func StripPositions(n Node) Node {
+	return rewrite(n, func(n Node) Node {
+		copied := reflect.New(reflect.TypeOf(n)).Elem()
+		copied.Set(reflect.ValueOf(n))
+
+		if pos := copied.FieldByName("Pos"); pos.IsValid() && pos.Type() == positionType {
+			pos.Set(reflect.Zero(positionType))
+		}
+
+		return copied.Interface().(Node)
+	})
+}
+
+// Whether two functions are the same up to a consistent renaming of
+// their parameters and locals, so `f(a) return (a + 1);` is equivalent
+// to `g(b) return (b + 1);`. Globals, literals and the shape of the tree
+// must match exactly. Calls a function makes to itself are treated as
+// the same name.
+func AlphaEquivalent(a, b FunctionNode) bool {
+	if len(a.Params) != len(b.Params) {
+		return false
+	}
+
+	return Equal(canonicalNames(a), canonicalNames(b))
+}
+
+// Body of fn with its own name, parameters and locals replaced by
+// numbers in order of declaration, which can't clash with identifiers
+func canonicalNames(fn FunctionNode) Node {
+	names := map[string]string{fn.Name: "0"}
+	count := 1
+
+	bind := func(name string) {
+		names[name] = strconv.Itoa(count)
+		count += 1
+	}
+
+	for _, param := range fn.Params {
+		bind(param)
+	}
+
+	for _, local := range fn.Locals() {
+		if _, ok := names[local.Name]; !ok || local.Name == fn.Name {
+			bind(local.Name)
+		}
+	}
+
+	return rewrite(fn.Body, func(n Node) Node {
+		switch node := n.(type) {
+		case IdentNode:
+			if name, ok := names[node.Value]; ok {
+				node.Value = name
+			}
+			return node
+
+		case VarDeclNode:
+			vars := make([]VarDecl, len(node.Vars))
+			copy(vars, node.Vars)
+
+			for i := range vars {
+				vars[i].Name = names[vars[i].Name]
+			}
+
+			node.Vars = vars
+			return node
+		}
+
+		return n
+	})
+}
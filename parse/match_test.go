@@ -0,0 +1,228 @@
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFindAll(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+main() {
+	putchar('a');
+	putchar(x + 1);
+	if (x) putchar(putchar('b'));
+	printf("%d", x);
+	putc('c');
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	pattern := FunctionCallNode{
+		Callable: IdentNode{Value: "putchar"},
+		Args:     []Node{AnyNode{}},
+	}
+
+	found := FindAll(pattern, unit)
+
+	expected := []string{"putchar('a')", "putchar(x + 1)",
+		"putchar(putchar('b'))", "putchar('b')"}
+
+	if len(found) != len(expected) {
+		t.Fatalf("Expected %v, found %v", expected, found)
+	}
+
+	for i, node := range found {
+		if node.String() != expected[i] {
+			t.Errorf("Expected %s, found %v", expected[i], node)
+		}
+	}
+
+	// Nil arguments match any argument list
+	printf := FunctionCallNode{Callable: IdentNode{Value: "printf"}}
+	if found := FindAll(printf, unit); len(found) != 1 {
+		t.Errorf("Expected one printf call, found %v", found)
+	}
+
+	// No wildcard needs an exact match
+	exact := FunctionCallNode{
+		Callable: IdentNode{Value: "putchar"},
+		Args:     []Node{CharacterNode{Value: "a"}},
+	}
+	if found := FindAll(exact, unit); len(found) != 1 {
+		t.Errorf("Expected one exact match, found %v", found)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := BinaryNode{Left: IdentNode{Value: "a"}, Oper: "+", Right: IntegerNode{Value: 1}}
+
+	if !Equal(a, BinaryNode{Left: IdentNode{Value: "a"}, Oper: "+", Right: IntegerNode{Value: 1}}) {
+		t.Errorf("Equal trees differ")
+	}
+
+	if Equal(a, BinaryNode{Left: IdentNode{Value: "a"}, Oper: "+", Right: IntegerNode{Value: 2}}) {
+		t.Errorf("Different trees equal")
+	}
+
+	if Equal(BinaryNode{Left: AnyNode{}, Oper: "+", Right: IntegerNode{Value: 1}}, a) {
+		t.Errorf("Equal treats AnyNode as a wildcard")
+	}
+
+	if !Match(BinaryNode{Left: AnyNode{}, Oper: "+", Right: IntegerNode{Value: 1}}, a) {
+		t.Errorf("Wildcard didn't match")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	parse := func(src string) Node {
+		node, err := NewParser("", strings.NewReader(src)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return node
+	}
+
+	a := parse("if (x) { f(1); y = a + b * c; }")
+
+	var tests = []struct {
+		other, diff string
+	}{
+		{"if (x) {\n  f(1);\n  y = a + b * c;\n}", ""},
+		{"if (x) { f(1); y = a + b / c; }",
+			`IfNode.Body.Nodes[1].Expr.Rhs.Right.Oper: "*" != "/"`},
+		{"if (x) { f(1); y = a + 2 * c; }",
+			"IfNode.Body.Nodes[1].Expr.Rhs.Right.Left: IdentNode b != IntegerNode 2"},
+		{"if (x) { f(1, 2); y = a + b * c; }",
+			"IfNode.Body.Nodes[0].Expr.Args: 1 elements != 2"},
+	}
+
+	for _, test := range tests {
+		b := parse(test.other)
+
+		if d := Diff(a, b); d != test.diff {
+			t.Errorf("%s: expected diff %q, got %q", test.other, test.diff, d)
+		}
+
+		if (test.diff == "") != Equal(a, b) {
+			t.Errorf("%s: Diff and Equal disagree", test.other)
+		}
+	}
+
+	if d := Diff(IntegerNode{Value: 1}, IdentNode{Value: "x"}); d != "IntegerNode: IntegerNode 1 != IdentNode x" {
+		t.Errorf("Diff of different types: %q", d)
+	}
+
+	if d := Diff(nil, IntegerNode{Value: 1}); d != "nil: nil != IntegerNode 1" {
+		t.Errorf("Diff against nil: %q", d)
+	}
+}
+
+func TestStripPositions(t *testing.T) {
+	parse := func(src string) []byte {
+		unit, err := NewParser("", strings.NewReader(src)).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+
+		for i, fn := range unit.Funcs {
+			unit.Funcs[i] = StripPositions(fn).(FunctionNode)
+		}
+
+		data, err := json.Marshal(unit)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+
+		return data
+	}
+
+	compact := parse(`main(){top:if(x)goto top;switch(x){case 1:in:goto in;}}`)
+	spaced := parse(`
+main() {
+  top:
+    if (x)
+        goto top;
+
+    switch (x) {
+    case 1:
+      in: goto in;
+    }
+}`)
+
+	if !bytes.Equal(compact, spaced) {
+		t.Errorf("Stripped trees differ:\n%s\n%s", compact, spaced)
+	}
+
+	// The original tree keeps its positions
+	unit, _ := NewParser("", strings.NewReader("f() {\n lbl: ;\n}")).Parse()
+	StripPositions(unit.Funcs[0])
+
+	if label := unit.Funcs[0].Body.(BlockNode).Nodes[0].(LabelNode); label.Pos.Line != 2 {
+		t.Errorf("Original position lost: %v", label.Pos)
+	}
+}
+
+func TestAlphaEquivalent(t *testing.T) {
+	unit, err := NewParserWithConfig("", strings.NewReader(`
+sum(v, n) {
+  auto i, total;
+  total = 0;
+  for (i = 0; i < n; i++) total =+ v[i];
+  return (total);
+}
+
+add(vec, len) {
+  auto j, acc;
+  acc = 0;
+  for (j = 0; j < len; j++) acc =+ vec[j];
+  return (acc);
+}
+
+product(v, n) {
+  auto i, total;
+  total = 0;
+  for (i = 0; i < n; i++) total =* v[i];
+  return (total);
+}
+
+swapped(v, n) {
+  auto i, total;
+  total = 0;
+  for (i = 0; i < v; i++) total =+ n[i];
+  return (total);
+}
+
+fact(n) return (n < 2 ? 1 : n * fact(n - 1));
+fact2(m) return (m < 2 ? 1 : m * fact2(m - 1));
+calls(n) return (n < 2 ? 1 : n * fact(n - 1));
+`), ModernConfig).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	funcs := map[string]FunctionNode{}
+	for _, fn := range unit.Funcs {
+		funcs[fn.Name] = fn
+	}
+
+	var tests = []struct {
+		a, b     string
+		expected bool
+	}{
+		{"sum", "add", true},
+		{"sum", "product", false},
+		{"sum", "swapped", false},
+		{"fact", "fact2", true},
+		{"fact", "calls", false},
+	}
+
+	for _, test := range tests {
+		if AlphaEquivalent(funcs[test.a], funcs[test.b]) != test.expected {
+			t.Errorf("%s and %s: expected %v", test.a, test.b, test.expected)
+		}
+	}
+}
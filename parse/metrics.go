@@ -0,0 +1,76 @@
+package parse
+
+// Size and complexity measures of a single function
+type FunctionMetrics struct {
+	Name       string
+	Nodes      int // Nodes in the function, including itself
+	MaxDepth   int // Longest path from the function down to a leaf
+	Cyclomatic int // Decision points plus one
+	Blocks     int // Basic blocks, estimated from the statement structure
+	Edges      int // Control flow edges between those blocks
+	Calls      int
+}
+
+// Compute the metrics for fn in one pass over its tree. There is no
+// control flow graph, so blocks are counted from the statements which
+// start them: each branch of an if or ternary and the point they join,
+// the condition, body and exit of a loop, each case of a switch and its
+// exit, and every label. Edges are counted along with them: into each
+// branch and from each to the join, into a loop's condition, from it to
+// the body and exit and back from the body, from a switch to each case
+// and its default or exit and from each to the exit, into each label from
+// the statement before it, and from each goto to its label. Without
+// gotos this agrees with the cyclomatic complexity, E = M + N - 2.
+func Metrics(fn FunctionNode) FunctionMetrics {
+	metrics := FunctionMetrics{Name: fn.Name, Cyclomatic: 1, Blocks: 1}
+
+	var visit func(n Node, depth int)
+	visit = func(n Node, depth int) {
+		metrics.Nodes += 1
+		if depth > metrics.MaxDepth {
+			metrics.MaxDepth = depth
+		}
+
+		switch n := n.(type) {
+		case FunctionCallNode:
+			metrics.Calls += 1
+		case IfNode:
+			metrics.Cyclomatic += 1
+			metrics.Blocks += 2
+			metrics.Edges += 3
+			if n.HasElse {
+				metrics.Blocks += 1
+				metrics.Edges += 1
+			}
+		case TernaryNode:
+			metrics.Cyclomatic += 1
+			metrics.Blocks += 3
+			metrics.Edges += 4
+		case ForNode, WhileNode:
+			metrics.Cyclomatic += 1
+			metrics.Blocks += 3
+			metrics.Edges += 4
+		case SwitchNode:
+			metrics.Cyclomatic += len(n.Cases)
+			metrics.Blocks += len(n.Cases) + 1
+			metrics.Edges += 2*len(n.Cases) + 1
+			if n.DefaultCase != nil {
+				metrics.Blocks += 1
+				metrics.Edges += 1
+			}
+		case LabelNode:
+			metrics.Blocks += 1
+			metrics.Edges += 1
+		case GotoNode:
+			metrics.Edges += 1
+		}
+
+		for _, child := range children(n) {
+			visit(child, depth+1)
+		}
+	}
+
+	visit(fn, 1)
+
+	return metrics
+}
@@ -0,0 +1,64 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	node, err := NewParser("", strings.NewReader(`
+count(n) {
+	auto i;
+	i = 0;
+	while (i < n)
+		putchar(i++);
+	return (i);
+}`)).parseFuncDeclaration()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := FunctionMetrics{
+		Name: "count",
+		// count, block, auto, i = 0; (4), while, i < n (3),
+		// putchar(i++); (5), return (i); (3)
+		Nodes:      19,
+		MaxDepth:   7, // count, block, while, statement, call, i++, i
+		Cyclomatic: 2,
+		Blocks:     4, // entry, loop condition, loop body, loop exit
+		Edges:      4,
+		Calls:      1,
+	}
+
+	if metrics := Metrics((*node).(FunctionNode)); metrics != expected {
+		t.Errorf("Expected %+v, got %+v", expected, metrics)
+	}
+}
+
+func TestMetricsEdges(t *testing.T) {
+	var tests = []struct {
+		src           string
+		blocks, edges int
+	}{
+		// entry, then, else, join
+		{"f(x) { if (x) a(); else b(); }", 4, 4},
+		// entry, two cases, default, exit
+		{"f(x) { switch (x) { case 1: a(); case 2: b(); default: c(); } }", 5, 6},
+		// entry, label, with the goto jumping back to it
+		{"f() { top: a(); goto top; }", 2, 2},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseFuncDeclaration()
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", test.src, err)
+		}
+
+		metrics := Metrics((*node).(FunctionNode))
+		if metrics.Blocks != test.blocks || metrics.Edges != test.edges {
+			t.Errorf("%s: expected %d blocks and %d edges, got %d and %d",
+				test.src, test.blocks, test.edges, metrics.Blocks, metrics.Edges)
+		}
+	}
+}
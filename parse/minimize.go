@@ -0,0 +1,73 @@
+package parse
+
+import (
+	"bytes"
+)
+
+// Total number of nodes in the unit, including the top level declarations
+func NodeCount(unit TranslationUnit) int {
+	var count func(Node) int
+
+	count = func(n Node) int {
+		total := 1
+		for _, child := range children(n) {
+			total += count(child)
+		}
+		return total
+	}
+
+	total := 0
+
+	for _, v := range unit.Vars {
+		total += count(v)
+	}
+
+	for _, fn := range unit.Funcs {
+		total += count(fn)
+	}
+
+	return total
+}
+
+// Number of tokens in src, not counting EOF. Tokens which fail to lex
+// are counted as well.
+func TokenCount(name string, src []byte) int {
+	lex := NewLexer(name, bytes.NewReader(src))
+	count := 0
+
+	for {
+		if tok, _ := lex.NextToken(); tok.kind == tkEof {
+			return count
+		}
+
+		count += 1
+	}
+}
+
+// Shrink src a chunk of lines at a time for as long as fails still
+// holds, returning the smallest input found. This is a simplified form
+// of delta debugging, useful for reducing fuzzer inputs.
+func Minimize(src []byte, fails func([]byte) bool) []byte {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+
+	for chunk := len(lines) / 2; chunk > 0; chunk /= 2 {
+		for start := 0; start < len(lines); {
+			end := start + chunk
+			if end > len(lines) {
+				end = len(lines)
+			}
+
+			candidate := make([][]byte, 0, len(lines))
+			candidate = append(candidate, lines[:start]...)
+			candidate = append(candidate, lines[end:]...)
+
+			if fails(bytes.Join(candidate, nil)) {
+				lines = candidate
+			} else {
+				start = end
+			}
+		}
+	}
+
+	return bytes.Join(lines, nil)
+}
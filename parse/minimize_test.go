@@ -0,0 +1,66 @@
+package parse
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNodeCount(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+a 1;
+main() { return a + 2; }`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// a 1; => 2, main => 1, block => 1, return a + 2 => 4
+	if count := NodeCount(unit); count != 8 {
+		t.Errorf("Node count: expected 8, got %d", count)
+	}
+}
+
+func TestTokenCount(t *testing.T) {
+	if count := TokenCount("", []byte("main() { return 1; }")); count != 8 {
+		t.Errorf("Token count: expected 8, got %d", count)
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	src := []byte(`a 1;
+b 2;
+f() {
+  a = b;
+  return a;
+}
+g() {
+  auto x;
+  x = 1 +;
+  return x;
+}
+h() { return 3; }
+`)
+
+	// Only the original failure counts, not ones introduced by
+	// cutting the program apart
+	fails := func(src []byte) bool {
+		_, err := NewParser("", bytes.NewReader(src)).Parse()
+		return err != nil &&
+			strings.Contains(err.Error(), "expected primary expression")
+	}
+
+	min := Minimize(src, fails)
+
+	if !fails(min) {
+		t.Fatalf("Minimized input no longer fails: %q", min)
+	}
+
+	if TokenCount("", min) >= TokenCount("", src) {
+		t.Errorf("Input not reduced: %q", min)
+	}
+
+	if !bytes.Contains(min, []byte("1 +;")) {
+		t.Errorf("Lost the failing line: %q", min)
+	}
+}
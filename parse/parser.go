@@ -3,6 +3,7 @@ package parse
 import (
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -13,75 +14,300 @@ type ParseError struct {
 }
 
 func (p *ParseError) Error() string {
-	return fmt.Sprintf("Parse error on line %d, at token: %s: %s",
-		p.tok.start.Line, p.tok.String(), p.msg)
+	return fmt.Sprintf("Parse error at %d:%d, at token: %s: %s",
+		p.tok.start.Line, p.tok.start.Column, p.tok.String(), p.msg)
 }
 
 func NewParseError(tok Token, msg string) error {
 	return &ParseError{tok, msg}
 }
 
+// Whether the input ended partway through a construct, rather than
+// having a syntax error, so more input could complete it
+func (p *ParseError) UnexpectedEOF() bool {
+	return p.tok.kind == tkEof
+}
+
+// Whether err is a ParseError or LexError for input which ended too soon
+func IsUnexpectedEOF(err error) bool {
+	switch e := err.(type) {
+	case *ParseError:
+		return e.UnexpectedEOF()
+	case *LexError:
+		return e.UnexpectedEOF()
+	}
+
+	return false
+}
+
+// The error followed by the source line it occurred on, with a caret
+// under the offending token. src is the complete input given to the
+// parser.
+func (p *ParseError) PrettyError(src string) string {
+	// Offsets are into the source as lexed
+	src = normalizeNewlines(src)
+
+	offset := p.tok.start.Offset
+	if offset < 0 || offset > len(src) {
+		return p.Error()
+	}
+
+	start := strings.LastIndex(src[:offset], "\n") + 1
+	end := strings.Index(src[offset:], "\n")
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+
+	// Keep tabs so the caret lines up however they are displayed
+	caret := ""
+	for _, r := range src[start:offset] {
+		if r == '\t' {
+			caret += "\t"
+		} else {
+			caret += " "
+		}
+	}
+
+	return fmt.Sprintf("%s\n%s\n%s^", p.Error(), src[start:end], caret)
+}
+
 type Parser struct {
 	lex    *Lexer
 	tokens []Token
 	tokIdx int
 	nodes  []Node
+
+	// Errors recovered from in tolerant mode
+	errors []error
+
+	// Lex error on the first token, reported by the first production
+	// rather than from the constructor
+	startErr error
+
+	// Dialect to parse, ClassicConfig by default
+	Config
+
+	// Most values accepted in one vector initializer, guarding against
+	// runaway generated code
+	MaxVectorValues int
+
+	// Return an ErrorNode alongside the error when a production fails,
+	// rather than a nil node. Statements which fail to parse inside a
+	// block are skipped over and replaced with an ErrorNode, so the rest
+	// of the input is still parsed.
+	Tolerant bool
 }
 
+// Default for Parser.MaxVectorValues
+const DefaultMaxVectorValues = 1000000
+
 func NewParser(name string, input io.Reader) *Parser {
+	return NewParserWithConfig(name, input, ClassicConfig)
+}
+
+func NewParserWithConfig(name string, input io.Reader, config Config) *Parser {
 	parse := &Parser{
 		lex:    NewLexer(name, input),
 		nodes:  make([]Node, 0, 10),
 		tokens: make([]Token, 0, 10),
 		tokIdx: -1,
+		Config: config,
+
+		MaxVectorValues: DefaultMaxVectorValues,
 	}
 
-	if _, err := parse.nextToken(); err != nil {
-		panic(err)
+	parse.lex.Config = config
+
+	// The error token stands in for the first token, so the parser is
+	// usable until the error is returned
+	if tok, err := parse.nextToken(); err != nil {
+		parse.tokens = append(parse.tokens, tok)
+		parse.startErr = err
 	}
 
 	return parse
 }
 
-func (p *Parser) Parse() (unit TranslationUnit, err error) {
-	var node *Node = nil
-	unit = TranslationUnit{File: p.lex.name}
+func (p *Parser) Parse() (TranslationUnit, error) {
+	unit := TranslationUnit{File: p.lex.name, Config: p.Config}
+
+	for {
+		node, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if _, ok := err.(*LexError); ok {
+			return TranslationUnit{}, err
+		} else if err != nil {
+			return unit, err
+		}
+
+		if fn, ok := node.(FunctionNode); ok {
+			unit.Funcs = append(unit.Funcs, fn)
+		} else {
+			unit.Vars = append(unit.Vars, node)
+		}
+	}
+
+	// Report the first error recovered from, if any, alongside the
+	// partial tree
+	if len(p.errors) > 0 {
+		return unit, p.errors[0]
+	}
 
+	return unit, nil
+}
+
+// Parse the next top level declaration, a FunctionNode, ExternVarInitNode
+// or ExternVecInitNode, so a file can be processed a declaration at a
+// time. Returns io.EOF once the input is used up.
+func (p *Parser) Next() (node Node, err error) {
 	// Bail out of lex errors
-	// TODO: this is sort of convoluted logic, refactor
 	defer func() {
-		if e := recover(); e != nil {
-			// if it's a lex error, trap, return
-			if lexErr, ok := e.(*LexError); ok {
-				unit, err = TranslationUnit{}, lexErr
-			} else {
-				// rethrow
-				panic(e)
-			}
+		if lexErr := recoverLexError(recover()); lexErr != nil {
+			node, err = nil, lexErr
 		}
 	}()
 
+	if p.startErr != nil {
+		return nil, p.startErr
+	}
+
 	for {
 		if _, ok := p.acceptType(tkEof); ok {
+			return nil, io.EOF
+		}
+
+		// A stray semicolon after a function body, as in `f() { ... };`,
+		// is skipped. Inside a block it is a null statement.
+		if _, ok := p.acceptType(tkSemicolon); !ok {
 			break
 		}
+	}
 
-		if node, err = p.parseTopLevel(); err != nil {
-			return unit, err
+	parsed, err := p.parseTopLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	switch (*parsed).(type) {
+	case FunctionNode, ExternVarInitNode, ExternVecInitNode:
+		return *parsed, nil
+	}
+
+	return nil, NewParseError(p.token(), "That's not a top level decl")
+}
+
+// Parse a single expression which makes up the whole input.
+func (p *Parser) ParseExpression() (node Node, err error) {
+	return p.parseWhole(p.parseExpression)
+}
+
+// Parse a single statement which makes up the whole input.
+func (p *Parser) ParseStatement() (node Node, err error) {
+	return p.parseWhole(p.parseStatement)
+}
+
+// Run one production over the whole input, which must leave nothing
+// behind it
+func (p *Parser) parseWhole(production func() (*Node, error)) (node Node, err error) {
+	defer func() {
+		if lexErr := recoverLexError(recover()); lexErr != nil {
+			node, err = nil, lexErr
 		}
+	}()
+
+	if p.startErr != nil {
+		return nil, p.startErr
+	}
+
+	parsed, err := production()
+	if err != nil {
+		return nil, err
+	} else if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+
+	return *parsed, nil
+}
+
+// Lex errors are thrown as panics. Anything else is rethrown.
+func recoverLexError(e interface{}) *LexError {
+	if e == nil {
+		return nil
+	} else if lexErr, ok := e.(*LexError); ok {
+		return lexErr
+	}
+
+	panic(e)
+}
 
-		switch (*node).(type) {
-		case FunctionNode:
-			unit.Funcs = append(unit.Funcs, (*node).(FunctionNode))
-		case ExternVarInitNode, ExternVecInitNode:
-			unit.Vars = append(unit.Vars, *node)
-		default:
-			return unit, NewParseError(p.token(),
-				"That's not a top level decl")
+// Fail unless all of the input has been consumed.
+func (p *Parser) expectEOF() error {
+	if p.token().kind != tkEof {
+		return NewParseError(p.token(), "unexpected trailing token")
+	}
+
+	return nil
+}
+
+// Report a failed production. In tolerant mode the node is an ErrorNode
+// placeholder, otherwise nil.
+func (p *Parser) fail(err error) (*Node, error) {
+	if !p.Tolerant {
+		return nil, err
+	}
+
+	var node Node = ErrorNode{baseNode: at(p.token()), Msg: err.Error()}
+	return &node, err
+}
+
+// Skip over a statement starting at token index pos which failed to parse
+// with err. Tokens are skipped through the statement's semicolon or
+// closing brace, stopping short of a brace which closes the enclosing
+// block. Returns false if there is nothing to skip.
+func (p *Parser) skipStatement(pos int, err error) (*Node, bool) {
+	p.tokIdx = pos
+
+	for depth := 0; ; {
+		tok := p.token()
+
+		if tok.kind == tkEof || (tok.kind == tkCloseBrace && depth == 0) {
+			break
+		}
+
+		if _, err := p.nextToken(); err != nil {
+			panic(err)
+		}
+
+		if tok.kind == tkOpenBrace {
+			depth += 1
+		} else if tok.kind == tkCloseBrace {
+			depth -= 1
+		}
+
+		if depth == 0 && (tok.kind == tkSemicolon || tok.kind == tkCloseBrace) {
+			break
 		}
 	}
 
-	return unit, nil
+	if p.tokIdx == pos {
+		return nil, false
+	}
+
+	skipped := p.tokens[pos:p.tokIdx]
+	first, last := skipped[0], skipped[len(skipped)-1]
+
+	var node Node = ErrorNode{
+		baseNode: at(first),
+		Msg:      err.Error(),
+		Tokens:   append([]Token(nil), skipped...),
+		Text:     p.lex.text(first.start.Offset, last.end.Offset),
+	}
+
+	p.errors = append(p.errors, err)
+	return &node, true
 }
 
 func (p *Parser) accept(t TokenType, str string) (*Token, bool) {
@@ -166,91 +392,190 @@ func (p *Parser) nextToken() (Token, error) {
 }
 
 func (p *Parser) parseBlock() (*Node, error) {
-	if _, err := p.expectType(tkOpenBrace); err != nil {
-		return nil, err
+	open, err := p.expectType(tkOpenBrace)
+	if err != nil {
+		return p.fail(err)
 	}
 
-	block := BlockNode{}
+	block := BlockNode{baseNode: at(*open)}
 
 	for p.token().kind != tkCloseBrace {
+		if p.token().kind == tkEof {
+			return p.fail(NewParseError(p.token(), fmt.Sprintf(
+				"unterminated block, opened at %d:%d",
+				open.start.Line, open.start.Column)))
+		}
+
+		pos := p.tokIdx
+
 		stmt, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			if !p.Tolerant {
+				return p.fail(err)
+			}
+
+			var ok bool
+			if stmt, ok = p.skipStatement(pos, err); !ok {
+				return p.fail(err)
+			}
 		}
 
 		block.Nodes = append(block.Nodes, *stmt)
 	}
 
 	if _, err := p.expectType(tkCloseBrace); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	var node Node = block
 	return &node, nil
 }
 
-func (p *Parser) parseConstant() (*Node, error) {
+// Unsigned number, character or string literal
+func (p *Parser) parseLiteral() (*Node, error) {
 	var node Node
 
 	kind, tok, err := p.expectOneOf(tkNumber, tkCharacter, tkString)
 
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	switch kind {
 	case tkNumber:
-		num, err := strconv.Atoi(tok.value)
+		num, err := p.parseInteger(tok)
 		if err != nil {
-			return nil, NewParseError(p.token(), "invalid integer literal")
+			return p.fail(err)
 		}
 
-		node = IntegerNode{num}
+		node = IntegerNode{baseNode: at(tok), Value: num, Text: tok.value}
 		return &node, err
 	case tkCharacter:
-		node = CharacterNode{tok.value}
+		node = CharacterNode{baseNode: at(tok), Value: tok.raw}
 		return &node, err
 	case tkString:
-		node = StringNode{tok.value}
+		str := tok.raw
+
+		for p.ConcatStrings {
+			next, ok := p.acceptType(tkString)
+			if !ok {
+				break
+			}
+
+			str += next.raw
+		}
+
+		node = StringNode{baseNode: at(tok), Value: str}
 		return &node, err
 	default:
-		return nil, err
+		return p.fail(NewParseError(tok, "expected constant"))
+	}
+}
+
+// Constant in an initializer or case label, which may be signed as in
+// `x -1;` or `case -1:`. A signed character is given as an IntegerNode of
+// its packed value.
+func (p *Parser) parseConstant() (*Node, error) {
+	sign, signed := p.accept(tkOperator, "-")
+	if !signed {
+		sign, signed = p.accept(tkOperator, "+")
 	}
 
-	return nil, nil
+	constant, err := p.parseLiteral()
+	if err != nil || !signed {
+		return constant, err
+	}
+
+	var value int64
+
+	switch c := (*constant).(type) {
+	case IntegerNode:
+		value = c.Value
+	case CharacterNode:
+		char, err := c.IntWithConfig(p.Config)
+		if err != nil {
+			return p.fail(NewParseError(*sign, err.Error()))
+		}
+		value = int64(char)
+	default:
+		return p.fail(NewParseError(*sign,
+			fmt.Sprintf("cannot apply %s to %v", sign.value, c)))
+	}
+
+	if sign.value == "-" {
+		value = -value
+	}
+
+	var node Node = IntegerNode{baseNode: at(*sign), Value: value,
+		Text: sign.value + (*constant).String()}
+	return &node, nil
+}
+
+// Decode an integer literal, which is octal if it has a leading zero and
+// hexadecimal if it starts with 0x, which the lexer only lets through
+// with Config.HexLiterals
+func (p *Parser) parseInteger(tok Token) (int64, error) {
+	digits, base := tok.value, 10
+	if len(digits) > 2 && (digits[1] == 'x' || digits[1] == 'X') {
+		digits, base = digits[2:], 16
+	} else if len(digits) > 1 && digits[0] == '0' {
+		base = 8
+	}
+
+	// Literals are checked against the dialect's word size
+	bits := p.Bits()
+	num, err := strconv.ParseInt(digits, base, bits)
+
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		return 0, NewParseError(tok,
+			fmt.Sprintf("integer literal %s is larger than the maximum %d",
+				tok.value, int64(math.MaxInt64>>uint(64-bits))))
+	} else if err != nil {
+		return 0, NewParseError(tok, "invalid integer literal")
+	}
+
+	return num, nil
 }
 
 func (p *Parser) parseSubExpression() (*Node, error) {
-	unNode := UnaryNode{Oper: ""}
+	// Unary prefix operator, whose operand is another subexpression, so
+	// they nest as in `!*p` and `**p`, and bind looser than postfix ones
+	if op, ok := p.acceptType(tkOperator); ok {
+		start := p.tokIdx
+
+		operand, err := p.parseSubExpression()
+		if err != nil {
+			// Nothing usable follows the operator, so blame it
+			// rather than whatever token comes next
+			if p.tokIdx == start {
+				return p.fail(NewParseError(*op,
+					fmt.Sprintf("expected operand after '%s'", op.value)))
+			}
+			return p.fail(err)
+		}
 
-	// Unary prefix operator
-	if tok, ok := p.acceptType(tkOperator); ok {
 		// *, &, -, !, ++, --, and ~.
-		switch tok.value {
+		switch op.value {
 		case "*", "&", "-", "!", "++", "--", "~":
-			unNode = UnaryNode{Oper: tok.value, Postfix: false}
-		default:
-			return nil, NewParseError(p.token(), "invalid unary op")
+			var node Node = UnaryNode{baseNode: at(*op), Oper: op.value,
+				Node: *operand, Postfix: false}
+			return &node, nil
 		}
+
+		return p.fail(NewParseError(*op,
+			fmt.Sprintf("invalid unary op '%s'", op.value)))
 	}
 
 	expr, err := p.parsePrimary()
 	if err != nil {
-		return nil, err
-	}
-
-	// TODO: this logic is ugly.
-	if unNode.Oper != "" {
-		unNode.Node = *expr
-		*expr = unNode
+		return p.fail(err)
 	}
 
 	if p.token().kind == tkOperator {
 		switch p.token().value {
 		case "++", "--": // Unary postfix operator
-			unNode = UnaryNode{Oper: p.token().value,
-				Node: *expr, Postfix: true}
-			*expr = unNode
+			*expr = UnaryNode{baseNode: startOf(*expr),
+				Oper: p.token().value, Node: *expr, Postfix: true}
 
 			p.nextToken()
 		}
@@ -260,90 +585,135 @@ func (p *Parser) parseSubExpression() (*Node, error) {
 }
 
 func (p *Parser) parseExpression() (*Node, error) {
-	node, err := p.parseSubExpression()
+	return p.parseBinary(0)
+}
+
+// Parse an expression where a comma can only be the comma operator, such
+// as inside parentheses. Without Config.CommaExpressions this is just an
+// expression, leaving any comma to the caller.
+func (p *Parser) parseCommaExpression() (*Node, error) {
+	node, err := p.parseExpression()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
+	} else if !p.CommaExpressions || p.token().kind != tkComma {
+		return node, nil
 	}
 
-	if tok, ok := p.acceptType(tkOperator); ok {
-		rhs, err := p.parseExpression()
+	comma := CommaNode{baseNode: startOf(*node), Exprs: []Node{*node}}
+
+	for {
+		if _, ok := p.acceptType(tkComma); !ok {
+			break
+		}
+
+		expr, err := p.parseExpression()
 		if err != nil {
-			return nil, err
+			return p.fail(err)
 		}
+		comma.Exprs = append(comma.Exprs, *expr)
+	}
 
-		var bin BinaryNode
+	*node = comma
+	return node, nil
+}
 
-		// Resolve precedence for multiple operators in expression
-		// TODO: currently ignores LTR, RTL binding
-		if rbin, ok := (*rhs).(BinaryNode); ok {
-			lproc, _ := OperatorPrecedence(tok.value)
-			rproc, _ := OperatorPrecedence(rbin.Oper)
+// Parse a run of operands joined by operators which bind at least as
+// tightly as minPrec, by precedence climbing. The right operand of a left
+// binding operator only takes tighter operators, so `a - b - c` is
+// `(a - b) - c`, while a right binding operator's takes its own level
+// too, so `a = b = c` is `a = (b = c)`.
+func (p *Parser) parseBinary(minPrec int) (*Node, error) {
+	node, err := p.parseSubExpression()
+	if err != nil {
+		return p.fail(err)
+	}
 
-			if lproc > rproc {
-				left := BinaryNode{Left: *node, Oper: tok.value,
-					Right: rbin.Left}
-				bin = BinaryNode{Left: left, Oper: rbin.Oper,
-					Right: rbin.Right}
-			} else {
-				bin = BinaryNode{Left: *node, Oper: tok.value,
-					Right: rbin}
-			}
+	for {
+		tok := p.token()
+		if tok.kind != tkOperator && tok.kind != tkTernary {
+			break
+		}
 
-		} else {
-			bin = BinaryNode{Left: *node,
-				Oper: tok.value, Right: *rhs}
+		prec, ok := OperatorPrecedence(tok.value)
+		if !ok || prec < minPrec {
+			break
 		}
 
-		*node = bin
-	}
+		p.acceptType(tok.kind)
 
-	// Ternary operator
-	if _, ok := p.acceptType(tkTernary); ok {
-		ter := TernaryNode{Cond: *node}
+		assign := isAssignment(tok.value)
+		if assign && !isLvalue(*node) {
+			return p.fail(NewParseError(tok, fmt.Sprintf(
+				"cannot assign to %v", *node)))
+		}
 
-		if body, err := p.parseExpression(); err != nil {
-			return nil, err
-		} else {
-			ter.TrueBody = *body
+		if OperatorAssoc(tok.value) == LeftAssoc {
+			prec += 1
 		}
 
-		if _, err := p.expectType(tkColon); err != nil {
-			return nil, err
+		// Ternary operator
+		if tok.kind == tkTernary {
+			ter := TernaryNode{baseNode: startOf(*node), Cond: *node}
+
+			if colon := p.token(); p.AllowElvis && colon.kind == tkColon {
+				ter.TrueBody = NullNode{baseNode: at(colon)}
+			} else if body, err := p.parseExpression(); err != nil {
+				return p.fail(err)
+			} else {
+				ter.TrueBody = *body
+			}
+
+			if _, err := p.expectType(tkColon); err != nil {
+				return p.fail(err)
+			}
+
+			if body, err := p.parseBinary(prec); err != nil {
+				return p.fail(err)
+			} else {
+				ter.FalseBody = *body
+			}
+
+			*node = ter
+			continue
 		}
 
-		if body, err := p.parseExpression(); err != nil {
-			return nil, err
-		} else {
-			ter.FalseBody = *body
+		rhs, err := p.parseBinary(prec)
+		if err != nil {
+			return p.fail(err)
 		}
 
-		*node = ter
+		if assign {
+			*node = AssignNode{baseNode: startOf(*node), Lhs: *node,
+				Op: tok.value, Rhs: *rhs}
+		} else {
+			*node = BinaryNode{baseNode: startOf(*node), Left: *node,
+				Oper: tok.value, Right: *rhs}
+		}
 	}
 
 	return node, nil
 }
 
 func (p *Parser) parseExternVarDecl() (*Node, error) {
-	var err error
-
-	if _, err = p.expect(tkKeyword, "extrn"); err != nil {
-		return nil, err
+	kw, err := p.expect(tkKeyword, "extrn")
+	if err != nil {
+		return p.fail(err)
 	}
 
-	varNode := ExternVarDeclNode{}
+	varNode := ExternVarDeclNode{baseNode: at(*kw)}
 
-	if varNode.names, err = p.parseVariableList(); err != nil {
-		return nil, err
+	if varNode.Names, err = p.parseVariableList(); err != nil {
+		return p.fail(err)
 	}
 
 	if _, err = p.expectType(tkSemicolon); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
-	if len(varNode.names) <= 0 {
-		return nil, NewParseError(p.token(),
+	if len(varNode.Names) <= 0 {
+		return p.fail(NewParseError(p.token(),
 			"expected at least 1 variable in extrn"+
-				" declaration")
+				" declaration"))
 	}
 
 	var node Node = varNode
@@ -356,32 +726,22 @@ func (p *Parser) parseExternalVariableInit() (*Node, error) {
 	ident, err := p.expectType(tkIdent)
 
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if _, ok := p.acceptType(tkOpenBracket); ok {
-		init := ExternVecInitNode{Name: ident.value}
-
-		size, err := p.expectType(tkNumber)
-		if err != nil {
-			return nil, err
-		}
-		if _, err := p.expectType(tkCloseBracket); err != nil {
-			return nil, err
-		}
-
-		// TODO: Assert declared size == actual size
+		init := ExternVecInitNode{baseNode: at(*ident), Name: ident.value}
 
-		init.Size, err = strconv.Atoi(size.value)
+		// Size may be left out, and is then the number of values
+		size, sized := p.acceptType(tkNumber)
 
-		if err != nil {
-			return nil, NewParseError(p.token(),
-				"Bad integer literal")
+		if _, err := p.expectType(tkCloseBracket); err != nil {
+			return p.fail(err)
 		}
 
 		for {
 			if constant, err := p.parseConstant(); err != nil {
-				return nil, err
+				return p.fail(err)
 			} else {
 				init.Values = append(init.Values, *constant)
 			}
@@ -389,40 +749,98 @@ func (p *Parser) parseExternalVariableInit() (*Node, error) {
 			if _, ok := p.acceptType(tkComma); !ok {
 				break
 			}
+
+			if err := p.checkVectorValues(len(init.Values) + 1); err != nil {
+				return p.fail(err)
+			}
+		}
+
+		if sized {
+			num, err := p.parseInteger(*size)
+			if err != nil {
+				return p.fail(err)
+			}
+
+			// The size is the highest index, so `v[2]` holds three
+			// values. Missing values are zero filled.
+			if int64(len(init.Values)) > num+1 {
+				return p.fail(NewParseError(*size, fmt.Sprintf(
+					"%d values given for vector of size %d",
+					len(init.Values), num)))
+			}
+
+			init.Size = int(num)
+		} else {
+			init.Size = len(init.Values) - 1
 		}
 
 		var node Node = init
 		if _, err = p.expectType(tkSemicolon); err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 		return &node, nil
 	} else {
-		init := ExternVarInitNode{Name: ident.value}
+		init := ExternVarInitNode{baseNode: at(*ident), Name: ident.value}
 
-		constant, err := p.parseConstant()
-		if err != nil {
-			if _, err = p.expectType(tkSemicolon); err == nil {
-				// Empty declarations are zero filled
-				init.Value = IntegerNode{0}
-				var node Node = init
-				return &node, nil
-			}
-		} else {
-			init.Value = *constant
+		if _, ok := p.acceptType(tkSemicolon); ok {
+			// Empty declarations are zero filled
+			init.Value = IntegerNode{Value: 0}
+			var node Node = init
+			return &node, nil
 		}
 
+		constant, err := p.parseConstant()
 		if err != nil {
-			return nil, err
+			return p.fail(err)
 		}
+		init.Value = *constant
 
 		var node Node = init
 		if _, err = p.expectType(tkSemicolon); err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 		return &node, nil
 	}
+}
+
+func (p *Parser) parseFor() (*Node, error) {
+	kw, err := p.expect(tkKeyword, "for")
+	if err != nil {
+		return p.fail(err)
+	}
+
+	if _, err := p.expectType(tkOpenParen); err != nil {
+		return p.fail(err)
+	}
+
+	var clauses [3]Node
+
+	for i, end := range []TokenType{tkSemicolon, tkSemicolon, tkCloseParen} {
+		if tok, ok := p.acceptType(end); ok {
+			clauses[i] = NullNode{baseNode: at(*tok)}
+			continue
+		}
+
+		expr, err := p.parseCommaExpression()
+		if err != nil {
+			return p.fail(err)
+		}
+
+		if _, err := p.expectType(end); err != nil {
+			return p.fail(err)
+		}
+
+		clauses[i] = *expr
+	}
 
-	return nil, nil
+	body, err := p.parseStatement()
+	if err != nil {
+		return p.fail(err)
+	}
+
+	var node Node = ForNode{baseNode: at(*kw), Init: clauses[0],
+		Cond: clauses[1], Step: clauses[2], Body: *body}
+	return &node, nil
 }
 
 func (p *Parser) parseFuncDeclaration() (*Node, error) {
@@ -431,27 +849,27 @@ func (p *Parser) parseFuncDeclaration() (*Node, error) {
 	id, err := p.expectType(tkIdent)
 
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
-	fnNode := FunctionNode{Name: id.value}
+	fnNode := FunctionNode{baseNode: at(*id), Name: id.value}
 
 	if _, err = p.expectType(tkOpenParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if fnNode.Params, err = p.parseVariableList(); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if _, err = p.expectType(tkCloseParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	var stmt *Node
 
-	if stmt, err = p.parseStatement(); stmt == nil || err != nil {
-		return nil, err
+	if stmt, err = p.parseStatement(); err != nil {
+		return p.fail(err)
 	}
 
 	fnNode.Body = *stmt
@@ -464,34 +882,35 @@ func (p *Parser) parseIdent() (*Node, error) {
 	tok, err := p.expectType(tkIdent)
 
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
-	var node Node = IdentNode{tok.value}
+	var node Node = IdentNode{baseNode: at(*tok), Value: tok.value}
 	return &node, nil
 }
 
 func (p *Parser) parseIf() (*Node, error) {
-	if _, err := p.expect(tkKeyword, "if"); err != nil {
-		return nil, err
+	kw, err := p.expect(tkKeyword, "if")
+	if err != nil {
+		return p.fail(err)
 	}
 
 	if _, err := p.expectType(tkOpenParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	cond, err := p.parseExpression()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if _, err := p.expectType(tkCloseParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	trueBody, err := p.parseStatement()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	var elseBody Node
@@ -501,155 +920,181 @@ func (p *Parser) parseIf() (*Node, error) {
 		hasElse = true
 		els, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 
 		elseBody = *els
 	}
 
-	var node Node = IfNode{Cond: *cond, Body: *trueBody, HasElse: hasElse,
-		ElseBody: elseBody}
+	var node Node = IfNode{baseNode: at(*kw), Cond: *cond, Body: *trueBody,
+		HasElse: hasElse, ElseBody: elseBody}
 	return &node, nil
 
 }
 
 func (p *Parser) parseParen() (*Node, error) {
-	if _, err := p.expectType(tkOpenParen); err != nil {
-		return nil, err
+	open, err := p.expectType(tkOpenParen)
+	if err != nil {
+		return p.fail(err)
 	}
 
-	inner, err := p.parseExpression()
+	inner, err := p.parseCommaExpression()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if _, err := p.expectType(tkCloseParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
-	var node Node = ParenNode{*inner}
+	var node Node = ParenNode{baseNode: at(*open), Node: *inner}
 	return &node, nil
 }
 
-// TODO: unfinished, untested
-func (p *Parser) parsePrimary() (node *Node, err error) {
-	if node, err = p.parseParen(); err == nil {
-	} else if node, err = p.parseConstant(); err == nil {
-	} else if node, err = p.parseIdent(); err == nil {
-	} else {
-		return nil, NewParseError(p.token(), "expected primary expression")
-	}
-
-	// Array access
-	if _, ok := p.acceptType(tkOpenBracket); ok {
-		array := *node
-		index, err := p.parseExpression()
+// Parse a parenthesized expression, constant or identifier, chosen by the
+// current token, followed by any subscripts and calls
+func (p *Parser) parsePrimary() (*Node, error) {
+	var node *Node
+	var err error
 
-		if err != nil {
-			return nil, err
-		}
-		if _, err := p.expectType(tkCloseBracket); err != nil {
-			return nil, err
-		}
+	switch tok := p.token(); tok.kind {
+	case tkOpenParen:
+		node, err = p.parseParen()
+	case tkNumber, tkCharacter, tkString:
+		node, err = p.parseLiteral()
+	case tkIdent:
+		node, err = p.parseIdent()
+	default:
+		return p.fail(NewParseError(tok, "expected primary expression"))
+	}
 
-		*node = ArrayAccessNode{Array: array, Index: *index}
-		return node, nil
+	if err != nil {
+		return p.fail(err)
 	}
 
-	// Function call
-	if _, ok := p.acceptType(tkOpenParen); ok {
-		args := make([]Node, 0, 10)
+	// Any number of subscripts and calls, as in `grid[r][c]` or `f()()`
+	for {
+		if _, ok := p.acceptType(tkOpenBracket); ok {
+			index, err := p.parseExpression()
+			if err != nil {
+				return p.fail(err)
+			}
 
-		if p.token().kind != tkCloseParen {
-			for {
-				arg, err := p.parseExpression()
+			if _, err := p.expectType(tkCloseBracket); err != nil {
+				return p.fail(err)
+			}
 
-				if err != nil {
-					return nil, err
+			*node = ArrayAccessNode{baseNode: startOf(*node), Array: *node,
+				Index: *index}
+		} else if _, ok := p.acceptType(tkOpenParen); ok {
+			args := make([]Node, 0, 10)
+
+			if p.token().kind != tkCloseParen {
+				for {
+					arg, err := p.parseExpression()
+					if err != nil {
+						return p.fail(err)
+					}
+					args = append(args, *arg)
+
+					if _, ok := p.acceptType(tkComma); !ok {
+						break
+					}
 				}
-				args = append(args, *arg)
+			}
 
-				if _, ok := p.acceptType(tkComma); !ok {
-					break
-				}
+			if _, err := p.expectType(tkCloseParen); err != nil {
+				return p.fail(err)
 			}
-		}
 
-		if _, err := p.expectType(tkCloseParen); err != nil {
-			return nil, err
+			*node = FunctionCallNode{baseNode: startOf(*node), Callable: *node,
+				Args: args}
+		} else {
+			return node, nil
 		}
-		*node = FunctionCallNode{Callable: *node, Args: args}
-		return node, nil
 	}
-
-	return node, nil
 }
 
 func (p *Parser) parseStatement() (node *Node, err error) {
 	pos := p.tokIdx
 
 	if node, err := p.parseIf(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
 	if node, err := p.parseBlock(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
 	if node, err := p.parseVarDecl(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
 	if node, err := p.parseExternVarDecl(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
+	} else if err == nil {
+		return node, nil
+	}
+
+	if node, err := p.parseFor(); err != nil && p.tokIdx != pos {
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
 	if node, err := p.parseWhile(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
 	if node, err := p.parseSwitch(); err != nil && p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	} else if err == nil {
 		return node, nil
 	}
 
-	if _, ok := p.acceptType(tkSemicolon); ok {
-		var null Node = NullNode{}
+	if tok, ok := p.acceptType(tkSemicolon); ok {
+		var null Node = NullNode{baseNode: at(*tok)}
 		return &null, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "break"); ok {
+	if kw, ok := p.accept(tkKeyword, "break"); ok {
 		if _, err := p.expectType(tkSemicolon); err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 
-		var brk Node = BreakNode{}
+		var brk Node = BreakNode{baseNode: at(*kw)}
 		return &brk, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "return"); ok {
-		var retNode ReturnNode
-		if _, ok := p.acceptType(tkSemicolon); ok {
-			retNode.Node = NullNode{}
+	if kw, ok := p.accept(tkKeyword, "continue"); ok {
+		if _, err := p.expectType(tkSemicolon); err != nil {
+			return p.fail(err)
+		}
+
+		var cont Node = ContinueNode{baseNode: at(*kw)}
+		return &cont, nil
+	}
+
+	if kw, ok := p.accept(tkKeyword, "return"); ok {
+		retNode := ReturnNode{baseNode: at(*kw)}
+		if tok, ok := p.acceptType(tkSemicolon); ok {
+			retNode.Node = NullNode{baseNode: at(*tok)}
 		} else {
 			node, err := p.parseExpression()
 			if err != nil {
-				return nil, err
+				return p.fail(err)
 			}
 
 			if _, err := p.expectType(tkSemicolon); err != nil {
-				return nil, err
+				return p.fail(err)
 			}
 			retNode.Node = *node
 		}
@@ -658,17 +1103,16 @@ func (p *Parser) parseStatement() (node *Node, err error) {
 		return &node, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "goto"); ok {
-		var tok *Token = nil
-
-		if tok, err = p.expectType(tkIdent); err != nil {
-			return nil, err
+	if kw, ok := p.accept(tkKeyword, "goto"); ok {
+		label, err := p.parseExpression()
+		if err != nil {
+			return p.fail(err)
 		}
 
-		var gt Node = GotoNode{Label: tok.value}
+		var gt Node = GotoNode{baseNode: at(*kw), Label: *label}
 
 		if _, err := p.expectType(tkSemicolon); err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 
 		return &gt, nil
@@ -676,10 +1120,11 @@ func (p *Parser) parseStatement() (node *Node, err error) {
 
 	if tok, ok := p.acceptType(tkIdent); ok {
 		if _, ok := p.acceptType(tkColon); ok {
-			var node Node = LabelNode{tok.value}
+			var node Node = LabelNode{baseNode: at(*tok), Name: tok.value}
 			return &node, nil
 		} else if _, ok := p.acceptType(tkSemicolon); ok {
-			var node Node = StatementNode{IdentNode{tok.value}}
+			ident := IdentNode{baseNode: at(*tok), Value: tok.value}
+			var node Node = StatementNode{baseNode: ident.baseNode, Expr: ident}
 			return &node, nil
 		}
 
@@ -687,45 +1132,46 @@ func (p *Parser) parseStatement() (node *Node, err error) {
 		p.tokIdx = pos
 	}
 
-	if node, err := p.parseExpression(); err != nil && p.tokIdx != pos {
-		return nil, err
+	if node, err := p.parseCommaExpression(); err != nil && p.tokIdx != pos {
+		return p.fail(err)
 	} else if err == nil {
 		if _, err := p.expectType(tkSemicolon); err != nil {
-			return nil, err
+			return p.fail(err)
 		}
-		*node = StatementNode{Expr: *node}
+		*node = StatementNode{baseNode: startOf(*node), Expr: *node}
 		return node, nil
 	}
 
-	return nil, NewParseError(p.tokenAt(pos), "expected statement")
+	return p.fail(NewParseError(p.tokenAt(pos), "expected statement"))
 }
 
 // TODO: this logic is all over the place. refactor.
 func (p *Parser) parseSwitch() (*Node, error) {
-	var switchNode SwitchNode
-
-	if _, err := p.expect(tkKeyword, "switch"); err != nil {
-		return nil, err
+	kw, err := p.expect(tkKeyword, "switch")
+	if err != nil {
+		return p.fail(err)
 	}
 
+	switchNode := SwitchNode{baseNode: at(*kw)}
+
 	if _, err := p.expectType(tkOpenParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if cond, err := p.parseExpression(); err != nil {
-		return nil, err
+		return p.fail(err)
 	} else {
 		switchNode.Cond = *cond
 	}
 
 	if _, err := p.expectType(tkCloseParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	// I know, it can technically be any statement, but I'll leave it
 	// as a block for now.
 	if _, err := p.expectType(tkOpenBrace); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	for {
@@ -733,17 +1179,34 @@ func (p *Parser) parseSwitch() (*Node, error) {
 			break
 		}
 
-		if _, ok := p.accept(tkKeyword, "case"); ok {
-			var c CaseNode
+		if kw, ok := p.accept(tkKeyword, "case"); ok {
+			c := CaseNode{baseNode: at(*kw)}
+
+			// `case x = 1:` is almost certainly a mistyped `==`, so
+			// give a clearer error than a missing colon or constant
+			pos := p.tokIdx
+			if label, err := p.parseExpression(); err == nil {
+				if assign, ok := (*label).(AssignNode); ok {
+					return p.fail(NewParseError(p.tokenAt(pos), fmt.Sprintf(
+						"assignment '%s' in case label, did you mean '=='?",
+						assign.Op)))
+				}
+			}
+			p.tokIdx = pos
 
-			if cond, err := p.parseConstant(); err != nil {
-				return nil, err
+			parseLabel := p.parseConstant
+			if p.CaseGuards {
+				parseLabel = p.parseExpression
+			}
+
+			if cond, err := parseLabel(); err != nil {
+				return p.fail(err)
 			} else {
-				c = CaseNode{Cond: *cond}
+				c.Cond = *cond
 			}
 
 			if _, err := p.expectType(tkColon); err != nil {
-				return nil, err
+				return p.fail(err)
 			}
 
 			for {
@@ -759,24 +1222,28 @@ func (p *Parser) parseSwitch() (*Node, error) {
 				}
 
 				if stmt, err := p.parseStatement(); err != nil {
-					return nil, err
+					return p.fail(err)
 				} else {
 					c.Statements = append(c.Statements, *stmt)
 				}
 			}
 
+			c.FallsThrough = canComplete(BlockNode{Nodes: c.Statements})
 			switchNode.Cases = append(switchNode.Cases, c)
 
 		} else if _, ok := p.accept(tkKeyword, "default"); ok {
 			if _, err := p.expectType(tkColon); err != nil {
-				return nil, err
+				return p.fail(err)
 			}
 
 			if switchNode.DefaultCase != nil {
-				return nil, NewParseError(p.token(),
-					"Multiple 'default' cases")
+				return p.fail(NewParseError(p.token(),
+					"Multiple 'default' cases"))
 			}
 
+			// Not nil, so an empty default is kept
+			switchNode.DefaultCase = []Node{}
+
 			for {
 				if _, ok := p.accept(tkKeyword, "case"); ok {
 					p.tokIdx -= 1
@@ -790,7 +1257,7 @@ func (p *Parser) parseSwitch() (*Node, error) {
 				}
 
 				if stmt, err := p.parseStatement(); err != nil {
-					return nil, err
+					return p.fail(err)
 				} else {
 					switchNode.DefaultCase =
 						append(switchNode.DefaultCase, *stmt)
@@ -798,8 +1265,8 @@ func (p *Parser) parseSwitch() (*Node, error) {
 			}
 
 		} else {
-			return nil, NewParseError(p.token(),
-				"expected 'case' or 'default'")
+			return p.fail(NewParseError(p.token(),
+				"expected 'case' or 'default'"))
 		}
 	}
 
@@ -820,54 +1287,63 @@ func (p *Parser) parseTopLevel() (node *Node, err error) {
 		p.tokIdx = pos
 	} else {
 		// Otherwise, it's an actual syntax error
-		return nil, err
+		return p.fail(err)
 	}
 
 	if node, err := p.parseFuncDeclaration(); err == nil {
 		return node, nil
 	} else if p.tokIdx != pos {
-		return nil, err
+		return p.fail(err)
 	}
 
-	return nil, NewParseError(p.token(), "expected top level decl")
+	return p.fail(NewParseError(p.token(), "expected top level decl"))
 }
 
 func (p *Parser) parseVarDecl() (*Node, error) {
-	var err error
-
-	if _, err = p.expect(tkKeyword, "auto"); err != nil {
-		return nil, err
+	kw, err := p.expect(tkKeyword, "auto")
+	if err != nil {
+		return p.fail(err)
 	}
 
-	varNode := VarDeclNode{}
+	varNode := VarDeclNode{baseNode: at(*kw)}
 
 	for {
 		ident, err := p.expectType(tkIdent)
 		if err != nil {
-			return nil, err
+			return p.fail(err)
 		}
 
 		if _, ok := p.acceptType(tkOpenBracket); ok {
+			_, negative := p.accept(tkOperator, "-")
 
-			if num, err := p.expectType(tkNumber); err != nil {
-				return nil, err
-			} else {
-				size, err := strconv.Atoi(num.value)
+			num, err := p.expectType(tkNumber)
+			if err != nil {
+				return p.fail(err)
+			}
 
-				if err != nil {
-					return nil, NewParseError(p.token(), "invalid integer literal")
-				}
+			size, err := p.parseInteger(*num)
+			if err != nil {
+				return p.fail(err)
+			}
 
-				varNode.Vars = append(varNode.Vars,
-					VarDecl{ident.value, true, size})
+			if negative && size != 0 {
+				return p.fail(NewParseError(*num,
+					fmt.Sprintf("vector size -%d is negative", size)))
 			}
 
 			if _, err := p.expectType(tkCloseBracket); err != nil {
-				return nil, err
+				return p.fail(err)
 			}
+
+			decl := VarDecl{Name: ident.value, VecDecl: true, Size: int(size)}
+			if decl.Values, err = p.parseVectorValues(); err != nil {
+				return p.fail(err)
+			}
+
+			varNode.Vars = append(varNode.Vars, decl)
 		} else {
 			varNode.Vars = append(varNode.Vars,
-				VarDecl{ident.value, false, 0})
+				VarDecl{Name: ident.value})
 		}
 
 		if _, ok := p.acceptType(tkComma); !ok {
@@ -876,18 +1352,82 @@ func (p *Parser) parseVarDecl() (*Node, error) {
 	}
 
 	if _, err = p.expectType(tkSemicolon); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if len(varNode.Vars) <= 0 {
-		return nil, NewParseError(p.token(),
-			"expected at least 1 variable in auto declaration")
+		return p.fail(NewParseError(p.token(),
+			"expected at least 1 variable in auto declaration"))
 	}
 
 	var node Node = varNode
 	return &node, nil
 }
 
+// Initial values of an auto vector, written after the size as for an
+// external vector: `auto v[2] 1, 2, w;`. Not standard B, but accepted so
+// both kinds of vector are represented the same way. A comma followed by
+// anything but a constant is left for the next variable.
+func (p *Parser) parseVectorValues() ([]Node, error) {
+	var values []Node
+
+	isConstant := func() bool {
+		tok := p.token()
+
+		// Look past a sign
+		if tok.kind == tkOperator && (tok.value == "-" || tok.value == "+") {
+			if _, err := p.nextToken(); err != nil {
+				panic(err)
+			}
+
+			tok = p.token()
+			p.tokIdx -= 1
+		}
+
+		switch tok.kind {
+		case tkNumber, tkCharacter, tkString:
+			return true
+		}
+		return false
+	}
+
+	if !isConstant() {
+		return nil, nil
+	}
+
+	for {
+		constant, err := p.parseConstant()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, *constant)
+
+		if _, ok := p.acceptType(tkComma); !ok {
+			return values, nil
+		}
+
+		if !isConstant() {
+			// rewind to the comma
+			p.tokIdx -= 1
+			return values, nil
+		}
+
+		if err := p.checkVectorValues(len(values) + 1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Error if a vector initializer has grown past MaxVectorValues
+func (p *Parser) checkVectorValues(count int) error {
+	if count > p.MaxVectorValues {
+		return NewParseError(p.token(), fmt.Sprintf(
+			"more than %d values in vector initializer", p.MaxVectorValues))
+	}
+
+	return nil
+}
+
 // zero or more comma separated variables
 func (p *Parser) parseVariableList() ([]string, error) {
 	var err error
@@ -910,31 +1450,39 @@ func (p *Parser) parseVariableList() ([]string, error) {
 }
 
 func (p *Parser) parseWhile() (*Node, error) {
-	if _, err := p.expect(tkKeyword, "while"); err != nil {
-		return nil, err
+	kw, err := p.expect(tkKeyword, "while")
+	if err != nil {
+		return p.fail(err)
 	}
 
 	if _, err := p.expectType(tkOpenParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	cond, err := p.parseExpression()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	if _, err := p.expectType(tkCloseParen); err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
 	body, err := p.parseStatement()
 	if err != nil {
-		return nil, err
+		return p.fail(err)
 	}
 
-	var node Node = WhileNode{Cond: *cond, Body: *body}
+	var node Node = WhileNode{baseNode: at(*kw), Cond: *cond, Body: *body}
 	return &node, nil
 }
 
+// Position of a node whose first token is tok
+func at(tok Token) baseNode { return baseNode{tok.start} }
+
+// Position of a node which starts with n, such as a call or a binary
+// expression
+func startOf(n Node) baseNode { return baseNode{n.Position()} }
+
 func (p *Parser) tokenAt(idx int) Token { return p.tokens[idx] }
 func (p *Parser) token() Token          { return p.tokenAt(p.tokIdx) }
@@ -1,6 +1,8 @@
 package parse
 
 import (
+	"io"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -49,12 +51,89 @@ func TestParserExpect(t *testing.T) {
 	}
 }
 
+func TestParseErrorPosition(t *testing.T) {
+	parser := NewParser("name", strings.NewReader("f() {\n  a = 1 +;\n}"))
+
+	_, err := parser.Parse()
+	if err == nil || !strings.Contains(err.Error(), "at 2:10,") {
+		t.Errorf("Error position: %v", err)
+	}
+}
+
+func TestParseUnexpectedEOF(t *testing.T) {
+	var tests = []struct {
+		src string
+		eof bool
+	}{
+		{"if (a", true},
+		{"if (a) b = ", true},
+		{"{ a; b;", true},
+		{"x = f(1, ", true},
+		{"return", true},
+		{"x; /* unterminated", true},
+		{"x = \"abc", true},
+		{"x = 'a", true},
+		{"x = \"abc\n\";", false},
+		{"if (a) b = );", false},
+		{"x = f(1 2);", false},
+		{"a b", false},
+	}
+
+	for _, test := range tests {
+		_, err := NewParser("", strings.NewReader(test.src)).ParseStatement()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.src)
+		} else if IsUnexpectedEOF(err) != test.eof {
+			t.Errorf("%s: expected unexpected EOF to be %v, got %v", test.src,
+				test.eof, err)
+		}
+	}
+
+	if _, err := NewParser("", strings.NewReader("a +")).ParseExpression(); !IsUnexpectedEOF(err) {
+		t.Errorf("Expected unexpected EOF in an expression, got %v", err)
+	}
+
+	if _, err := NewParser("", strings.NewReader("f() {")).Parse(); !IsUnexpectedEOF(err) {
+		t.Errorf("Expected unexpected EOF in a function, got %v", err)
+	}
+}
+
+func TestParseErrorPretty(t *testing.T) {
+	src := "f() {\n\tx = 'é' +;\n}"
+
+	_, err := NewParser("name", strings.NewReader(src)).Parse()
+	if err == nil {
+		t.Fatalf("Parsed bad input")
+	}
+
+	expected := err.Error() + "\n\tx = 'é' +;\n\t         ^"
+	if pretty := err.(*ParseError).PrettyError(src); pretty != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, pretty)
+	}
+
+	// Errors at the end of the input point just past the last line
+	src = "f() {\n  a = 1;"
+
+	_, err = NewParser("name", strings.NewReader(src)).Parse()
+	if pretty := err.(*ParseError).PrettyError(src); !strings.HasSuffix(pretty, "\n  a = 1;\n        ^") {
+		t.Errorf("End of input: %q", pretty)
+	}
+
+	// Windows line endings
+	src = "f() {\r\n  a;\r\n  b = 1 +;\r\n}"
+
+	_, err = NewParser("name", strings.NewReader(src)).Parse()
+	if pretty := err.(*ParseError).PrettyError(src); !strings.HasSuffix(pretty, "\n  b = 1 +;\n         ^") {
+		t.Errorf("Windows line endings: %q", pretty)
+	}
+}
+
 func TestParserExternalVarInit(t *testing.T) {
 	parser := NewParser("name", strings.NewReader(`
 varname 123;
 varname 'abcd';
 zero ;
-varname [1] 123, '245', "abc";
+varname [2] 123, '245', "abc";
 `))
 
 	node, err := parser.parseExternalVariableInit()
@@ -78,6 +157,251 @@ varname [1] 123, '245', "abc";
 	}
 }
 
+func TestParseSignedInitializers(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+x -5;
+y +5;
+c -'a';
+v[2] -1, -2;
+f() { auto w[1] -3, +'b'; }
+`)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := []Node{
+		ExternVarInitNode{Name: "x", Value: IntegerNode{Value: -5}},
+		ExternVarInitNode{Name: "y", Value: IntegerNode{Value: 5}},
+		ExternVarInitNode{Name: "c", Value: IntegerNode{Value: -'a'}},
+		ExternVecInitNode{Name: "v", Size: 2, Values: []Node{
+			IntegerNode{Value: -1}, IntegerNode{Value: -2}}},
+	}
+
+	for i, v := range unit.Vars {
+		if !Equal(v, expected[i]) {
+			t.Errorf("Expected %v, got %v", expected[i], v)
+		}
+	}
+
+	decl := unit.Funcs[0].Body.(BlockNode).Nodes[0].(VarDeclNode)
+	values := []Node{IntegerNode{Value: -3}, IntegerNode{Value: 'b'}}
+	if !Equal(BlockNode{Nodes: decl.Vars[0].Values}, BlockNode{Nodes: values}) {
+		t.Errorf("Expected %v, got %v", values, decl.Vars[0].Values)
+	}
+
+	for _, src := range []string{"s -\"str\";", "x -;", "x - y;"} {
+		if _, err := NewParser("", strings.NewReader(src)).Parse(); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestParseVectorSize(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(`
+full [2] 1, 2, 3;
+short [4] 1;
+over [1] 1, 2, 3;
+`))
+
+	for _, size := range []int{2, 4} {
+		node, err := parser.parseExternalVariableInit()
+		if err != nil {
+			t.Fatalf("Vector size %d: %v", size, err)
+		}
+
+		if vec := (*node).(ExternVecInitNode); vec.Size != size {
+			t.Errorf("Expected size %d, got %v", size, vec)
+		}
+	}
+
+	_, err := parser.parseExternalVariableInit()
+	if err == nil || !strings.Contains(err.Error(), "3 values given for vector of size 1") {
+		t.Errorf("Too many values: %v", err)
+	}
+
+	node, err := NewParser("name", strings.NewReader(`v [] 'a', 'b', 'c';`)).parseExternalVariableInit()
+	if err != nil {
+		t.Fatalf("Inferred size: %v", err)
+	}
+
+	if vec := (*node).(ExternVecInitNode); vec.Size != 2 || len(vec.Values) != 3 {
+		t.Errorf("Inferred size: %v", vec)
+	}
+}
+
+func TestParseMaxVectorValues(t *testing.T) {
+	src := "v [] " + strings.Repeat("1, ", 10) + "1;"
+
+	parser := NewParser("name", strings.NewReader(src))
+	if _, err := parser.parseExternalVariableInit(); err != nil {
+		t.Errorf("Default limit: %v", err)
+	}
+
+	parser = NewParser("name", strings.NewReader(src))
+	parser.MaxVectorValues = 10
+
+	_, err := parser.parseExternalVariableInit()
+	if err == nil || !strings.Contains(err.Error(), "more than 10 values in vector initializer") {
+		t.Errorf("Limit exceeded: %v", err)
+	}
+
+	parser = NewParser("name", strings.NewReader("auto v[20] "+strings.Repeat("1, ", 10)+"1;"))
+	parser.MaxVectorValues = 10
+
+	if _, err := parser.parseVarDecl(); err == nil {
+		t.Errorf("Limit exceeded in auto vector")
+	}
+
+	parser = NewParser("name", strings.NewReader(src))
+	parser.MaxVectorValues = 11
+
+	if _, err := parser.parseExternalVariableInit(); err != nil {
+		t.Errorf("Limit reached: %v", err)
+	}
+}
+
+// Integer literals are compared by value, not by their spelling
+func TestParseIntegerIdentity(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(`7 007`))
+
+	seven, err := parser.parseConstant()
+	if err != nil {
+		t.Fatalf("Integer: %v", err)
+	}
+
+	padded, err := parser.parseConstant()
+	if err != nil {
+		t.Fatalf("Padded integer: %v", err)
+	}
+
+	if !Equal(*seven, *padded) {
+		t.Errorf("Integer identity: %v != %v", *seven, *padded)
+	}
+
+	if d := Diff(*seven, *padded); d != "" {
+		t.Errorf("Integer identity: %s", d)
+	}
+
+	// While the formatter keeps the spelling
+	if str := (*padded).String(); str != "007" {
+		t.Errorf("Expected 007, got %s", str)
+	}
+
+	unit, err := NewParser("", strings.NewReader("x 007;\ny -010;\n")).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	formatted := unit.Format()
+	for _, expected := range []string{"x 007;", "y -010;"} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, formatted)
+		}
+	}
+}
+
+func TestParseOctal(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(`0777 0 010`))
+
+	for _, expected := range []int64{511, 0, 8} {
+		node, err := parser.parseConstant()
+		if err != nil {
+			t.Errorf("Octal: %v", err)
+		} else if num := (*node).(IntegerNode); num.Value != expected {
+			t.Errorf("Octal: expected %d, got %d", expected, num.Value)
+		}
+	}
+
+	lex := NewLexer("name", strings.NewReader(`08`))
+	if tok, err := lex.NextToken(); err == nil || tok.kind != tkError {
+		t.Errorf("Bad octal: %v", tok)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	parser := NewParserWithConfig("name",
+		strings.NewReader(`0xFF 0X10 0x0 0xdeadBEEF 010`), ModernConfig)
+
+	for _, expected := range []int64{255, 16, 0, 0xdeadbeef, 8} {
+		node, err := parser.parseConstant()
+		if err != nil {
+			t.Errorf("Hex: %v", err)
+		} else if num := (*node).(IntegerNode); num.Value != expected {
+			t.Errorf("Hex: expected %d, got %d", expected, num.Value)
+		}
+	}
+
+	var bad = []struct {
+		input, err string
+	}{
+		{"0xG", "invalid digit 'G' in hexadecimal literal"},
+		{"0x1fg", "invalid digit 'g' in hexadecimal literal"},
+		{"0x", "hexadecimal literal has no digits"},
+		{"019", "invalid digit '9' in octal literal"},
+		{"0b1", "bad number: 0b1"},
+		{"1_000", "bad number: 1_000"},
+	}
+
+	for _, test := range bad {
+		lex := NewLexer("name", strings.NewReader(test.input))
+		lex.Config = ModernConfig
+		if tok, err := lex.NextToken(); err == nil || tok.kind != tkError ||
+			!strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected %q, got %v", test.input, test.err, err)
+		}
+	}
+
+	// Classic B has no hexadecimal literals
+	lex := NewLexer("name", strings.NewReader("0xFF"))
+	if _, err := lex.NextToken(); err == nil || !strings.Contains(err.Error(), "bad number: 0xFF") {
+		t.Errorf("0xFF: expected a bad number, got %v", err)
+	}
+}
+
+func TestParseOverflow(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(
+		`9223372036854775807 9223372036854775808`))
+
+	if node, err := parser.parseConstant(); err != nil {
+		t.Errorf("Largest integer: %v", err)
+	} else if num := (*node).(IntegerNode); num.Value != 9223372036854775807 {
+		t.Errorf("Largest integer: %v", num)
+	}
+
+	_, err := parser.parseConstant()
+	if err == nil {
+		t.Fatalf("Integer overflow accepted")
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "9223372036854775808") ||
+		!strings.Contains(msg, "maximum 9223372036854775807") {
+		t.Errorf("Integer overflow message: %s", msg)
+	}
+}
+
+func TestParseConcatStrings(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(`"ab" "cd"`))
+	parser.ConcatStrings = true
+
+	if node, err := parser.parseConstant(); err != nil {
+		t.Errorf("Concat strings: %v", err)
+	} else if str := (*node).String(); str != `"abcd"` {
+		t.Errorf("Concat strings: %s", str)
+	}
+
+	parser = NewParser("name", strings.NewReader(`"ab" "cd"`))
+
+	if node, err := parser.parseConstant(); err != nil {
+		t.Errorf("Separate strings: %v", err)
+	} else if str := (*node).String(); str != `"ab"` {
+		t.Errorf("Separate strings: %s", str)
+	}
+
+	if _, err := NewParser("", strings.NewReader(`x "ab" "cd";`)).Parse(); err == nil {
+		t.Errorf("Adjacent strings accepted without extension")
+	}
+}
+
 // TODO: flesh out this test
 func TestParseFuncDecl(t *testing.T) {
 	parser := NewParser("name", strings.NewReader(`main(a,b,c) {}`))
@@ -104,6 +428,29 @@ func TestParseVarDecl(t *testing.T) {
 	if _, err := parser.parseVarDecl(); err != nil {
 		t.Errorf("Var: %v", err)
 	}
+
+	parser = NewParser("name", strings.NewReader(`auto v[-1];`))
+	if _, err := parser.parseVarDecl(); err == nil ||
+		!strings.Contains(err.Error(), "vector size -1 is negative") {
+		t.Errorf("Negative size: %v", err)
+	}
+
+	parser = NewParser("name", strings.NewReader(`auto a, v[3] 1, 'x', w, u[2];`))
+	node, err := parser.parseVarDecl()
+	if err != nil {
+		t.Fatalf("Vector values: %v", err)
+	}
+
+	expected := VarDeclNode{Vars: []VarDecl{
+		{Name: "a"},
+		{Name: "v", VecDecl: true, Size: 3,
+			Values: []Node{IntegerNode{Value: 1}, CharacterNode{Value: "x"}}},
+		{Name: "w"},
+		{Name: "u", VecDecl: true, Size: 2},
+	}}
+	if !Equal(*node, expected) {
+		t.Errorf("Vector values: expected %v, got %v", expected, *node)
+	}
 }
 
 func TestParseParen(t *testing.T) {
@@ -145,19 +492,57 @@ func TestParsePrimary(t *testing.T) {
 	}
 
 	parser = NewParser("name", strings.NewReader(`
-(func)(1,(ab(c)),3)
+(func)(1,(ab(c)),3);
 ((abb(++a))[23])[ab(c(d[2]))]
 `))
 	if _, err := parser.parsePrimary(); err != nil {
 		t.Errorf("Complex func call: %v", err)
 	}
 
+	parser.expectType(tkSemicolon)
+
 	if _, err := parser.parsePrimary(); err != nil {
 		t.Errorf("Complex array access: %v", err)
 	}
 
 }
 
+func TestParsePostfixChains(t *testing.T) {
+	a, f, i, j := IdentNode{Value: "a"}, IdentNode{Value: "f"},
+		IdentNode{Value: "i"}, IdentNode{Value: "j"}
+
+	tests := []struct {
+		src  string
+		tree Node
+	}{
+		{"a[i][j]", ArrayAccessNode{
+			Array: ArrayAccessNode{Array: a, Index: i},
+			Index: j}},
+		{"f()()", FunctionCallNode{
+			Callable: FunctionCallNode{Callable: f, Args: []Node{}},
+			Args:     []Node{}}},
+		{"f(i)[j](a)", FunctionCallNode{
+			Callable: ArrayAccessNode{
+				Array: FunctionCallNode{Callable: f, Args: []Node{i}},
+				Index: j},
+			Args: []Node{a}}},
+		{"grid[r][c]", ArrayAccessNode{
+			Array: ArrayAccessNode{
+				Array: IdentNode{Value: "grid"},
+				Index: IdentNode{Value: "r"}},
+			Index: IdentNode{Value: "c"}}},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).ParseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !Equal(node, test.tree) {
+			t.Errorf("%s: expected %s, got %s", test.src, SExpr(test.tree), SExpr(node))
+		}
+	}
+}
+
 func TestParseUnary(t *testing.T) {
 	parser := NewParser("", strings.NewReader(`
 *a, &a, -a, !a, ++a, --a, ~a, /* prefix ops */
@@ -271,6 +656,267 @@ switch(1+1) {
 	}
 
 	// TODO: actually test this
+
+	// Case labels may be signed like initializers
+	node, err := NewParser("", strings.NewReader(
+		"switch(1){ case -1: ; case +'a': ; }")).parseSwitch()
+	if err != nil {
+		t.Fatalf("Signed case: %v", err)
+	}
+
+	cases := (*node).(SwitchNode).Cases
+	for i, expected := range []int64{-1, 'a'} {
+		if num, ok := cases[i].Cond.(IntegerNode); !ok || num.Value != expected {
+			t.Errorf("Expected case %d, got %v", expected, cases[i].Cond)
+		}
+	}
+}
+
+func TestParseSwitchFallthrough(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+switch(x) {
+  case 1: a(); break;
+  case 2: b();
+  case 3:
+  case 4: if (x) return; else goto out;
+  case 5: { c(); break; }
+  case 6: if (x) break;
+}
+`))
+
+	node, err := parser.parseSwitch()
+	if err != nil {
+		t.Fatalf("Switch statement: %v", err)
+	}
+
+	expected := []bool{false, true, true, false, false, true}
+	for i, c := range (*node).(SwitchNode).Cases {
+		if c.FallsThrough != expected[i] {
+			t.Errorf("%v: expected falls through = %v", c, expected[i])
+		}
+	}
+}
+
+func TestParseCaseGuard(t *testing.T) {
+	src := `
+switch(x) {
+  case x > 0: positive(); break;
+  case 0: zero();
+}
+`
+
+	parser := NewParser("", strings.NewReader(src))
+	if _, err := parser.parseSwitch(); err == nil {
+		t.Errorf("Case guard accepted without extension")
+	}
+
+	parser = NewParser("", strings.NewReader(src))
+	parser.CaseGuards = true
+
+	node, err := parser.parseSwitch()
+	if err != nil {
+		t.Fatalf("Case guard: %v", err)
+	}
+
+	sw := (*node).(SwitchNode)
+	if guard, ok := sw.Cases[0].Cond.(BinaryNode); !ok || guard.String() != "x > 0" {
+		t.Errorf("Case guard: %v", sw.Cases[0].Cond)
+	}
+
+	if _, ok := sw.Cases[1].Cond.(IntegerNode); !ok {
+		t.Errorf("Constant case with guards enabled: %v", sw.Cases[1].Cond)
+	}
+}
+
+func TestParsePrimaryKinds(t *testing.T) {
+	tests := map[string]string{
+		"(a)":      "(paren (ident a))",
+		"42":       "(int 42)",
+		"'ab'":     "(char 'ab')",
+		`"hi*n"`:   `(string "hi*n")`,
+		"x":        "(ident x)",
+		"v[i + 1]": "(index (ident v) (binary + (ident i) (int 1)))",
+		"f(a, 2)":  "(call (ident f) (ident a) (int 2))",
+		"(g)()":    "(call (paren (ident g)))",
+	}
+
+	for src, expected := range tests {
+		node, err := NewParser("", strings.NewReader(src)).parsePrimary()
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if str := SExpr(*node); str != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, str)
+		}
+	}
+
+	errors := map[string]string{
+		"if":    "at 1:1, at token: Keyword: if: expected primary expression",
+		";":     "at 1:1, at token: Semicolon: ;: expected primary expression",
+		"(1 b)": "at 1:4, at token: Identifier: b: Expected Close Paren",
+		"(a":    "at 1:3, at token: EOF: : Expected Close Paren",
+		"v[1)":  "at 1:4, at token: Close Paren: ): Expected Close bracket",
+	}
+
+	for src, expected := range errors {
+		_, err := NewParser("", strings.NewReader(src)).parsePrimary()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got %v", src, expected, err)
+		}
+	}
+}
+
+func TestParseStrayOperator(t *testing.T) {
+	var tests = []struct {
+		input, err string
+	}{
+		{"+;", "at 1:1, at token: Operator: +: expected operand after '+'"},
+		{"* )", "at 1:1, at token: Operator: *: expected operand after '*'"},
+		{"a = - ;", "at 1:5, at token: Operator: -: expected operand after '-'"},
+		{"+a;", "at 1:1, at token: Operator: +: invalid unary op '+'"},
+		{"*(a +);", "at 1:6, at token: Close Paren: ): expected primary expression"},
+		{"!* ;", "at 1:2, at token: Operator: *: expected operand after '*'"},
+	}
+
+	for _, test := range tests {
+		parser := NewParser("", strings.NewReader(test.input))
+
+		if _, err := parser.parseStatement(); err == nil ||
+			!strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected %q, got %v", test.input, test.err, err)
+		}
+	}
+
+	// Prefix operators nest, and bind looser than postfix ones
+	p := IdentNode{Value: "p"}
+	var nested = []struct {
+		input    string
+		expected Node
+	}{
+		{"!*p", UnaryNode{Oper: "!", Node: UnaryNode{Oper: "*", Node: p}}},
+		{"**p", UnaryNode{Oper: "*", Node: UnaryNode{Oper: "*", Node: p}}},
+		{"-*p++", UnaryNode{Oper: "-", Node: UnaryNode{Oper: "*",
+			Node: UnaryNode{Oper: "++", Node: p, Postfix: true}}}},
+	}
+
+	for _, test := range nested {
+		node, err := NewParser("", strings.NewReader(test.input)).ParseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if !Equal(node, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.input, test.expected, node)
+		}
+	}
+}
+
+func TestParseTrailingTokens(t *testing.T) {
+	var tests = []struct {
+		input, err string
+		parse      func(*Parser) (Node, error)
+	}{
+		{"a + b", "", (*Parser).ParseExpression},
+		{"a b", "at 1:3, at token: Identifier: b: unexpected trailing token",
+			(*Parser).ParseExpression},
+		{"a + b;", "at 1:6, at token: Semicolon: ;: unexpected trailing token",
+			(*Parser).ParseExpression},
+		{"return (1);", "", (*Parser).ParseStatement},
+		{"x = 1; y = 2;", "at 1:8, at token: Identifier: y: unexpected trailing token",
+			(*Parser).ParseStatement},
+		{"{ a; } }", "at 1:8, at token: Close Brace: }: unexpected trailing token",
+			(*Parser).ParseStatement},
+	}
+
+	for _, test := range tests {
+		_, err := test.parse(NewParser("", strings.NewReader(test.input)))
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if test.err != "" && (err == nil ||
+			!strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected %q, got %v", test.input, test.err, err)
+		}
+	}
+}
+
+func TestParseCaseAssignment(t *testing.T) {
+	for _, guards := range []bool{false, true} {
+		parser := NewParser("", strings.NewReader(`switch(x) { case x = 1: y(); }`))
+		parser.CaseGuards = guards
+
+		_, err := parser.parseSwitch()
+		if err == nil || !strings.Contains(err.Error(),
+			"at 1:18, at token: Identifier: x: assignment '=' in case label, did you mean '=='?") {
+			t.Errorf("Case guards %v: %v", guards, err)
+		}
+	}
+
+	parser := NewParser("", strings.NewReader(`switch(x) { case n =+ x: ; }`))
+	if _, err := parser.parseSwitch(); err == nil ||
+		!strings.Contains(err.Error(), "assignment '=+' in case label") {
+		t.Errorf("Compound assignment: %v", err)
+	}
+
+	// Comparisons are fine where guards are allowed
+	parser = NewParser("", strings.NewReader(`switch(x) { case x == 1: ; }`))
+	parser.CaseGuards = true
+	if _, err := parser.parseSwitch(); err != nil {
+		t.Errorf("Comparison guard: %v", err)
+	}
+}
+
+func TestParseGoto(t *testing.T) {
+	var tests = []struct {
+		src   string
+		label Node
+		name  string
+	}{
+		{"goto lbl;", IdentNode{Value: "lbl"}, "lbl"},
+		{"goto *p;", UnaryNode{Oper: "*", Node: IdentNode{Value: "p"}}, ""},
+		{"goto labels[i];", ArrayAccessNode{Array: IdentNode{Value: "labels"},
+			Index: IdentNode{Value: "i"}}, ""},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).ParseStatement()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+
+		gt := node.(GotoNode)
+		if !Equal(gt.Label, test.label) {
+			t.Errorf("%s: expected label %v, got %v", test.src, test.label, gt.Label)
+		}
+
+		if name, named := gt.Name(); name != test.name || named != (test.name != "") {
+			t.Errorf("%s: expected name %q, got %q", test.src, test.name, name)
+		}
+
+		if gt.String() != test.src {
+			t.Errorf("%s: round trip gave %s", test.src, gt)
+		}
+	}
+
+	if _, err := NewParser("", strings.NewReader("goto ;")).ParseStatement(); err == nil {
+		t.Errorf("Expected an error for a missing label")
+	}
+}
+
+func TestParseContinue(t *testing.T) {
+	node, err := NewParserWithConfig("", strings.NewReader("while (x) continue;"),
+		ModernConfig).ParseStatement()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if body := node.(WhileNode).Body; !Equal(body, ContinueNode{}) || body.String() != "continue;" {
+		t.Errorf("Expected continue, got %v", body)
+	}
+
+	// Only a keyword in the modern dialect
+	node, err = NewParser("", strings.NewReader("continue;")).ParseStatement()
+	if err != nil || !Equal(node, StatementNode{Expr: IdentNode{Value: "continue"}}) {
+		t.Errorf("Expected an identifier in classic B, got %v, %v", node, err)
+	}
 }
 
 func TestParseStatement(t *testing.T) {
@@ -375,42 +1021,136 @@ switch(1+1) {
 
 // TODO: I'm only sort of sure about the correctness of these
 func TestParseOperatorPrecedence(t *testing.T) {
-	parser := NewParser("", strings.NewReader(`
-a=b+c---d /* (a = (b + (c-- - d))) */
-a+2*--a=b=c /* ((a + (2 * --a)) = (b = c)) */
-a=b=c+d=e
-`))
-
-	node, err := parser.parseExpression()
-	if err != nil {
-		t.Errorf("Operator parse: %v", err)
+	var tests = []struct {
+		src, expected string
+	}{
+		{"a=b+c---d", "(a = ((b + c--) - d))"},
+		{"x=a+2*--a==b", "(x = ((a + (2 * --a)) == b))"},
+		{"a=b=c+d", "(a = (b = (c + d)))"},
+		{"*p=+v[1]<<2", "(*p =+ (v[1] << 2))"},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if str := withPrecedence(*node); str != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expected, str)
+		}
 	}
+}
 
-	if str := (*node).(BinaryNode).StringWithPrecedence(); str !=
-		"(a = (b + (c-- - d)))" {
-		t.Errorf("Bad precedence: %s", str)
+func TestParseAssignment(t *testing.T) {
+	var tests = []struct {
+		src      string
+		expected Node
+	}{
+		{"a = 1", AssignNode{Lhs: IdentNode{Value: "a"}, Op: "=", Rhs: IntegerNode{Value: 1}}},
+		{"v[i] =+ 2", AssignNode{
+			Lhs: ArrayAccessNode{Array: IdentNode{Value: "v"}, Index: IdentNode{Value: "i"}},
+			Op:  "=+", Rhs: IntegerNode{Value: 2}}},
+		{"*p =<< a == b", AssignNode{
+			Lhs: UnaryNode{Oper: "*", Node: IdentNode{Value: "p"}, Postfix: false}, Op: "=<<",
+			Rhs: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "==", Right: IdentNode{Value: "b"}}}},
+		{"(*p) = 1", AssignNode{
+			Lhs: ParenNode{Node: UnaryNode{Oper: "*", Node: IdentNode{Value: "p"}}},
+			Op:  "=", Rhs: IntegerNode{Value: 1}}},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !Equal(*node, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.src, test.expected, *node)
+		}
 	}
 
-	node, err = parser.parseExpression()
-	if err != nil {
-		t.Errorf("Operator parse: %v", err)
+	var errors = []struct {
+		src, err string
+	}{
+		{"1 = 2", "at 1:3, at token: Operator: =: cannot assign to 1"},
+		{"a + b =- c", "at 1:7, at token: Operator: =-: cannot assign to a + b"},
+		{"x = f() = 1", "at 1:9, at token: Operator: =: cannot assign to f()"},
+		{"(a + 1) = 1", "cannot assign to (a + 1)"},
+		{"-a =* 2", "cannot assign to -a"},
 	}
 
-	if str := (*node).(BinaryNode).StringWithPrecedence(); str !=
-		"((a + (2 * --a)) = (b = c))" {
-		t.Errorf("Bad precedence: %s", str)
+	for _, test := range errors {
+		_, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected %q, got %v", test.src, test.err, err)
+		}
 	}
+}
 
-	node, err = parser.parseExpression()
-	if err != nil {
-		t.Errorf("Operator parse: %v", err)
+func TestOperatorPrecedenceTable(t *testing.T) {
+	// Loosest binding first
+	var levels = []struct {
+		ops   []string
+		assoc Assoc
+	}{
+		{[]string{"=", "=+", "=-", "=*", "=/", "=%", "=&", "=|", "=<<", "=>>"}, RightAssoc},
+		{[]string{"?"}, RightAssoc},
+		{[]string{"|"}, LeftAssoc},
+		{[]string{"^"}, LeftAssoc},
+		{[]string{"&"}, LeftAssoc},
+		{[]string{"==", "!="}, LeftAssoc},
+		{[]string{"<", "<=", ">", ">="}, LeftAssoc},
+		{[]string{"<<", ">>"}, LeftAssoc},
+		{[]string{"+", "-"}, LeftAssoc},
+		{[]string{"*", "/", "%"}, LeftAssoc},
+	}
+
+	last := -1
+	for _, level := range levels {
+		prec, ok := OperatorPrecedence(level.ops[0])
+		if !ok || prec <= last {
+			t.Errorf("%s: expected precedence above %d, got %d", level.ops[0],
+				last, prec)
+		}
+
+		for _, op := range level.ops {
+			if p, ok := OperatorPrecedence(op); !ok || p != prec {
+				t.Errorf("%s: expected precedence %d, got %d", op, prec, p)
+			}
+
+			if assoc := OperatorAssoc(op); assoc != level.assoc {
+				t.Errorf("%s: expected associativity %d, got %d", op,
+					level.assoc, assoc)
+			}
+		}
+
+		last = prec
 	}
 
-	if str := (*node).(BinaryNode).StringWithPrecedence(); str !=
-		"(a = (b = ((c + d) = e)))" {
-		t.Errorf("Bad precedence: %s", str)
+	for _, op := range []string{"!", "~", "++", "--", "&&", ""} {
+		if _, ok := OperatorPrecedence(op); ok {
+			t.Errorf("%s: expected not to be a binary operator", op)
+		}
 	}
+}
 
+// Every binary operator the lexer produces has a precedence
+func TestOperatorPrecedenceComplete(t *testing.T) {
+	lex := NewLexer("", strings.NewReader(
+		"a = b =+ b =- b =* b =/ b =% b =& b =| b =<< b =>> b == b != b "+
+			"< b <= b > b >= b << b >> b + b - b * b / b % b & b | b ^ b"))
+
+	for {
+		tok, err := lex.NextToken()
+		if err != nil {
+			t.Fatalf("Lex: %v", err)
+		} else if tok.kind == tkEof {
+			break
+		} else if tok.kind != tkOperator {
+			continue
+		}
+
+		if _, ok := OperatorPrecedence(tok.value); !ok {
+			t.Errorf("%s: missing precedence", tok.value)
+		}
+	}
 }
 
 func TestParseIf(t *testing.T) {
@@ -464,3 +1204,411 @@ func TestParse(t *testing.T) {
 	}
 
 }
+
+func TestParseNodeInvariant(t *testing.T) {
+	productions := []struct {
+		name string
+		fn   func(*Parser) (*Node, error)
+		good string
+		bad  string
+	}{
+		{"block", (*Parser).parseBlock, `{ a; }`, `{ a; `},
+		{"constant", (*Parser).parseConstant, `'a'`, `a`},
+		{"expression", (*Parser).parseExpression, `a = b + 1`, `a = +`},
+		{"extrn", (*Parser).parseExternVarDecl, `extrn a;`, `extrn ;`},
+		{"extern init", (*Parser).parseExternalVariableInit, `a[2] 1, 2;`, `a[2] 1,;`},
+		{"function", (*Parser).parseFuncDeclaration, `f(a) a;`, `f(a) }`},
+		{"ident", (*Parser).parseIdent, `a`, `1`},
+		{"if", (*Parser).parseIf, `if (a) b;`, `if a b;`},
+		{"paren", (*Parser).parseParen, `(a)`, `(a`},
+		{"primary", (*Parser).parsePrimary, `a[1](2)`, `a[1`},
+		{"statement", (*Parser).parseStatement, `return a;`, `return a`},
+		{"switch", (*Parser).parseSwitch, `switch (a) { case 1: ; }`, `switch (a) { x }`},
+		{"toplevel", (*Parser).parseTopLevel, `a 1;`, `1 a;`},
+		{"auto", (*Parser).parseVarDecl, `auto a;`, `auto ;`},
+		{"while", (*Parser).parseWhile, `while (a) ;`, `while (a`},
+	}
+
+	for _, prod := range productions {
+		for _, tolerant := range []bool{false, true} {
+			parser := NewParser("name", strings.NewReader(prod.good))
+			parser.Tolerant = tolerant
+
+			if node, err := prod.fn(parser); err != nil || node == nil {
+				t.Errorf("%s: good input: %v, %v", prod.name, node, err)
+			}
+
+			parser = NewParser("name", strings.NewReader(prod.bad))
+			parser.Tolerant = tolerant
+
+			node, err := prod.fn(parser)
+			if err == nil {
+				t.Errorf("%s: bad input parsed: %v", prod.name, *node)
+			} else if !tolerant && node != nil {
+				t.Errorf("%s: node returned with error: %v", prod.name, *node)
+			} else if tolerant {
+				if node == nil {
+					t.Errorf("%s: tolerant mode returned nil node", prod.name)
+				} else if _, ok := (*node).(ErrorNode); !ok {
+					t.Errorf("%s: expected ErrorNode, got %v", prod.name, *node)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTolerantRecovery(t *testing.T) {
+	parser := NewParser("name", strings.NewReader(`
+main() {
+	a = 1;
+	b = (2 +;
+	c = 3;
+}`))
+	parser.Tolerant = true
+
+	unit, err := parser.Parse()
+	if err == nil {
+		t.Errorf("broken statement not reported")
+	}
+
+	if len(unit.Funcs) != 1 {
+		t.Fatalf("expected one function, got %v", unit.Funcs)
+	}
+
+	nodes := unit.Funcs[0].Body.(BlockNode).Nodes
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 statements, got %v", nodes)
+	}
+
+	if nodes[0].String() != "a = 1;" || nodes[2].String() != "c = 3;" {
+		t.Errorf("valid statements not kept: %v", nodes)
+	}
+
+	if node, ok := nodes[1].(ErrorNode); !ok {
+		t.Errorf("expected ErrorNode, got %v", nodes[1])
+	} else if node.Text != "b = (2 +;" || len(node.Tokens) != 6 {
+		t.Errorf("skipped wrong text: %q, %v", node.Text, node.Tokens)
+	}
+
+	// Without tolerant mode nothing is recovered
+	if _, err := NewParser("", strings.NewReader(`f() { b = (2 +; }`)).Parse(); err == nil {
+		t.Errorf("broken statement parsed")
+	}
+}
+
+func TestParseAssociativity(t *testing.T) {
+	a, b, c := IdentNode{Value: "a"}, IdentNode{Value: "b"}, IdentNode{Value: "c"}
+
+	tests := []struct {
+		src  string
+		tree Node
+	}{
+		{"a - b - c", BinaryNode{
+			Left:  BinaryNode{Left: a, Oper: "-", Right: b},
+			Oper:  "-",
+			Right: c}},
+		{"a = b = c", AssignNode{
+			Lhs: a,
+			Op:  "=",
+			Rhs: AssignNode{Lhs: b, Op: "=", Rhs: c}}},
+		{"a + b * c", BinaryNode{
+			Left:  a,
+			Oper:  "+",
+			Right: BinaryNode{Left: b, Oper: "*", Right: c}}},
+		{"a ? b : c ? a : b", TernaryNode{
+			Cond:      a,
+			TrueBody:  b,
+			FalseBody: TernaryNode{Cond: c, TrueBody: a, FalseBody: b}}},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !Equal(*node, test.tree) {
+			t.Errorf("%s: expected %v, got %v", test.src, test.tree, *node)
+		}
+	}
+}
+
+func TestParseAssignmentPrecedence(t *testing.T) {
+	tests := map[string]string{
+		"a = b + c":       "(a = (b + c))",
+		"a = b = c":       "(a = (b = c))",
+		"a =+ b * c":      "(a =+ (b * c))",
+		"a =- b =* c | d": "(a =- (b =* (c | d)))",
+		"a =<< b == c":    "(a =<< (b == c))",
+		"a =>> b =% c":    "(a =>> (b =% c))",
+		"a =& b =| c":     "(a =& (b =| c))",
+		"a =/ b - c - d":  "(a =/ ((b - c) - d))",
+	}
+
+	for src, expected := range tests {
+		node, err := NewParser("", strings.NewReader(src)).parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+
+		if str := withPrecedence(*node); str != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, str)
+		}
+	}
+}
+
+func TestParseTernaryGrouping(t *testing.T) {
+	tests := map[string]string{
+		"a ? b : c ? d : e":         "(a ? b : (c ? d : e))",
+		"a ? b ? c : d : e":         "(a ? (b ? c : d) : e)",
+		"a ? b ? c : d : e ? f : g": "(a ? (b ? c : d) : (e ? f : g))",
+		"a == b ? c + d : e":        "((a == b) ? (c + d) : e)",
+		"a = b ? c : d":             "(a = (b ? c : d))",
+	}
+
+	for src, expected := range tests {
+		node, err := NewParser("", strings.NewReader(src)).parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+
+		if str := withPrecedence(*node); str != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, str)
+		}
+	}
+}
+
+func TestParseElvis(t *testing.T) {
+	src := "a ?: b ?: c"
+
+	if _, err := NewParser("", strings.NewReader(src)).parseExpression(); err == nil {
+		t.Errorf("%s: parsed without AllowElvis", src)
+	}
+
+	config := ClassicConfig
+	config.AllowElvis = true
+
+	node, err := NewParserWithConfig("", strings.NewReader(src), config).parseExpression()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+
+	if str := withPrecedence(*node); str != "(a ?: (b ?: c))" {
+		t.Errorf("%s: expected (a ?: (b ?: c)), got %s", src, str)
+	}
+
+	if ter := (*node).(TernaryNode); !ter.Elvis() || !Equal(ter.Cond, IdentNode{Value: "a"}) {
+		t.Errorf("%s: expected the true branch left out, got %s", src, SExpr(ter))
+	}
+
+	// The usual form still works
+	node, err = NewParserWithConfig("", strings.NewReader("a ? b : c"), config).parseExpression()
+	if err != nil || (*node).(TernaryNode).Elvis() {
+		t.Errorf("a ? b : c: %v, %v", node, err)
+	}
+}
+
+func TestParseCommaExpressions(t *testing.T) {
+	if _, err := NewParser("", strings.NewReader("(a, b)")).ParseExpression(); err == nil {
+		t.Errorf("(a, b): parsed without CommaExpressions")
+	}
+
+	config := ModernConfig
+	config.CommaExpressions = true
+
+	tests := map[string]string{
+		"(a, b)":         "(paren (comma (ident a) (ident b)))",
+		"(a = 1, b, c)":  "(paren (comma (assign = (ident a) (int 1)) (ident b) (ident c)))",
+		"f((a, b), c)":   "(call (ident f) (paren (comma (ident a) (ident b))) (ident c))",
+		"x = (a, b) + 1": "(assign = (ident x) (binary + (paren (comma (ident a) (ident b))) (int 1)))",
+	}
+
+	for src, expected := range tests {
+		node, err := NewParserWithConfig("", strings.NewReader(src), config).ParseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if str := SExpr(node); str != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, str)
+		}
+	}
+
+	node, err := NewParserWithConfig("", strings.NewReader(
+		"for (i = 0, j = n; i < j; i++, j--) a, b;"), config).ParseStatement()
+	if err != nil {
+		t.Fatalf("for: %v", err)
+	}
+
+	expected := "(for (comma (assign = (ident i) (int 0)) (assign = (ident j) (ident n))) " +
+		"(binary < (ident i) (ident j)) " +
+		"(comma (postfix ++ (ident i)) (postfix -- (ident j))) " +
+		"(expr (comma (ident a) (ident b))))"
+	if str := SExpr(node); str != expected {
+		t.Errorf("for: expected %s, got %s", expected, str)
+	}
+}
+
+func TestParseSemicolonAfterBlock(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+main() {
+	if(x){a;}; b;
+	{ c; };
+};`))
+
+	unit, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Semicolon after block: %v", err)
+	}
+
+	nodes := unit.Funcs[0].Body.(BlockNode).Nodes
+	expected := []Node{IfNode{}, NullNode{}, StatementNode{}, BlockNode{}, NullNode{}}
+
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d statements, got %v", len(expected), nodes)
+	}
+
+	for i, node := range nodes {
+		if reflect.TypeOf(node) != reflect.TypeOf(expected[i]) {
+			t.Errorf("statement %d: expected %T, got %T", i, expected[i], node)
+		}
+	}
+}
+
+func TestParseFor(t *testing.T) {
+	parser := NewParserWithConfig("", strings.NewReader(`
+for(;;) break;
+for(i = 0; i < n; i++) { x =+ i; }
+for(; i;) ;
+`), ModernConfig)
+
+	node, err := parser.parseStatement()
+	if err != nil {
+		t.Fatalf("Empty clauses: %v", err)
+	}
+
+	expected := ForNode{Init: NullNode{}, Cond: NullNode{}, Step: NullNode{}, Body: BreakNode{}}
+	if !Equal(*node, expected) {
+		t.Errorf("Empty clauses: expected %v, got %v", expected, *node)
+	}
+
+	node, err = parser.parseStatement()
+	if err != nil {
+		t.Fatalf("Full loop: %v", err)
+	}
+
+	if str := (*node).String(); str != "for(i = 0; i < n; i++) {\n\tx =+ i;\n}" {
+		t.Errorf("Full loop: %s", str)
+	}
+
+	node, err = parser.parseStatement()
+	if err != nil {
+		t.Fatalf("Condition only: %v", err)
+	}
+
+	if str := (*node).String(); str != "for(; i;) " {
+		t.Errorf("Condition only: %s", str)
+	}
+
+	// The printed loop parses back to the same tree
+	if reparsed, err := NewParserWithConfig("", strings.NewReader((*node).String()+";"),
+		ModernConfig).parseStatement(); err != nil {
+		t.Errorf("Round trip: %v", err)
+	} else if !Equal(*reparsed, *node) {
+		t.Errorf("Round trip: expected %v, got %v", *node, *reparsed)
+	}
+
+	if _, err := NewParserWithConfig("", strings.NewReader(`for(a; b) c;`),
+		ModernConfig).parseStatement(); err == nil {
+		t.Errorf("Missing clause parsed")
+	}
+}
+
+func TestParseUnterminatedBlock(t *testing.T) {
+	_, err := NewParser("", strings.NewReader("{ a;")).ParseStatement()
+	if err == nil || !strings.Contains(err.Error(),
+		"at token: EOF: : unterminated block, opened at 1:1") {
+		t.Errorf("Expected an unterminated block error, got %v", err)
+	}
+
+	// Reported for the block left open, in tolerant mode too
+	for _, tolerant := range []bool{false, true} {
+		parser := NewParser("", strings.NewReader("f() {\n  if (a) {\n    b;\n}"))
+		parser.Tolerant = tolerant
+
+		_, err := parser.Parse()
+		if err == nil || !strings.Contains(err.Error(),
+			"unterminated block, opened at 1:5") {
+			t.Errorf("Tolerant %v: expected an unterminated block error, got %v",
+				tolerant, err)
+		}
+	}
+}
+
+func TestParseNext(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+first() return (1);
+n 2;
+second(x) { return (x); };
+`))
+
+	var names []string
+	for {
+		node, err := parser.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+
+		switch n := node.(type) {
+		case FunctionNode:
+			names = append(names, n.Name)
+		case ExternVarInitNode:
+			names = append(names, n.Name)
+		default:
+			t.Errorf("Unexpected declaration: %v", node)
+		}
+	}
+
+	if expected := []string{"first", "n", "second"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	if _, err := parser.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF again, got %v", err)
+	}
+}
+
+func TestParseBadFirstToken(t *testing.T) {
+	const msg = "Lex error on line: 1, character: 2: unexpected character: @"
+
+	// Constructing the parser doesn't throw the error
+	parser := NewParser("", strings.NewReader("@main() {}"))
+
+	unit, err := parser.Parse()
+	if _, ok := err.(*LexError); !ok || err.Error() != msg {
+		t.Errorf("Expected %q, got %v", msg, err)
+	} else if len(unit.Funcs) != 0 || len(unit.Vars) != 0 {
+		t.Errorf("Expected an empty unit, got %v", unit)
+	}
+
+	if _, err := parser.Next(); err == nil || err.Error() != msg {
+		t.Errorf("Next: expected %q, got %v", msg, err)
+	}
+
+	entries := map[string]func(*Parser) (Node, error){
+		"ParseExpression": (*Parser).ParseExpression,
+		"ParseStatement":  (*Parser).ParseStatement,
+	}
+
+	for name, entry := range entries {
+		_, err := entry(NewParser("", strings.NewReader("@ + 1;")))
+		if _, ok := err.(*LexError); !ok {
+			t.Errorf("%s: expected a lex error, got %v", name, err)
+		}
+	}
+
+	// A lex error after the first token is still returned from Parse
+	if _, err := NewParser("", strings.NewReader("main() { @ }")).Parse(); err == nil {
+		t.Errorf("Expected a lex error after the first token")
+	}
+}
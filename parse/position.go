@@ -0,0 +1,69 @@
+package parse
+
+import (
+	"text/scanner"
+	"unicode/utf8"
+)
+
+// Return the line and column of a byte offset into src, counted the same
+// way as token positions: both from 1, with columns in characters. As
+// with token offsets, the offset is into src with \r\n and lone \r line
+// endings read as \n. An offset outside of src gives an invalid position.
+func OffsetToPosition(src []byte, offset int) scanner.Position {
+	src = []byte(normalizeNewlines(string(src)))
+
+	if offset < 0 || offset > len(src) {
+		return scanner.Position{}
+	}
+
+	pos := scanner.Position{Offset: offset, Line: 1, Column: 1}
+
+	for i := 0; i < offset; {
+		r, size := utf8.DecodeRune(src[i:])
+		i += size
+
+		if r == '\n' {
+			pos.Line += 1
+			pos.Column = 1
+		} else {
+			pos.Column += 1
+		}
+	}
+
+	return pos
+}
+
+// Return the byte offset into src of a line and column, the inverse of
+// OffsetToPosition. Returns -1 if src has no such position.
+func PositionToOffset(src []byte, pos scanner.Position) int {
+	src = []byte(normalizeNewlines(string(src)))
+
+	line, column := 1, 1
+
+	for i := 0; i <= len(src); {
+		if line == pos.Line && column == pos.Column {
+			return i
+		}
+
+		if i == len(src) {
+			break
+		}
+
+		r, size := utf8.DecodeRune(src[i:])
+		i += size
+
+		if r == '\n' {
+			if line == pos.Line {
+				// Column is past the end of the line
+				break
+			}
+
+			line += 1
+			column = 1
+		} else {
+			column += 1
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,125 @@
+package parse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"text/scanner"
+)
+
+func TestOffsetToPosition(t *testing.T) {
+	src := []byte("main() {\n\tx = 'é';\n}\n")
+
+	tests := []struct {
+		offset       int
+		line, column int
+	}{
+		{0, 1, 1},
+		{4, 1, 5},
+		{8, 1, 9},  // newline ends line 1
+		{9, 2, 1},  // first character after a newline
+		{10, 2, 2}, // tab counts as one column
+		{17, 2, 8}, // after the two byte 'é'
+		{20, 3, 1},
+		{22, 4, 1}, // end of input
+	}
+
+	for _, test := range tests {
+		pos := OffsetToPosition(src, test.offset)
+		if pos.Line != test.line || pos.Column != test.column {
+			t.Errorf("offset %d: expected %d:%d, got %d:%d", test.offset,
+				test.line, test.column, pos.Line, pos.Column)
+		}
+
+		if offset := PositionToOffset(src, pos); offset != test.offset {
+			t.Errorf("%d:%d: expected offset %d, got %d", pos.Line,
+				pos.Column, test.offset, offset)
+		}
+	}
+
+	if pos := OffsetToPosition(src, len(src)+1); pos.IsValid() {
+		t.Errorf("offset past end gave valid position %v", pos)
+	}
+
+	bad := []scanner.Position{{Line: 1, Column: 10}, {Line: 5, Column: 1},
+		{Line: 0, Column: 0}}
+	for _, pos := range bad {
+		if offset := PositionToOffset(src, pos); offset != -1 {
+			t.Errorf("%d:%d: expected no offset, got %d", pos.Line,
+				pos.Column, offset)
+		}
+	}
+}
+
+// Positions computed from offsets agree with the lexer's, whatever the
+// line endings
+func TestOffsetToPositionTokens(t *testing.T) {
+	for _, src := range []string{
+		"a = 1;\n  /* c */ b =+ 'x';\n",
+		"a = 1;\r\n  /* c */ b =+ 'x';\r\n\r\nc;",
+		"a = 1;\r  /* c */ b =+ 'x';\r",
+	} {
+		lex := NewLexer("", strings.NewReader(src))
+
+		tokens, err := lex.Tokenize()
+		if err != nil {
+			t.Fatalf("tokenize: %v", err)
+		}
+
+		for _, tok := range tokens {
+			pos := OffsetToPosition([]byte(src), tok.start.Offset)
+			if pos.Line != tok.start.Line || pos.Column != tok.start.Column {
+				t.Errorf("%q: %v: lexer says %d:%d, got %d:%d", src, tok,
+					tok.start.Line, tok.start.Column, pos.Line, pos.Column)
+			}
+
+			if offset := PositionToOffset([]byte(src), tok.start); offset != tok.start.Offset {
+				t.Errorf("%q: %v: expected offset %d, got %d", src, tok,
+					tok.start.Offset, offset)
+			}
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	src := "f() return (1);\ng(x) { x = -x + f(); }\n"
+
+	unit, err := NewParser("", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	fn := unit.Funcs[1]
+	if pos := fn.Position(); pos.Line != 2 || pos.Column != 1 || pos.Offset != 16 {
+		t.Errorf("Second function at %v", pos)
+	}
+
+	// Every node parsed from the source knows where it starts
+	var nodes []string
+	Walk(fn.Body, func(n Node) bool {
+		pos := n.Position()
+		nodes = append(nodes, fmt.Sprintf("%T %d:%d", n, pos.Line, pos.Column))
+		return true
+	})
+
+	expected := []string{
+		"parse.BlockNode 2:6",
+		"parse.StatementNode 2:8",
+		"parse.AssignNode 2:8",
+		"parse.IdentNode 2:8",
+		"parse.BinaryNode 2:12",
+		"parse.UnaryNode 2:12",
+		"parse.IdentNode 2:13",
+		"parse.FunctionCallNode 2:17",
+		"parse.IdentNode 2:17",
+	}
+
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("Expected %v, got %v", expected, nodes)
+	}
+
+	if str := fn.String(); str != "g(x) {\n\tx = -x + f();\n}" {
+		t.Errorf("String changed: %q", str)
+	}
+}
@@ -0,0 +1,55 @@
+package parse
+
+import (
+	"strings"
+)
+
+// Gathers lines of input until they make up a whole statement, for a
+// read-eval-print loop
+type StatementBuffer struct {
+	config Config
+	lines  []string
+}
+
+func NewStatementBuffer(config Config) *StatementBuffer {
+	return &StatementBuffer{config: config}
+}
+
+// Add a line of input and parse everything given since the last
+// statement. Returns the statement once the input is complete. While
+// more is needed the error satisfies IsUnexpectedEOF and the input is
+// kept. On any other error the input is discarded.
+func (b *StatementBuffer) Feed(line string) (node Node, err error) {
+	b.lines = append(b.lines, line)
+	src := strings.Join(b.lines, "\n")
+
+	node, err = b.parse(src)
+
+	// The next line starts with a newline, which ends a string or
+	// character literal as surely as its closing quote
+	if IsUnexpectedEOF(err) {
+		if _, nextErr := b.parse(src + "\n"); !IsUnexpectedEOF(nextErr) {
+			err = nextErr
+		}
+	}
+
+	if !IsUnexpectedEOF(err) {
+		b.Reset()
+	}
+
+	return node, err
+}
+
+func (b *StatementBuffer) parse(src string) (Node, error) {
+	return NewParserWithConfig("", strings.NewReader(src), b.config).ParseStatement()
+}
+
+// Whether some input is waiting to be completed
+func (b *StatementBuffer) Pending() bool {
+	return len(b.lines) > 0
+}
+
+// Throw away the input given since the last statement
+func (b *StatementBuffer) Reset() {
+	b.lines = nil
+}
@@ -0,0 +1,42 @@
+package parse
+
+import (
+	"testing"
+)
+
+func TestStatementBuffer(t *testing.T) {
+	buf := NewStatementBuffer(ClassicConfig)
+
+	node, err := buf.Feed("1 + 2;")
+	if err != nil || !Equal(node, StatementNode{Expr: BinaryNode{
+		Left: IntegerNode{Value: 1}, Oper: "+", Right: IntegerNode{Value: 2}}}) {
+		t.Errorf("Expected 1 + 2, got %v, %v", node, err)
+	}
+
+	// Incomplete input is held on to until it's finished
+	for _, lines := range [][]string{
+		{"x = 1 +", "", "2;"},
+		{"if (x) {", "  y;", "}"},
+		{"/* a multi", "line comment */ x;"},
+	} {
+		for _, line := range lines[:len(lines)-1] {
+			if _, err := buf.Feed(line); !IsUnexpectedEOF(err) || !buf.Pending() {
+				t.Errorf("%q: expected unexpected EOF, got %v", line, err)
+			}
+		}
+
+		if node, err := buf.Feed(lines[len(lines)-1]); err != nil || buf.Pending() {
+			t.Errorf("%q: expected a statement, got %v, %v", lines, node, err)
+		}
+	}
+
+	// Other errors throw the input away
+	buf.Feed("while (x")
+	if _, err := buf.Feed("y;"); err == nil || IsUnexpectedEOF(err) || buf.Pending() {
+		t.Errorf("Expected a syntax error, got %v", err)
+	}
+
+	if _, err := buf.Feed(`"abc`); err == nil || IsUnexpectedEOF(err) || buf.Pending() {
+		t.Errorf("Expected a lex error, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package parse
+
+import (
+	"fmt"
+)
+
+// Names visible at some point in a program, each mapped to the node that
+// declares it, with a link to the enclosing scope
+type Scope struct {
+	Outer *Scope
+	names map[string]Node
+}
+
+func NewScope(outer *Scope) *Scope {
+	return &Scope{Outer: outer, names: map[string]Node{}}
+}
+
+// Add a name to this scope. Returns false if it is already declared
+// here; names in outer scopes may be shadowed.
+func (s *Scope) Declare(name string, decl Node) bool {
+	if _, ok := s.names[name]; ok {
+		return false
+	}
+
+	s.names[name] = decl
+	return true
+}
+
+// Find the declaration of a name in this scope or any enclosing one
+func (s *Scope) Lookup(name string) (Node, bool) {
+	for ; s != nil; s = s.Outer {
+		if decl, ok := s.names[name]; ok {
+			return decl, true
+		}
+	}
+
+	return nil, false
+}
+
+// Check that every name used in the unit is declared, returning all the
+// errors found. The global scope holds the unit's functions and external
+// variables, plus the builtins. Each function's scope holds its
+// parameters, autos, extrns and labels. As in C, a name which is only
+// ever called is taken to be a function defined elsewhere.
+func Analyze(unit TranslationUnit) []error {
+	var errors []error
+
+	builtins := unit.Builtins
+	if builtins == nil {
+		builtins = Builtins
+	}
+
+	global := NewScope(nil)
+
+	for name := range builtins {
+		global.Declare(name, IdentNode{Value: name})
+	}
+
+	for _, v := range unit.Vars {
+		switch v := v.(type) {
+		case ExternVarInitNode:
+			global.Declare(v.Name, v)
+		case ExternVecInitNode:
+			global.Declare(v.Name, v)
+		}
+	}
+
+	for _, fn := range unit.Funcs {
+		global.Declare(fn.Name, fn)
+	}
+
+	for _, fn := range unit.Funcs {
+		scope := NewScope(global)
+
+		for _, param := range fn.Params {
+			if !scope.Declare(param, fn) {
+				errors = append(errors, NewSemanticError(fn,
+					fmt.Sprintf("parameter %s declared twice", param)))
+			}
+		}
+
+		// Declarations apply to the whole function, so collect them
+		// before looking at any uses
+		Walk(fn.Body, func(n Node) bool {
+			switch n := n.(type) {
+			case ExternVarDeclNode:
+				for _, name := range n.Names {
+					scope.Declare(name, n)
+				}
+			case LabelNode:
+				scope.Declare(n.Name, n)
+			case VarDeclNode:
+				seen := map[string]bool{}
+				for _, decl := range n.Vars {
+					if seen[decl.Name] {
+						errors = append(errors, NewSemanticError(n,
+							fmt.Sprintf("%s declared twice", decl.Name)))
+					}
+					seen[decl.Name] = true
+					scope.Declare(decl.Name, n)
+				}
+			}
+			return true
+		})
+
+		reported := map[string]bool{}
+
+		var check func(Node) bool
+		check = func(n Node) bool {
+			switch n := n.(type) {
+			case FunctionCallNode:
+				// Skip a named callee, but not the arguments
+				if _, ok := n.Callable.(IdentNode); ok {
+					for _, arg := range n.Args {
+						Walk(arg, check)
+					}
+					return false
+				}
+			case IdentNode:
+				if _, ok := scope.Lookup(n.Value); !ok && !reported[n.Value] {
+					reported[n.Value] = true
+					errors = append(errors,
+						NewSemanticError(n, "undeclared identifier"))
+				}
+			}
+			return true
+		}
+
+		Walk(fn.Body, check)
+
+		errors = append(errors, CheckBreaks(fn)...)
+		errors = append(errors, CheckLvalues(fn)...)
+
+		Walk(fn.Body, func(n Node) bool {
+			if s, ok := n.(SwitchNode); ok {
+				errors = append(errors, CheckSwitchWithConfig(s, unit.Config)...)
+			}
+			return true
+		})
+	}
+
+	return errors
+}
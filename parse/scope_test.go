@@ -0,0 +1,100 @@
+package parse
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+limit 10;
+main(argc) {
+	extrn limit;
+	auto i, v[2];
+	i = argc + limit;
+	v[0] = &main;
+	putchar(i);
+	helper(i, v);
+loop:
+	i = loop;
+}
+shadow(limit) {
+	auto main;
+	main = limit;
+}
+bad(a) {
+	auto x, y, x;
+	x = a + missing + y;
+	missing = 1;
+	f(other);
+	g(h(x), *(other + 1));
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	errors := Analyze(unit)
+
+	expected := []string{
+		"x declared twice",
+		"`missing`: undeclared identifier",
+		"`other`: undeclared identifier",
+	}
+
+	if len(errors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errors)
+	}
+
+	for i, err := range errors {
+		if !strings.Contains(err.Error(), expected[i]) {
+			t.Errorf("Expected %q, got %v", expected[i], err)
+		}
+	}
+}
+
+func TestAnalyzeExamples(t *testing.T) {
+	for _, test := range tests {
+		file, err := os.Open("../examples/" + test)
+		if err != nil {
+			t.Fatalf("failed to open test: %s", err)
+		}
+
+		unit, err := NewParser(test, file).Parse()
+		file.Close()
+
+		if err != nil {
+			t.Fatalf("%s failed to parse: %v", test, err)
+		}
+
+		if errors := Analyze(unit); len(errors) != 0 {
+			t.Errorf("%s: %v", test, errors)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	outer := NewScope(nil)
+	inner := NewScope(outer)
+
+	if !outer.Declare("a", IntegerNode{Value: 1}) || outer.Declare("a", IntegerNode{Value: 2}) {
+		t.Errorf("Redeclaration in one scope allowed")
+	}
+
+	if !inner.Declare("a", IntegerNode{Value: 3}) {
+		t.Errorf("Shadowing not allowed")
+	}
+
+	if decl, ok := inner.Lookup("a"); !ok || decl != (IntegerNode{Value: 3}) {
+		t.Errorf("Lookup found %v", decl)
+	}
+
+	if decl, ok := outer.Lookup("a"); !ok || decl != (IntegerNode{Value: 1}) {
+		t.Errorf("Lookup found %v", decl)
+	}
+
+	if _, ok := inner.Lookup("b"); ok {
+		t.Errorf("Undeclared name found")
+	}
+}
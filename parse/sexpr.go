@@ -0,0 +1,162 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Render n as an S-expression, such as `(binary + (int 2) (int 3))` for
+// `2 + 3`. Unlike String, every node is spelled out with its type, so
+// the shape of the tree is plain, for debugging and comparing trees in
+// tests. Leaves which are missing, such as a left out for clause, are
+// `(null)`.
+func SExpr(n Node) string {
+	switch node := n.(type) {
+	case nil:
+		return "()"
+
+	case AnyNode:
+		return "(any)"
+
+	case ArrayAccessNode:
+		return sexprList("index", SExpr(node.Array), SExpr(node.Index))
+
+	case AssignNode:
+		return sexprList("assign", node.Op, SExpr(node.Lhs), SExpr(node.Rhs))
+
+	case BinaryNode:
+		return sexprList("binary", node.Oper, SExpr(node.Left), SExpr(node.Right))
+
+	case BlockNode:
+		return sexprList("block", sexprs(node.Nodes)...)
+
+	case BreakNode:
+		return "(break)"
+
+	case ContinueNode:
+		return "(continue)"
+
+	case CaseNode:
+		return sexprList("case", append([]string{SExpr(node.Cond)},
+			sexprs(node.Statements)...)...)
+
+	case CharacterNode:
+		return sexprList("char", node.String())
+
+	case CommaNode:
+		return sexprList("comma", sexprs(node.Exprs)...)
+
+	case ErrorNode:
+		return sexprList("error", fmt.Sprintf("%q", node.Msg))
+
+	case ExternVarDeclNode:
+		return sexprList("extrn", node.Names...)
+
+	case ExternVarInitNode:
+		return sexprList("global", node.Name, SExpr(node.Value))
+
+	case ExternVecInitNode:
+		return sexprList("vector", append([]string{node.Name,
+			fmt.Sprintf("%d", node.Size)}, sexprs(node.Values)...)...)
+
+	case ForNode:
+		return sexprList("for", SExpr(node.Init), SExpr(node.Cond),
+			SExpr(node.Step), SExpr(node.Body))
+
+	case FunctionNode:
+		return sexprList("function", node.Name, sexprList("params", node.Params...),
+			SExpr(node.Body))
+
+	case FunctionCallNode:
+		return sexprList("call", append([]string{SExpr(node.Callable)},
+			sexprs(node.Args)...)...)
+
+	case GotoNode:
+		return sexprList("goto", SExpr(node.Label))
+
+	case IdentNode:
+		return sexprList("ident", node.Value)
+
+	case IfNode:
+		if node.HasElse {
+			return sexprList("if", SExpr(node.Cond), SExpr(node.Body),
+				SExpr(node.ElseBody))
+		}
+		return sexprList("if", SExpr(node.Cond), SExpr(node.Body))
+
+	case IntegerNode:
+		return sexprList("int", fmt.Sprintf("%d", node.Value))
+
+	case LabelNode:
+		return sexprList("label", node.Name)
+
+	case NullNode:
+		return "(null)"
+
+	case ParenNode:
+		return sexprList("paren", SExpr(node.Node))
+
+	case ReturnNode:
+		return sexprList("return", SExpr(node.Node))
+
+	case StatementNode:
+		return sexprList("expr", SExpr(node.Expr))
+
+	case StringNode:
+		return sexprList("string", node.String())
+
+	case SwitchNode:
+		parts := []string{SExpr(node.Cond)}
+		for _, c := range node.Cases {
+			parts = append(parts, SExpr(c))
+		}
+
+		if node.DefaultCase != nil {
+			parts = append(parts, sexprList("default", sexprs(node.DefaultCase)...))
+		}
+
+		return sexprList("switch", parts...)
+
+	case TernaryNode:
+		return sexprList("ternary", SExpr(node.Cond), SExpr(node.TrueBody),
+			SExpr(node.FalseBody))
+
+	case UnaryNode:
+		if node.Postfix {
+			return sexprList("postfix", node.Oper, SExpr(node.Node))
+		}
+		return sexprList("unary", node.Oper, SExpr(node.Node))
+
+	case VarDeclNode:
+		var parts []string
+
+		for _, decl := range node.Vars {
+			if decl.VecDecl {
+				parts = append(parts, sexprList("vector", append([]string{decl.Name,
+					fmt.Sprintf("%d", decl.Size)}, sexprs(decl.Values)...)...))
+			} else {
+				parts = append(parts, decl.Name)
+			}
+		}
+
+		return sexprList("auto", parts...)
+
+	case WhileNode:
+		return sexprList("while", SExpr(node.Cond), SExpr(node.Body))
+	}
+
+	return fmt.Sprintf("(unknown %T)", n)
+}
+
+func sexprList(head string, parts ...string) string {
+	return "(" + strings.Join(append([]string{head}, parts...), " ") + ")"
+}
+
+func sexprs(nodes []Node) []string {
+	strs := make([]string, len(nodes))
+	for i, n := range nodes {
+		strs[i] = SExpr(n)
+	}
+
+	return strs
+}
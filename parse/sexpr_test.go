@@ -0,0 +1,70 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSExpr(t *testing.T) {
+	var tests = []struct {
+		src, expected string
+	}{
+		{"2 + 3 * 4", "(binary + (int 2) (binary * (int 3) (int 4)))"},
+		{"v[i++] =+ -x", "(assign =+ (index (ident v) (postfix ++ (ident i))) (unary - (ident x)))"},
+		{"f(a ? 'c' : \"s*n\")", `(call (ident f) (ternary (ident a) (char 'c') (string "s*n")))`},
+		{"(g)()", "(call (paren (ident g)))"},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).parseExpression()
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+
+		if sexpr := SExpr(*node); sexpr != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expected, sexpr)
+		}
+	}
+}
+
+func TestSExprStatements(t *testing.T) {
+	unit, err := NewParserWithConfig("", strings.NewReader(`
+tbl [1] 1, 2;
+n 3;
+f(a, b) {
+	auto x, v[2] 5;
+	extrn tbl;
+	for (;;) if (a) break; else return;
+	while (b) switch (a) { case 1: ; default: goto out; }
+out:
+	return (x);
+}`), ModernConfig).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var sexprs []string
+	for _, v := range unit.Vars {
+		sexprs = append(sexprs, SExpr(v))
+	}
+	sexprs = append(sexprs, SExpr(unit.Funcs[0]))
+
+	expected := []string{
+		"(vector tbl 1 (int 1) (int 2))",
+		"(global n (int 3))",
+		"(function f (params a b) (block " +
+			"(auto x (vector v 2 (int 5))) " +
+			"(extrn tbl) " +
+			"(for (null) (null) (null) (if (ident a) (break) (return (null)))) " +
+			"(while (ident b) (switch (ident a) (case (int 1) (null)) (default (goto (ident out))))) " +
+			"(label out) " +
+			"(return (paren (ident x)))))",
+	}
+
+	for i := range expected {
+		if sexprs[i] != expected[i] {
+			t.Errorf("Expected:\n%s\ngot:\n%s", expected[i], sexprs[i])
+		}
+	}
+}
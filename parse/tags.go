@@ -0,0 +1,43 @@
+package parse
+
+import (
+	"sort"
+	"text/scanner"
+)
+
+// Kinds of definitions recorded by Tags
+const (
+	TagFunction = "function"
+	TagVariable = "variable"
+)
+
+// Definition of a top level name, for jumping to it from an editor
+type Tag struct {
+	Name string
+	Kind string
+	Pos  scanner.Position
+}
+
+// Functions and global variables defined in the unit, in source order
+func Tags(unit TranslationUnit) []Tag {
+	var tags []Tag
+
+	for _, fn := range unit.Funcs {
+		tags = append(tags, Tag{fn.Name, TagFunction, fn.Pos})
+	}
+
+	for _, v := range unit.Vars {
+		switch node := v.(type) {
+		case ExternVarInitNode:
+			tags = append(tags, Tag{node.Name, TagVariable, node.Pos})
+		case ExternVecInitNode:
+			tags = append(tags, Tag{node.Name, TagVariable, node.Pos})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Pos.Offset < tags[j].Pos.Offset
+	})
+
+	return tags
+}
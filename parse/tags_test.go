@@ -0,0 +1,52 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	src := `main() {
+	return(add(1, 2));
+}
+
+limit [2] 1, 2, 3;
+
+add(a, b) return(a + b);
+`
+
+	unit, err := NewParser("name", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := []struct {
+		name, kind   string
+		line, column int
+	}{
+		{"main", TagFunction, 1, 1},
+		{"limit", TagVariable, 5, 1},
+		{"add", TagFunction, 7, 1},
+	}
+
+	tags := Tags(unit)
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected %d tags, got %v", len(expected), tags)
+	}
+
+	for i, tag := range tags {
+		exp := expected[i]
+
+		if tag.Name != exp.name || tag.Kind != exp.kind ||
+			tag.Pos.Line != exp.line || tag.Pos.Column != exp.column {
+			t.Errorf("Expected %s %s at %d:%d, got %s %s at %d:%d",
+				exp.kind, exp.name, exp.line, exp.column,
+				tag.Kind, tag.Name, tag.Pos.Line, tag.Pos.Column)
+		}
+
+		if offset := PositionToOffset([]byte(src), tag.Pos); offset != tag.Pos.Offset ||
+			!strings.HasPrefix(src[offset:], tag.Name) {
+			t.Errorf("Tag %s has wrong offset %d", tag.Name, tag.Pos.Offset)
+		}
+	}
+}
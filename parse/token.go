@@ -4,11 +4,12 @@ import (
 	"text/scanner"
 )
 
-type OperatorBinding int
+// Which way a chain of operators at the same precedence groups
+type Assoc int
 
 const (
-	opRL OperatorBinding = iota // right to left binding
-	opLR                        // left to right binding
+	RightAssoc Assoc = iota // a = b = c is a = (b = c)
+	LeftAssoc               // a - b - c is (a - b) - c
 )
 
 type TokenType int
@@ -32,20 +33,25 @@ const (
 	tkKeyword
 	tkTernary
 	tkOperator // Composite type of most operators
+	tkComment  // Only with Lexer.KeepComments
 )
 
 type Token struct {
 	kind       TokenType
 	value      string
 	start, end scanner.Position
+	trivia     string // Leading whitespace and comments, if kept
+	raw        string // String and character literals before unescaping
 }
 
 func (t *Token) Error() Token {
 	return Token{
-		kind:  tkError,
-		value: t.String(),
-		start: t.start,
-		end:   t.end,
+		kind:   tkError,
+		value:  t.String(),
+		start:  t.start,
+		end:    t.end,
+		trivia: t.trivia,
+		raw:    t.raw,
 	}
 }
 
@@ -87,6 +93,8 @@ func (t TokenType) String() string {
 		return "Operator"
 	case tkTernary:
 		return "Ternary"
+	case tkComment:
+		return "Comment"
 	}
 
 	return "UnknownType"
@@ -94,30 +102,74 @@ func (t TokenType) String() string {
 }
 
 func (t Token) String() string {
+	switch t.kind {
+	case tkString, tkCharacter:
+		// Show the literal as it was typed
+		return t.kind.String() + ": " + t.raw
+	}
+
 	return t.kind.String() + ": " + t.value
 }
 
-func OperatorPrecedence(op string) (prec int, bind OperatorBinding) {
-	switch op {
-	case "*", "/", "%":
-		return 90, opLR
-	case "+", "-":
-		return 80, opLR
-	case ">", "<", "<=", ">=":
-		return 70, opLR
-	case "==", "!=":
-		return 60, opLR
-	case "&":
-		return 50, opLR
-	case "^":
-		return 40, opLR
-	case "|":
-		return 30, opLR
-	case "?":
-		return 20, opRL
-	case "=", "=+", "=-", "=/", "=*": // etc
-		return 10, opRL
+// Binary operators, from loosest to tightest binding. B has no separate
+// logical operators: `&` and `|` serve for both.
+//
+//	=  =+ =- =* =/ =% =& =| =<< =>>   10  right
+//	?:                                20  right
+//	|                                 30  left
+//	^                                 40  left
+//	&                                 50  left
+//	== !=                             60  left
+//	< <= > >=                         70  left
+//	<< >>                             75  left
+//	+ -                               80  left
+//	* / %                             90  left
+var operators = map[string]struct {
+	prec  int
+	assoc Assoc
+}{
+	"=": {10, RightAssoc}, "=+": {10, RightAssoc}, "=-": {10, RightAssoc},
+	"=*": {10, RightAssoc}, "=/": {10, RightAssoc}, "=%": {10, RightAssoc},
+	"=&": {10, RightAssoc}, "=|": {10, RightAssoc}, "=<<": {10, RightAssoc},
+	"=>>": {10, RightAssoc},
+
+	"?": {20, RightAssoc},
+	"|": {30, LeftAssoc},
+	"^": {40, LeftAssoc},
+	"&": {50, LeftAssoc},
+
+	"==": {60, LeftAssoc}, "!=": {60, LeftAssoc},
+
+	"<": {70, LeftAssoc}, "<=": {70, LeftAssoc},
+	">": {70, LeftAssoc}, ">=": {70, LeftAssoc},
+
+	"<<": {75, LeftAssoc}, ">>": {75, LeftAssoc},
+	"+": {80, LeftAssoc}, "-": {80, LeftAssoc},
+	"*": {90, LeftAssoc}, "/": {90, LeftAssoc}, "%": {90, LeftAssoc},
+}
+
+// Return how tightly a binary operator binds, higher binding tighter. ok
+// is false for anything which isn't a binary operator, such as `!`.
+func OperatorPrecedence(op string) (prec int, ok bool) {
+	if oper, ok := operators[op]; ok {
+		return oper.prec, true
 	}
 
-	return -1, -1
+	return -1, false
+}
+
+// Return which way a chain of the binary operator op groups. Assignments
+// and the ternary group to the right, so `a = b = c + d` is
+// `a = (b = (c + d))`, and everything else to the left.
+func OperatorAssoc(op string) Assoc {
+	if oper, ok := operators[op]; ok {
+		return oper.assoc
+	}
+
+	return LeftAssoc
+}
+
+// Whether op is `=` or a compound assignment such as `=+`
+func isAssignment(op string) bool {
+	return len(op) > 0 && op[0] == '=' && op != "=="
 }
@@ -0,0 +1,23 @@
+// Package transpile translates a parsed unit into the source of another
+// language, for building with that language's tools.
+package transpile
+
+import (
+	"bytes"
+	"github.com/erik/gob/emit"
+	"github.com/erik/gob/parse"
+)
+
+// Translate the unit into C, as written by emit.CEmitter. Every value is
+// a B_AUTO word, autos become locals, extrns refer to the globals and
+// vectors are arrays of words. The result includes bstdlib.h, which
+// provides the B runtime.
+func ToC(unit parse.TranslationUnit) (string, error) {
+	var out bytes.Buffer
+
+	if err := (emit.CEmitter{}).Emit(&out, unit); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,124 @@
+package transpile
+
+import (
+	"github.com/erik/gob/parse"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const factorialSrc = `
+calls 0;
+table[2] 1, 1;
+
+fact(n) {
+  extrn calls, table;
+  auto v[1];
+  calls++;
+  if (n < 2)
+    return (table[n]);
+  v[1] = n;
+  return (v[1] * fact(n - 1));
+}
+
+set(p, x) {
+  *p = x;
+  return (p[0]);
+}
+
+pointers() {
+  auto x, v[2], p, s;
+  set(&x, 5);
+  v[0] = 1;
+  v[2] = 7;
+  p = v;
+  s = "hi";
+  return (x + p[2] + *p + char(s, 1));
+}
+
+v [2] 1, 2, 3;
+greeting "hi";
+
+sum(p, n) {
+  auto s;
+  s = 0;
+  while (n--)
+    s =+ *p++;
+  return (s);
+}
+
+walk() {
+  extrn v, greeting, other;
+  auto w[3] 4, 5, 6, 7;
+  return (sum(v, 3) + sum(w, 4) + sum(&w[1], 2) + char(greeting, 1) + other);
+}
+`
+
+func TestToC(t *testing.T) {
+	unit, err := parse.NewParser("", strings.NewReader(factorialSrc)).Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	out, err := ToC(unit)
+	if err != nil {
+		t.Fatalf("Failed to translate: %v", err)
+	}
+
+	for _, expected := range []string{
+		"static B_AUTO calls = 0;",
+		"static B_AUTO table[3] = {1, 1};",
+		"static B_AUTO fact(B_AUTO n) {",
+		"B_AUTO v[2];",
+		"calls++;",
+		"if (n < 2)",
+		"return (v[1] * fact(n - 1));",
+		"*B_PTR(p) = x;",
+		"return (B_PTR(p)[0]);",
+		"set(B_ADDR(&x), 5);",
+		"p = B_ADDR(v);",
+		"s = B_ADDR(B_STRING_0);",
+		"extern B_AUTO other;",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out)
+		}
+	}
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+
+	dir, err := ioutil.TempDir("", "gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"bstdlib.h": "#include <stdint.h>\ntypedef intptr_t B_AUTO;\n",
+		// other stands in for a variable of another unit
+		"out.c": out + "\nB_AUTO other = 3;\n" +
+			"int main(void) { return fact(5) == 120 && calls == 5 && " +
+			"pointers() == 118 && walk() == 147 ? 0 : 1; }\n",
+	}
+
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin := filepath.Join(dir, "out")
+	if out, err := exec.Command(cc, "-Werror", "-o", bin, filepath.Join(dir, "out.c")).CombinedOutput(); err != nil {
+		t.Fatalf("Compile failed: %v\n%s", err, out)
+	}
+
+	if err := exec.Command(bin).Run(); err != nil {
+		t.Errorf("fact(5), pointers() or walk() returned the wrong value: %v", err)
+	}
+}